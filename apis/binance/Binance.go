@@ -8,6 +8,7 @@ import (
 	"midas/common"
 	"strconv"
 	"encoding/json"
+	"time"
 )
 
 const (
@@ -21,20 +22,41 @@ const (
 	USER_DATA_STREAM_URI   = "userDataStream"
 	ACCOUNT_URI 		   = "account"
 	ORDER_URI 			   = "order"
+	OPEN_ORDERS_URI 	   = "openOrders"
 	EXCHANGE_INFO_URI 	   = "exchangeInfo"
 
 	MIN_DEPTH = 5
 	MAX_DEPTH = 100
 )
 
-func GetAllTickers() (*common.TickersMap, error) {
+// Request weights as documented by Binance
+const (
+	ALL_TICKERS_REQUEST_WEIGHT = 2
+)
+
+func GetDepthRequestWeight(size int) int64 {
+	switch {
+	case size <= 100:
+		return 1
+	case size <= 500:
+		return 5
+	case size <= 1000:
+		return 10
+	default:
+		return 50
+	}
+}
+
+// 0 timeout means no timeout
+func GetAllTickers(timeout time.Duration) (*common.TickersMap, error) {
 	tickersUri := API_V1 + TICKERS_URI
-	respData, err := network.NewHttpRequest(
+	respData, err := network.NewHttpRequestWithTimeout(
 		"GET",
 		tickersUri,
 		nil,
 		false,
-		false)
+		false,
+		timeout)
 	if err != nil {
 		log.Println("GetAllTickers error:", err)
 		return nil, err
@@ -53,23 +75,40 @@ func GetAllTickers() (*common.TickersMap, error) {
 	for _, tickerInterface := range tickerList {
 		tickerMap := tickerInterface.(map[string]interface {})
 		pairSymbol := tickerMap["symbol"].(string)
-		bidPrice, _ := strconv.ParseFloat(tickerMap["bidPrice"].(string), 64)
-		askPrice, _ := strconv.ParseFloat(tickerMap["askPrice"].(string), 64)
-		bidQty, _ := strconv.ParseFloat(tickerMap["bidQty"].(string), 64)
-		askQty, _ := strconv.ParseFloat(tickerMap["askQty"].(string), 64)
-		tickers[pairSymbol] = &common.Ticker{
-			Symbol:   pairSymbol,
-			BidPrice: bidPrice,
-			AskPrice: askPrice,
-			BidQty:   bidQty,
-			AskQty:   askQty,
+		ticker, err := parseTicker(pairSymbol, tickerMap["bidPrice"], tickerMap["bidQty"], tickerMap["askPrice"], tickerMap["askQty"])
+		if err != nil {
+			log.Println("GetAllTickers error:", err)
+			continue
 		}
+		tickers[pairSymbol] = ticker
 	}
 
 	return &tickers, nil
 }
 
-func GetDepth(size int, currencyPair string) (*common.Depth, error) {
+// Ticker with a value which is not a decimal or does not fit is rejected rather than read as zero
+func parseTicker(symbol string, bidPrice interface{}, bidQty interface{}, askPrice interface{}, askQty interface{}) (*common.Ticker, error) {
+	fields := []interface{}{bidPrice, bidQty, askPrice, askQty}
+	values := make([]common.Decimal, len(fields))
+	for i, field := range fields {
+		value, err := common.ParseDecimal(field)
+		if err != nil {
+			return nil, errors.New("Bad ticker " + symbol + ": " + err.Error())
+		}
+		values[i] = value
+	}
+
+	return &common.Ticker{
+		Symbol:   symbol,
+		BidPrice: values[0],
+		BidQty:   values[1],
+		AskPrice: values[2],
+		AskQty:   values[3],
+	}, nil
+}
+
+// 0 timeout means no timeout
+func GetDepth(size int, currencyPair string, timeout time.Duration) (*common.Depth, error) {
 	if size > MAX_DEPTH {
 		size = MAX_DEPTH
 	} else if size < MIN_DEPTH {
@@ -78,12 +117,13 @@ func GetDepth(size int, currencyPair string) (*common.Depth, error) {
 
 	apiUrl := fmt.Sprintf(API_V1+DEPTH_URI, currencyPair, size)
 
-	respData, err := network.NewHttpRequest(
+	respData, err := network.NewHttpRequestWithTimeout(
 		"GET",
 		apiUrl,
 		nil,
 		false,
-		false)
+		false,
+		timeout)
 	if err != nil {
 		log.Println("GetDepth error:", err)
 		return nil, err
@@ -110,24 +150,40 @@ func GetDepth(size int, currencyPair string) (*common.Depth, error) {
 	depth.LastUpdateId = lastUpdateId
 
 	for _, bid := range bids {
-		_bid := bid.([]interface{})
-		amount := common.ToFloat64(_bid[1])
-		price := common.ToFloat64(_bid[0])
-		dr := common.DepthRecord{Amount: amount, Price: price}
+		dr, err := parseDepthRecord(bid.([]interface{}))
+		if err != nil {
+			log.Println("GetDepth error:", err)
+			return nil, err
+		}
 		depth.BidList = append(depth.BidList, dr)
 	}
 
 	for _, ask := range asks {
-		_ask := ask.([]interface{})
-		amount := common.ToFloat64(_ask[1])
-		price := common.ToFloat64(_ask[0])
-		dr := common.DepthRecord{Amount: amount, Price: price}
+		dr, err := parseDepthRecord(ask.([]interface{}))
+		if err != nil {
+			log.Println("GetDepth error:", err)
+			return nil, err
+		}
 		depth.AskList = append(depth.AskList, dr)
 	}
 
 	return depth, nil
 }
 
+// Book with a level which can not be read is rejected, as skipping the level would misstate liquidity
+func parseDepthRecord(record []interface{}) (common.DepthRecord, error) {
+	price, err := common.ParseDecimal(record[0])
+	if err != nil {
+		return common.DepthRecord{}, errors.New("Bad depth price: " + err.Error())
+	}
+	amount, err := common.ParseDecimal(record[1])
+	if err != nil {
+		return common.DepthRecord{}, errors.New("Bad depth amount: " + err.Error())
+	}
+
+	return common.DepthRecord{Amount: amount, Price: price}, nil
+}
+
 func GetUserDataStreamListenKey() (*string, error) {
 	uri := API_V1 + USER_DATA_STREAM_URI
 	respData, err := network.NewHttpRequest(
@@ -222,8 +278,8 @@ func GetAccount() (*common.Account, error) {
 		Balances: make(map[string]*common.Balance),
 	}
 	for _, b := range rawAccount.Balances {
-		f := common.ToFloat64(b.Free)
-		l := common.ToFloat64(b.Locked)
+		f := common.ToDecimal(b.Free)
+		l := common.ToDecimal(b.Locked)
 
 		acc.Balances[b.Asset] = &common.Balance{
 			CoinSymbol: b.Asset,
@@ -240,8 +296,8 @@ func NewOrder(
 	symbol string,
 	side common.OrderSide,
 	orderType common.OrderType,
-	quantity float64,
-	price float64,
+	quantity common.Decimal,
+	price common.Decimal,
 	clientOrderId string,
 	timestamp int64,
 	test bool,
@@ -258,9 +314,9 @@ func NewOrder(
 	if orderType == common.TypeLimit {
 		params["timeInForce"] = string(common.IOC)
 	}
-	params["quantity"] = strconv.FormatFloat(quantity, 'f', -1, 64)
+	params["quantity"] = quantity.String()
 	if orderType == common.TypeLimit {
-		params["price"] = strconv.FormatFloat(price, 'f', -1, 64)
+		params["price"] = price.String()
 	}
 	params["timestamp"] = strconv.FormatInt(timestamp, 10)
 	if clientOrderId != "" {
@@ -316,10 +372,10 @@ func NewOrder(
 		rawResponse.OrderID,
 		rawResponse.ClientOrderID,
 		common.TimeFromUnixTimestampFloat(rawResponse.TransactTime),
-		common.ToFloat64(rawResponse.Price),
-		common.ToFloat64(rawResponse.OrigQty),
-		common.ToFloat64(rawResponse.ExecutedQty),
-		common.ToFloat64(rawResponse.CumulativeQuoteQty),
+		common.ToDecimal(rawResponse.Price),
+		common.ToDecimal(rawResponse.OrigQty),
+		common.ToDecimal(rawResponse.ExecutedQty),
+		common.ToDecimal(rawResponse.CumulativeQuoteQty),
 		common.OrderStatus(rawResponse.Status),
 		common.TimeInForce(rawResponse.TimeInForce),
 		common.OrderType(rawResponse.Type),
@@ -329,9 +385,9 @@ func NewOrder(
 
 	for _, f := range rawResponse.Fills {
 		executedOrder.Fills = append(executedOrder.Fills, &common.Fill{
-			common.ToFloat64(f.Price),
-			common.ToFloat64(f.Qty),
-			common.ToFloat64(f.Commission),
+			common.ToDecimal(f.Price),
+			common.ToDecimal(f.Qty),
+			common.ToDecimal(f.Commission),
 			f.CommissionAsset,
 		})
 	}
@@ -339,6 +395,104 @@ func NewOrder(
 	return executedOrder, nil
 }
 
+type rawExchangeOrder struct {
+	Symbol        string `json:"symbol"`
+	OrderID       int64 `json:"orderId"`
+	ClientOrderID string `json:"clientOrderId"`
+	OrigClientOrderID string `json:"origClientOrderId"` // cancel responses only
+	Price         string `json:"price"`
+	OrigQty       string `json:"origQty"`
+	ExecutedQty   string `json:"executedQty"`
+	CumulativeQuoteQty string `json:"cummulativeQuoteQty"`
+	Status        string `json:"status"`
+	TimeInForce   string `json:"timeInForce"`
+	Type          string `json:"type"`
+	Side          string `json:"side"`
+	Time          float64 `json:"time"`
+}
+
+func (o *rawExchangeOrder) toExchangeOrder() *common.ExchangeOrder {
+	clientOrderId := o.ClientOrderID
+	if o.OrigClientOrderID != "" {
+		clientOrderId = o.OrigClientOrderID
+	}
+
+	return &common.ExchangeOrder{
+		o.Symbol,
+		o.OrderID,
+		clientOrderId,
+		common.ToDecimal(o.Price),
+		common.ToDecimal(o.OrigQty),
+		common.ToDecimal(o.ExecutedQty),
+		common.ToDecimal(o.CumulativeQuoteQty),
+		common.OrderStatus(o.Status),
+		common.TimeInForce(o.TimeInForce),
+		common.OrderType(o.Type),
+		common.OrderSide(o.Side),
+		common.TimeFromUnixTimestampFloat(o.Time),
+	}
+}
+
+// Open orders of symbol, of all symbols if symbol is empty
+func GetOpenOrders(symbol string) ([]*common.ExchangeOrder, error) {
+	params := make(map[string]string)
+	if symbol != "" {
+		params["symbol"] = symbol
+	}
+	res, err := network.NewHttpRequest(
+		"GET",
+		API_V3 + OPEN_ORDERS_URI,
+		params,
+		true,
+		true)
+	if err != nil {
+		log.Println("GetOpenOrders error:", err)
+		return nil, err
+	}
+
+	var rawOrders []*rawExchangeOrder
+	if err := json.Unmarshal(res, &rawOrders); err != nil {
+		log.Println("GetOpenOrders unmarshaling error:", err)
+		return nil, err
+	}
+
+	orders := make([]*common.ExchangeOrder, 0, len(rawOrders))
+	for _, rawOrder := range rawOrders {
+		orders = append(orders, rawOrder.toExchangeOrder())
+	}
+
+	return orders, nil
+}
+
+// Order is identified by orderId if it is set, by clientOrderId otherwise
+func CancelOrder(symbol string, orderId int64, clientOrderId string) (*common.ExchangeOrder, error) {
+	params := make(map[string]string)
+	params["symbol"] = symbol
+	if orderId != 0 {
+		params["orderId"] = strconv.FormatInt(orderId, 10)
+	} else {
+		params["origClientOrderId"] = clientOrderId
+	}
+	res, err := network.NewHttpRequest(
+		"DELETE",
+		API_V3 + ORDER_URI,
+		params,
+		true,
+		true)
+	if err != nil {
+		log.Println("CancelOrder error:", err)
+		return nil, err
+	}
+
+	rawOrder := &rawExchangeOrder{}
+	if err := json.Unmarshal(res, rawOrder); err != nil {
+		log.Println("CancelOrder unmarshaling error:", err)
+		return nil, err
+	}
+
+	return rawOrder.toExchangeOrder(), nil
+}
+
 func GetExchangeInfo() (*common.ExchangeInfo, error) {
 	exchangeInfoUri := API_V1 + EXCHANGE_INFO_URI
 	res, err := network.NewHttpRequest(
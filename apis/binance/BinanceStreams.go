@@ -0,0 +1,57 @@
+package binance
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"log"
+	"midas/common"
+)
+
+const (
+	STREAM_BASE_URL = "wss://stream.binance.com:9443/ws/"
+	ALL_BOOK_TICKERS_STREAM = "!bookTicker"
+)
+
+// Connects to all book tickers stream and calls onTicker for every update until connection is closed.
+// Closing returned connection stops the stream
+func StreamAllBookTickers(onTicker func(ticker *common.Ticker)) (*websocket.Conn, error) {
+	url := STREAM_BASE_URL + ALL_BOOK_TICKERS_STREAM
+	log.Println("Connecting to book tickers stream: ", url)
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		log.Println("StreamAllBookTickers error:", err)
+		return nil, err
+	}
+
+	go func() {
+		defer c.Close()
+		for {
+			_, message, err := c.ReadMessage()
+			if err != nil {
+				log.Println("Book tickers stream closed:", err)
+				return
+			}
+
+			rawTicker := struct {
+				Symbol   string `json:"s"`
+				BidPrice string `json:"b"`
+				BidQty   string `json:"B"`
+				AskPrice string `json:"a"`
+				AskQty   string `json:"A"`
+			}{}
+			if err := json.Unmarshal(message, &rawTicker); err != nil {
+				log.Println("Book ticker unmarshaling error:", err)
+				continue
+			}
+
+			ticker, err := parseTicker(rawTicker.Symbol, rawTicker.BidPrice, rawTicker.BidQty, rawTicker.AskPrice, rawTicker.AskQty)
+			if err != nil {
+				log.Println("Book ticker error:", err)
+				continue
+			}
+			onTicker(ticker)
+		}
+	}()
+
+	return c, nil
+}
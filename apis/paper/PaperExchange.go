@@ -0,0 +1,218 @@
+package paper
+
+import (
+	"errors"
+	"midas/common"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Same message Binance rejects orders with
+const INSUFFICIENT_BALANCE_MESSAGE = "Account has insufficient balance for requested action."
+
+// Exchange which fills orders against live books without sending them anywhere, trading virtual balances.
+// Orders fill as takers right away, what book does not cover expires, so every order is IOC in effect.
+type Exchange struct {
+	balances map[string]common.Decimal
+	fee common.Decimal // relative taker fee, charged in coin order receives
+	lastOrderId int64
+	lastUpdateTs time.Time
+	mux sync.Mutex
+}
+
+func NewExchange(balances map[string]common.Decimal, fee common.Decimal) *Exchange {
+	exchange := &Exchange{
+		balances: make(map[string]common.Decimal),
+		fee: fee,
+	}
+	for coin, balance := range balances {
+		exchange.balances[coin] = balance
+	}
+
+	return exchange
+}
+
+// Book built from top of book, for symbols there is no depth for
+func DepthFromTicker(ticker *common.Ticker) *common.Depth {
+	return &common.Depth{
+		AskList: common.DepthRecords{{ticker.AskPrice, ticker.AskQty}},
+		BidList: common.DepthRecords{{ticker.BidPrice, ticker.BidQty}},
+	}
+}
+
+// Takes liquidity of book side order trades against, best price first, up to limit price for limit orders.
+// Book is left as is, so the same liquidity is there for the next order, as it would be after market makers refill it.
+func (e *Exchange) NewOrder(pair *common.CoinPair, request *common.OrderRequest, clientOrderId string, depth *common.Depth, ts time.Time) (*common.ExecutedOrderFullResponse, error) {
+	if request.Type != common.TypeMarket && request.Type != common.TypeLimit {
+		return nil, errors.New("Unsupported order type " + string(request.Type))
+	}
+	if !request.Qty.IsPositive() {
+		return nil, errors.New("Invalid quantity " + request.Qty.String())
+	}
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	fills := matchOrder(request, depth)
+	baseQty, quoteQty := common.Decimal{}, common.Decimal{}
+	notionals := make([]common.Decimal, len(fills))
+	for i, fill := range fills {
+		notional, ok := fill.Qty.Mul(fill.Price)
+		if !ok {
+			return nil, errors.New("Order amount is out of range")
+		}
+		notionals[i] = notional
+		baseQty = baseQty.Add(fill.Qty)
+		quoteQty = quoteQty.Add(notional)
+	}
+
+	spentCoin, receivedCoin := pair.BaseCoin.CoinSymbol, pair.QuoteCoin.CoinSymbol
+	spent, received := baseQty, quoteQty
+	// Limit orders lock their whole cost up front on exchange
+	required := request.Qty
+	if request.Side == common.SideBuy {
+		spentCoin, receivedCoin = receivedCoin, spentCoin
+		spent, received = quoteQty, baseQty
+		required = quoteQty
+		if request.Type == common.TypeLimit {
+			var ok bool
+			if required, ok = request.Qty.Mul(request.Price); !ok {
+				return nil, errors.New("Order amount is out of range")
+			}
+		}
+	}
+	if e.balances[spentCoin].LessThan(required) {
+		return nil, errors.New(INSUFFICIENT_BALANCE_MESSAGE)
+	}
+
+	commission := common.Decimal{}
+	for i, fill := range fills {
+		fill.Commission = fill.Qty
+		if request.Side == common.SideSell {
+			fill.Commission = notionals[i]
+		}
+		// Fee is a fraction, so commission always fits
+		fill.Commission, _ = fill.Commission.Mul(e.fee)
+		fill.CommissionAsset = receivedCoin
+		commission = commission.Add(fill.Commission)
+	}
+	e.balances[spentCoin] = e.balances[spentCoin].Sub(spent)
+	e.balances[receivedCoin] = e.balances[receivedCoin].Add(received).Sub(commission)
+	e.touch(ts)
+
+	e.lastOrderId++
+	status := common.StatusFilled
+	if baseQty.LessThan(request.Qty) {
+		status = common.StatusExpired
+	}
+	timeInForce := common.IOC
+	if request.Type == common.TypeMarket {
+		timeInForce = common.GTC
+	}
+
+	return &common.ExecutedOrderFullResponse{
+		pair.PairSymbol,
+		e.lastOrderId,
+		clientOrderId,
+		ts,
+		request.Price,
+		request.Qty,
+		baseQty,
+		quoteQty,
+		status,
+		timeInForce,
+		request.Type,
+		request.Side,
+		fills,
+	}, nil
+}
+
+// Fills order would get from book, one per level it reaches
+func matchOrder(request *common.OrderRequest, depth *common.Depth) []*common.Fill {
+	fills := make([]*common.Fill, 0)
+	if depth == nil {
+		return fills
+	}
+
+	levels := make(common.DepthRecords, 0)
+	if request.Side == common.SideBuy {
+		levels = append(levels, depth.AskList...)
+		sort.Sort(levels)
+	} else {
+		levels = append(levels, depth.BidList...)
+		sort.Sort(sort.Reverse(levels))
+	}
+
+	remaining := request.Qty
+	for _, level := range levels {
+		if !remaining.IsPositive() {
+			break
+		}
+		if request.Type == common.TypeLimit {
+			if request.Side == common.SideBuy && level.Price.GreaterThan(request.Price) {
+				break
+			}
+			if request.Side == common.SideSell && level.Price.LessThan(request.Price) {
+				break
+			}
+		}
+		if !level.Amount.IsPositive() {
+			continue
+		}
+
+		qty := level.Amount
+		if remaining.LessThan(qty) {
+			qty = remaining
+		}
+		fills = append(fills, &common.Fill{Price: level.Price, Qty: qty})
+		remaining = remaining.Sub(qty)
+	}
+
+	return fills
+}
+
+// Account update timestamps have to grow for consumers to take newer accounts
+func (e *Exchange) touch(ts time.Time) {
+	if !ts.After(e.lastUpdateTs) {
+		ts = e.lastUpdateTs.Add(time.Nanosecond)
+	}
+	e.lastUpdateTs = ts
+}
+
+// Virtual balances, as a new account each time
+func (e *Exchange) GetAccount() *common.Account {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.lastUpdateTs.IsZero() {
+		e.touch(time.Now())
+	}
+
+	account := &common.Account{
+		LastUpdateTs: e.lastUpdateTs,
+		Balances: make(map[string]*common.Balance),
+	}
+	for coin, balance := range e.balances {
+		account.Balances[coin] = &common.Balance{CoinSymbol: coin, Free: balance}
+	}
+
+	return account
+}
+
+func (e *Exchange) String() string {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	coins := make([]string, 0, len(e.balances))
+	for coin := range e.balances {
+		coins = append(coins, coin)
+	}
+	sort.Strings(coins)
+
+	out := "Paper balances:"
+	for _, coin := range coins {
+		out += " " + coin + " " + e.balances[coin].String()
+	}
+
+	return out + " | Orders: " + strconv.FormatInt(e.lastOrderId, 10)
+}
@@ -0,0 +1,104 @@
+package paper
+
+import (
+	"midas/common"
+	"testing"
+	"time"
+)
+
+var ethBtc = &common.CoinPair{PairSymbol: "ETHBTC", BaseCoin: common.Coin{CoinSymbol: "ETH"}, QuoteCoin: common.Coin{CoinSymbol: "BTC"}}
+
+func makeDepth() *common.Depth {
+	return &common.Depth{
+		AskList: common.DepthRecords{
+			{common.ToDecimal("0.031"), common.ToDecimal("2")},
+			{common.ToDecimal("0.030"), common.ToDecimal("1")},
+		},
+		BidList: common.DepthRecords{
+			{common.ToDecimal("0.029"), common.ToDecimal("1")},
+			{common.ToDecimal("0.028"), common.ToDecimal("5")},
+		},
+	}
+}
+
+func makeExchange() *Exchange {
+	return NewExchange(map[string]common.Decimal{
+		"BTC": common.ToDecimal("1"),
+		"ETH": common.ToDecimal("10"),
+	}, common.ToDecimal("0.001"))
+}
+
+func TestLimitBuyPartiallyFills(t *testing.T) {
+	exchange := makeExchange()
+	request := &common.OrderRequest{"ETHBTC", common.SideBuy, common.TypeLimit, common.ToDecimal("1.5"), common.ToDecimal("0.030")}
+
+	res, err := exchange.NewOrder(ethBtc, request, "id", makeDepth(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != common.StatusExpired || res.ExecutedQty != common.ToDecimal("1") || res.CumulativeQuoteQty != common.ToDecimal("0.03") {
+		t.Errorf("expected 1 ETH filled for 0.03 BTC and the rest expired, got %s %s for %s", res.Status, res.ExecutedQty.String(), res.CumulativeQuoteQty.String())
+	}
+	if len(res.Fills) != 1 || res.Fills[0].Commission != common.ToDecimal("0.001") || res.Fills[0].CommissionAsset != "ETH" {
+		t.Errorf("expected single fill with 0.001 ETH commission, got %+v", res.Fills)
+	}
+
+	account := exchange.GetAccount()
+	if account.Balances["BTC"].Free != common.ToDecimal("0.97") || account.Balances["ETH"].Free != common.ToDecimal("10.999") {
+		t.Errorf("expected 0.97 BTC and 10.999 ETH, got %s BTC and %s ETH", account.Balances["BTC"].Free.String(), account.Balances["ETH"].Free.String())
+	}
+}
+
+func TestMarketSellWalksBook(t *testing.T) {
+	exchange := makeExchange()
+	request := &common.OrderRequest{"ETHBTC", common.SideSell, common.TypeMarket, common.ToDecimal("3"), common.Decimal{}}
+
+	res, err := exchange.NewOrder(ethBtc, request, "id", makeDepth(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != common.StatusFilled || len(res.Fills) != 2 || res.Fills[0].Price != common.ToDecimal("0.029") {
+		t.Errorf("expected fill at 0.029 first and then at 0.028, got %s %+v", res.Status, res.Fills)
+	}
+	if res.CumulativeQuoteQty != common.ToDecimal("0.085") {
+		t.Errorf("expected 0.085 BTC received, got %s", res.CumulativeQuoteQty.String())
+	}
+}
+
+func TestLimitOrderWithoutLiquidityExpires(t *testing.T) {
+	exchange := makeExchange()
+	request := &common.OrderRequest{"ETHBTC", common.SideSell, common.TypeLimit, common.ToDecimal("1"), common.ToDecimal("0.03")}
+
+	res, err := exchange.NewOrder(ethBtc, request, "id", makeDepth(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != common.StatusExpired || !res.ExecutedQty.IsZero() || len(res.Fills) != 0 {
+		t.Errorf("expected order to expire unfilled, got %s with %s executed", res.Status, res.ExecutedQty.String())
+	}
+}
+
+func TestInsufficientBalanceIsRejected(t *testing.T) {
+	exchange := makeExchange()
+	request := &common.OrderRequest{"ETHBTC", common.SideBuy, common.TypeLimit, common.ToDecimal("100"), common.ToDecimal("0.031")}
+
+	if _, err := exchange.NewOrder(ethBtc, request, "id", makeDepth(), time.Now()); err == nil || err.Error() != INSUFFICIENT_BALANCE_MESSAGE {
+		t.Errorf("expected insufficient balance error, got %v", err)
+	}
+	if exchange.GetAccount().Balances["BTC"].Free != common.ToDecimal("1") {
+		t.Error("expected balances to be left as is")
+	}
+}
+
+func TestAccountUpdatesAreOrdered(t *testing.T) {
+	exchange := makeExchange()
+	ts := time.Now()
+	before := exchange.GetAccount()
+	request := &common.OrderRequest{"ETHBTC", common.SideBuy, common.TypeMarket, common.ToDecimal("1"), common.Decimal{}}
+	if _, err := exchange.NewOrder(ethBtc, request, "id", makeDepth(), ts.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if !exchange.GetAccount().LastUpdateTs.After(before.LastUpdateTs) {
+		t.Error("expected account after order to be newer")
+	}
+}
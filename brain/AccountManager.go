@@ -7,11 +7,16 @@ import (
 	"log"
 )
 
-var account *common.Account
-
 const ACCOUNT_UPDATE_PERIOD_MIN = 1
 
 func RunUpdateAccountInfo() {
+	// Virtual account is updated by paper orders
+	if isPaperTrading() {
+		log.Println("Paper trading | " + paperExchange.String())
+		updateAccount(paperExchange.GetAccount())
+		return
+	}
+
 	StartUserDataStream()
 	updateAccountInfo()
 	go func() {
@@ -25,8 +30,18 @@ func RunUpdateAccountInfo() {
 func updateAccountInfo() {
 	log.Println("Updating account info...")
 	acc, _ := binance.GetAccount()
-	if acc != nil && (account == nil || account.LastUpdateTs.Before(acc.LastUpdateTs)) {
-		account = acc
+	if acc != nil && updateAccount(acc) {
 		log.Println("Updating account info... Done")
 	}
 }
+
+// Both REST and user data stream deliver accounts, older one is ignored
+func updateAccount(acc *common.Account) bool {
+	return updateSnapshot(func(next *MarketSnapshot) bool {
+		if next.Account != nil && !next.Account.LastUpdateTs.Before(acc.LastUpdateTs) {
+			return false
+		}
+		next.Account = acc
+		return true
+	})
+}
@@ -22,69 +22,94 @@ const (
 var arbTriangles = make(map[string]*arb.Triangle)
 var arbCoins = make(map[string]bool)
 var arbPairs = make(map[string]bool)
+var triangleIndex = arb.NewTriangleIndex()
+
+// Symbols changed by tickers frames since last detection pass
+var changedSymbols = make(map[string]bool)
+var changedSymbolsMux sync.Mutex
+var tickersChanged = make(chan struct{}, 1)
 
 var arbStates = sync.Map{}
 
-var brainConfig = configuration.ReadBrainConfig()
+// Zero config until Configure is called, so brain package can be loaded without a config file
+var brainConfig = &configuration.BrainConfig{}
+
+// Sets config brain runs with, has to be called before anything else is run
+func Configure(config *configuration.BrainConfig) {
+	brainConfig = config
+	executionThresholds = makeExecutionThresholds(config.EXECUTION_THRESHOLDS)
+	universeConfig = config.UNIVERSE
+	universe = makeUniverseLists(config.UNIVERSE)
+	executionConfig = makeExecutionConfig(config.EXECUTION)
+	orderManagerConfig = makeOrderManagerConfig(config.ORDER_MANAGER)
+	paperExchange = makePaperExchange(config.PAPER_TRADING)
+}
+
+var stopDetection = make(chan struct{})
+var stopDetectionOnce sync.Once
+// Routines which report and detect alongside triangle detector
+var detectionWg sync.WaitGroup
 
+// Blocks until StopArbDetector is called
 func RunArbDetector() {
 	initArbDetector()
 	runReportArb()
+	runDetectCycles()
+	runDetectSpatialArb()
 	runDetectArbBLOCKING()
+	detectionWg.Wait()
+	reportAllArbStates()
+}
+
+// Blocks until detection and reporting routines exit, triangle detector returns from RunArbDetector
+func StopArbDetector() {
+	stopDetectionOnce.Do(func() {
+		log.Println("Stopping arb detector...")
+		close(stopDetection)
+	})
+	detectionWg.Wait()
 }
 
 func initArbDetector() {
 	log.Println("Initializing arb detector...")
-	if allPairs == nil {
+	pairs := getSnapshot().Pairs
+	if pairs == nil {
 		panic("Arb detector error: pairs are not fetched")
 	}
-	log.Println("Analyzing " + strconv.Itoa(len(allPairs)) + " pairs...")
+	log.Println("Analyzing " + strconv.Itoa(len(pairs)) + " pairs...")
 	tStart := time.Now()
 
-	for _, pairA := range allPairs {
-		for _,  pairB := range allPairs {
-			for _,  pairC := range allPairs {
-				if isTriangle(pairA, pairB, pairC) {
-					key, triangle := makeTriangle(pairA, pairB, pairC)
-
-					// Record arb triangle
-					if arbTriangles[key] == nil {
-						arbTriangles[key] = triangle
-					}
-
-					// Record arb coins
-					arbCoins[triangle.CoinA.CoinSymbol] = true
-					arbCoins[triangle.CoinB.CoinSymbol] = true
-					arbCoins[triangle.CoinC.CoinSymbol] = true
-
-					// Record arb pairs
-					arbPairs[(*triangle.PairAB).PairSymbol] = true
-					arbPairs[(*triangle.PairBC).PairSymbol] = true
-					arbPairs[(*triangle.PairAC).PairSymbol] = true
-				}
-			}
-		}
-	}
+	updateArbTriangles(pairs, false)
 
 	delta := time.Since(tStart)
 	log.Println("Initializing finished in " + delta.String())
+	trianglesMux.RLock()
 	log.Println("Arb triangles: " + strconv.Itoa(len(arbTriangles)))
 	log.Println("Arb pairs: " + strconv.Itoa(len(arbPairs)))
 	log.Println("Arb coins: " + strconv.Itoa(len(arbCoins)))
+	trianglesMux.RUnlock()
 	logging.LogLineToFile("Launched at " + time.Now().String(), logging.ARB_STATES_FILE_PATH)
 }
 
 func runReportArb() {
 	// Goes through all arb states and prints unreported
+	detectionWg.Add(1)
 	go func() {
+		defer detectionWg.Done()
 		log.Println("Checking existing arbs...")
 		for {
+			select {
+			case <-stopDetection:
+				return
+			default:
+			}
 			arbStates.Range(func(k, v interface{}) bool {
 				arbState := v.(*arb.State)
 				// If arb state was not updated by detector routine for more than ARB_REPORT_UPDATE_THRESHOLD_MICROS
 				// we consider arb opportunity is gone
-				if time.Since(arbState.LastUpdateTs) > time.Duration(brainConfig.ARB_REPORT_UPDATE_THRESHOLD_MICROS) * time.Microsecond {
+				if time.Since(arbState.GetLastUpdateTs()) > time.Duration(brainConfig.ARB_REPORT_UPDATE_THRESHOLD_MICROS) * time.Microsecond {
 					arbStates.Delete(k)
+					publishArbEvent(arb.EventExpired, arbState, "")
 					logging.QueueEvent(&logging.Event{
 						EventType: logging.EventTypeArbState,
 						Value: arbState,
@@ -92,25 +117,89 @@ func runReportArb() {
 				}
 				return true
 			})
+			reportStaleCycleArbStates()
 		}
 	} ()
 }
 
 
+// Reports arb states which are still open, used on shutdown
+func reportAllArbStates() {
+	arbStates.Range(func(k, v interface{}) bool {
+		arbStates.Delete(k)
+		publishArbEvent(arb.EventExpired, v.(*arb.State), "shutdown")
+		logging.QueueEvent(&logging.Event{
+			EventType: logging.EventTypeArbState,
+			Value: v.(*arb.State),
+		})
+		return true
+	})
+	reportAllCycleArbStates()
+	reportAllSpatialArbStates()
+}
+
+// Wakes detector up after tickers frame is applied, symbols are empty if frame changed nothing
+func notifyTickersChanged(symbols []string) {
+	changedSymbolsMux.Lock()
+	for _, symbol := range symbols {
+		changedSymbols[symbol] = true
+	}
+	changedSymbolsMux.Unlock()
+
+	select {
+	case tickersChanged<-struct{}{}:
+	default:
+		// Detector is already notified, symbols are merged into pending set
+	}
+}
+
+func takeChangedSymbols() []string {
+	changedSymbolsMux.Lock()
+	defer changedSymbolsMux.Unlock()
+	symbols := make([]string, 0, len(changedSymbols))
+	for symbol := range changedSymbols {
+		symbols = append(symbols, symbol)
+	}
+	changedSymbols = make(map[string]bool)
+
+	return symbols
+}
+
 func runDetectArbBLOCKING() {
 	log.Println("Looking for arb opportunities...")
 	for {
-		for _, triangle := range arbTriangles {
-			arbState := findArb(triangle)
+		select {
+		case <-stopDetection:
+			log.Println("Arb detector stopped")
+			return
+		case <-tickersChanged:
+		}
+
+		// Only triangles with changed tickers can change their arb state. Triangles of open states are
+		// evaluated too, so each frame confirms only states which still hold with its tickers.
+		trianglesMux.RLock()
+		triangles := triangleIndex.TrianglesForSymbols(takeChangedSymbols())
+		addOpenArbTriangles(triangles)
+		trianglesMux.RUnlock()
+		// Every triangle of the pass is evaluated against the same tickers and balances
+		snapshot := getSnapshot()
+		for _, triangle := range triangles {
+			arbState := findArb(snapshot, triangle)
 			// TODO build queueing system
 			if arbState != nil {
-				res, loaded := arbStates.LoadOrStore(arbState.Key, arbState)
-				if loaded {
-					arbState = res.(*arb.State)
-					arbState.LastUpdateTs = time.Now()
+				// Detector is the only routine which adds states, so state can't appear between Load and Store
+				if existing, loaded := arbStates.Load(arbState.Key); loaded {
+					arbState = existing.(*arb.State)
+					arbState.Touch(time.Now())
+					publishArbEvent(arb.EventUpdated, arbState, "")
 				} else {
+					// Valued before it is stored, other routines read it from then on
+					valueArbState(arbState)
+					arbStates.Store(arbState.Key, arbState)
 					log.Println("Detected " + arbState.Key)
+					publishArbEvent(arb.EventDetected, arbState, "")
 				}
+				recordFrameSighting(arbState, snapshot)
 
 				// TODO is it a correct place to call?
 				// TODO make sure delayed frames do not trigger trade exec
@@ -120,22 +209,40 @@ func runDetectArbBLOCKING() {
 	}
 }
 
-func findArb(triangle *arb.Triangle) *arb.State {
-	if tickersMap == nil {
-		return nil
+// Adds triangles of open arb states, unless triangle itself went away with exchange info update.
+// States which are not found again expire.
+func addOpenArbTriangles(triangles map[string]*arb.Triangle) {
+	arbStates.Range(func(k, v interface{}) bool {
+		key := v.(*arb.State).Triangle.Key
+		if triangle := arbTriangles[key]; triangle != nil {
+			triangles[key] = triangle
+		}
+		return true
+	})
+}
+
+// Fees make it impossible for both directions to be profitable at once, so at most one state is returned
+func findArb(snapshot *MarketSnapshot, triangle *arb.Triangle) *arb.State {
+	if arbState := findArbInDirection(snapshot, triangle, arb.DirectionForward); arbState != nil {
+		return arbState
 	}
 
-	tickerAB := (*tickersMap)[triangle.PairAB.PairSymbol]
-	tickerBC := (*tickersMap)[triangle.PairBC.PairSymbol]
-	tickerAC := (*tickersMap)[triangle.PairAC.PairSymbol]
+	return findArbInDirection(snapshot, triangle.Reversed(), arb.DirectionReverse)
+}
+
+// Simulates A->B->C->A of oriented triangle
+func findArbInDirection(snapshot *MarketSnapshot, triangle *arb.Triangle, direction arb.Direction) *arb.State {
+	tickerAB := snapshot.GetTicker(triangle.PairAB.PairSymbol)
+	tickerBC := snapshot.GetTicker(triangle.PairBC.PairSymbol)
+	tickerAC := snapshot.GetTicker(triangle.PairAC.PairSymbol)
 
 	if tickerAB == nil || tickerBC == nil || tickerAC == nil {
 		return nil
 	}
 
-	balanceA := account.Balances[triangle.CoinA.CoinSymbol].Free
-	balanceB := account.Balances[triangle.CoinB.CoinSymbol].Free
-	balanceC := account.Balances[triangle.CoinC.CoinSymbol].Free
+	balanceA := getAvailableBalance(snapshot, triangle.CoinA.CoinSymbol).Float64()
+	balanceB := getAvailableBalance(snapshot, triangle.CoinB.CoinSymbol).Float64()
+	balanceC := getAvailableBalance(snapshot, triangle.CoinC.CoinSymbol).Float64()
 
 	qtyA := 1.0 // we use arbitrary qty first, if prices form arbitrage we calculate tradable qty later
 
@@ -206,45 +313,60 @@ func findArb(triangle *arb.Triangle) *arb.State {
 	}
 
 	// TODO check filters here?
-	orders := make(map[string]*common.OrderRequest)
-	orders["AB"] = &common.OrderRequest{
-		triangle.PairAB.PairSymbol,
-		sideAB,
-		common.TypeLimit,
-		FormatQty(triangle.PairAB.PairSymbol, tradeQtyAB),
-		priceAB,
+	orderAB, okAB := makeLimitOrder(triangle.PairAB, sideAB, tradeQtyAB, priceAB)
+	orderBC, okBC := makeLimitOrder(triangle.PairBC, sideBC, tradeQtyBC, priceBC)
+	orderAC, okAC := makeLimitOrder(triangle.PairAC, sideAC, tradeQtyAC, priceAC)
+	if !okAB || !okBC || !okAC {
+		return nil
 	}
-	orders["BC"] = &common.OrderRequest{
-		triangle.PairBC.PairSymbol,
-		sideBC,
-		common.TypeLimit,
-		FormatQty(triangle.PairBC.PairSymbol, tradeQtyBC),
-		priceBC,
+	orders := map[string]*common.OrderRequest{"AB": orderAB, "BC": orderBC, "AC": orderAC}
+
+	legs := []*arb.Leg{
+		{Pair: triangle.PairAB, From: triangle.CoinA, To: triangle.CoinB, Order: orders["AB"], AvgPrice: priceAB},
+		{Pair: triangle.PairBC, From: triangle.CoinB, To: triangle.CoinC, Order: orders["BC"], AvgPrice: priceBC},
+		{Pair: triangle.PairAC, From: triangle.CoinC, To: triangle.CoinA, Order: orders["AC"], AvgPrice: priceAC},
 	}
-	orders["AC"] = &common.OrderRequest{
-		triangle.PairAC.PairSymbol,
-		sideAC,
-		common.TypeLimit,
-		FormatQty(triangle.PairAC.PairSymbol, tradeQtyAC),
-		priceAC,
+
+	profit := (newQtyA - qtyA)/qtyA
+	qtyBefore := minOrderQtyInA
+	qtyAfter := minOrderQtyInA * (1 + profit)
+
+	// Top of book only tells prices form arb, deeper levels tell how much of it can be taken
+	sizedByDepth := false
+	if bookLegs := makeBookLegs(legs); bookLegs != nil && minBalanceInA > 0 {
+		sizing := arb.SizeByDepth(bookLegs, minBalanceInA)
+		if sizing == nil {
+			// Arb does not survive fees after walking the books
+			return nil
+		}
+		if !applyDepthSizing(legs, sizing) {
+			return nil
+		}
+		qtyBefore = sizing.QtyBefore
+		qtyAfter = sizing.QtyAfter
+		profit = (qtyAfter - qtyBefore)/qtyBefore
+		usesAllBalance = qtyBefore >= minBalanceInA
+		sizedByDepth = true
 	}
 
 	now := time.Now()
-	profit := (newQtyA - qtyA)/qtyA
 
-	id := triangle.Key + "_" + common.FloatToString(profit) + "_" + strconv.FormatInt(common.UnixMillis(now), 10)
-	key := triangle.Key + "_" + common.FloatToString(profit)
+	key := triangle.Key + "_" + string(direction) + "_" + common.FloatToString(profit)
+	id := key + "_" + strconv.FormatInt(common.UnixMillis(now), 10)
 
 	arbState := &arb.State{
 		Id: id,
 		Key: key,
-		QtyBefore: minOrderQtyInA,
-		QtyAfter: minOrderQtyInA * (1 + profit),
+		QtyBefore: qtyBefore,
+		QtyAfter: qtyAfter,
 		ProfitRelative: profit,
 		Triangle: triangle,
+		Direction: direction,
+		Cycle: arb.NewCycle([]common.Coin{triangle.CoinA, triangle.CoinB, triangle.CoinC}),
+		Legs: legs,
 		StartTs: now,
 		LastUpdateTs: now,
-		FrameUpdateTsQueue: make([]time.Time, 0),
+		FrameHistory: arb.NewFrameHistory(),
 		Orders: orders,
 		BalanceA: balanceA,
 		BalanceB: balanceB,
@@ -254,11 +376,62 @@ func findArb(triangle *arb.Triangle) *arb.State {
 		OrderQtyAC: orderQtyAC,
 		ScheduledForExecution: false,
 		UsesAllBalance: usesAllBalance,
+		SizedByDepth: sizedByDepth,
 	}
 
 	return arbState
 }
 
+// Returns nil unless every leg has fresh depth
+func makeBookLegs(legs []*arb.Leg) []*arb.BookLeg {
+	bookLegs := make([]*arb.BookLeg, 0, len(legs))
+	for _, leg := range legs {
+		bookLeg := makeBookLeg(leg.Pair.PairSymbol, leg.Order.Side)
+		if bookLeg == nil {
+			return nil
+		}
+		bookLegs = append(bookLegs, bookLeg)
+	}
+
+	return bookLegs
+}
+
+// Orders are limited at the worst level they have to reach to fill at sized qty
+// False if some qty or price does not fit into Decimal, arb is dropped then
+func applyDepthSizing(legs []*arb.Leg, sizing *arb.DepthSizing) bool {
+	for i, leg := range legs {
+		fill := sizing.Fills[i]
+		order, ok := makeLimitOrder(leg.Pair, leg.Order.Side, fill.OrderQty, fill.WorstPrice)
+		if !ok {
+			return false
+		}
+		leg.Order.Qty = order.Qty
+		leg.Order.Price = order.Price
+		leg.AvgPrice = fill.AvgPrice
+		leg.Slippage = fill.Slippage
+	}
+
+	return true
+}
+
+// Qty is rounded down to step size. False if qty or price does not fit into Decimal, e.g. with a bad ticker
+// or a large qty of a cheap coin, arb is dropped then.
+func makeLimitOrder(pair *common.CoinPair, side common.OrderSide, qty float64, price float64) (*common.OrderRequest, bool) {
+	formattedQty, qtyOk := FormatQty(pair.PairSymbol, qty)
+	decimalPrice, priceOk := common.DecimalFromFloat(price)
+	if !qtyOk || !priceOk {
+		return nil, false
+	}
+
+	return &common.OrderRequest{
+		pair.PairSymbol,
+		side,
+		common.TypeLimit,
+		formattedQty,
+		decimalPrice,
+	}, true
+}
+
 func isBaseCoin(
 	coinA common.Coin,
 	pair *common.CoinPair) bool {
@@ -297,35 +470,27 @@ func simTradeWithTicker(
 
 	qty := 0.0
 	if side == common.SideBuy {
-		qty = qtyA / ticker.AskPrice
+		qty = qtyA / ticker.AskPrice.Float64()
 	} else {
-		qty = qtyA * ticker.BidPrice
+		qty = qtyA * ticker.BidPrice.Float64()
 	}
 	if withFee {
 		qty = applyFee(qty)
 	}
 	if side == common.SideBuy {
-		return qty, side, ticker.AskQty, ticker.AskPrice
+		return qty, side, ticker.AskQty.Float64(), ticker.AskPrice.Float64()
 	} else {
-		return qty, side, ticker.BidQty, ticker.BidPrice
+		return qty, side, ticker.BidQty.Float64(), ticker.BidPrice.Float64()
 	}
 }
 
 func applyFee(qty float64) float64 {
 	// TODO properly calc fee
-	return qty * (1.0 - BINANCE_BNB_FEE)
-}
-
-func isTriangle(pairA, pairB, pairC *common.CoinPair) bool {
-	// make sure number of coin symbols is 3 and all symbols are different
-	return strings.Compare(pairA.PairSymbol, pairB.PairSymbol) != 0 &&
-		strings.Compare(pairA.PairSymbol, pairC.PairSymbol) != 0 &&
-		strings.Compare(pairB.PairSymbol, pairC.PairSymbol) != 0 &&
-		len(getCoinSymbols(pairA, pairB, pairC)) == 3
+	return qty * (1.0 - executionThresholds.FEE)
 }
 
 func makeTriangle(pairA, pairB, pairC *common.CoinPair) (string, *arb.Triangle) {
-	// only works if isTriangle == true
+	// only works if pairs are different and have 3 coins in total
 	coinSymbols := getCoinSymbols(pairA, pairB, pairC)
 	var keys []string
 	for k := range coinSymbols {
@@ -378,11 +543,10 @@ func findPairForCoins(coinA common.Coin, coinB common.Coin, pairs ...*common.Coi
 	panic("Couldn't find coin pair")
 }
 
-func updateFrameCounters() {
-	ts := time.Now()
-	arbStates.Range(func(k, v interface{}) bool {
-		arbState := v.(*arb.State)
-		arbState.FrameUpdateTsQueue = append(arbState.FrameUpdateTsQueue, ts)
-		return true
-	})
+// Records frame tickers of snapshot in history of state which was found in them
+func recordFrameSighting(state *arb.State, snapshot *MarketSnapshot) {
+	if snapshot.TickersFrame == nil {
+		return
+	}
+	state.FrameHistory.Add(snapshot.TickersFrame.Ts, snapshot.TickersFrame.EyeId)
 }
@@ -0,0 +1,289 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/common/arb"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var detectionQuoteCoins = []string{"BTC", "ETH", "BNB"}
+
+// Prices of quote coins in BTC
+var detectionQuotePrices = map[string]float64{"BTC": 1, "ETH": 0.03, "BNB": 0.002}
+
+func makeDetectionPair(base string, quote string) *common.CoinPair {
+	return &common.CoinPair{
+		PairSymbol: base + quote,
+		BaseCoin: common.Coin{CoinSymbol: base},
+		QuoteCoin: common.Coin{CoinSymbol: quote},
+	}
+}
+
+// Every base coin is listed against BTC, ETH and BNB, which are listed against each other,
+// so every base coin is in 3 triangles. Spread keeps fees from forming arbs.
+func makeDetectionUniverse(baseCoins int) ([]*common.CoinPair, *common.TickersMap, *arb.TriangleIndex) {
+	pairs := []*common.CoinPair{makeDetectionPair("ETH", "BTC"), makeDetectionPair("BNB", "BTC"), makeDetectionPair("BNB", "ETH")}
+	prices := map[string]float64{"ETH": 0.03, "BNB": 0.002}
+	for i := 0; i < baseCoins; i++ {
+		coin := "C" + strconv.Itoa(i)
+		prices[coin] = 0.0001 * float64(i + 1)
+		for _, quote := range detectionQuoteCoins {
+			pairs = append(pairs, makeDetectionPair(coin, quote))
+		}
+	}
+
+	tickers := make(common.TickersMap)
+	for _, pair := range pairs {
+		mid := prices[pair.BaseCoin.CoinSymbol] / detectionQuotePrices[pair.QuoteCoin.CoinSymbol]
+		bidPrice, _ := common.DecimalFromFloat(mid * 0.999)
+		askPrice, _ := common.DecimalFromFloat(mid * 1.001)
+		tickers[pair.PairSymbol] = &common.Ticker{
+			Symbol: pair.PairSymbol,
+			BidPrice: bidPrice,
+			BidQty: common.DecimalFromInt(100),
+			AskPrice: askPrice,
+			AskQty: common.DecimalFromInt(100),
+		}
+	}
+
+	index := arb.NewTriangleIndex()
+	addTriangle := func(pairA, pairB, pairC *common.CoinPair) {
+		_, triangle := makeTriangle(pairA, pairB, pairC)
+		index.Add(triangle)
+	}
+	addTriangle(pairs[0], pairs[1], pairs[2])
+	for i := 3; i < len(pairs); i += 3 {
+		// base/BTC, base/ETH, base/BNB
+		addTriangle(pairs[i], pairs[i + 1], pairs[0])
+		addTriangle(pairs[i], pairs[i + 2], pairs[1])
+		addTriangle(pairs[i + 1], pairs[i + 2], pairs[2])
+	}
+
+	return pairs, &tickers, index
+}
+
+// Publishes snapshot with pairs, filters and balances of the universe, previous snapshot is restored after the test
+func setDetectionSnapshot(tb testing.TB, pairs []*common.CoinPair, minNotional string) {
+	prev := getSnapshot()
+	tb.Cleanup(func() {
+		currentSnapshot.Store(prev)
+	})
+
+	filters := make(common.FiltersMap)
+	symbolFilters := make(map[string]*common.SymbolFilters)
+	for _, pair := range pairs {
+		filters[pair.PairSymbol] = []common.Filter{
+			{"filterType": common.FILTER_TYPE_PRICE_FILTER, "tickSize": "0.00000001"},
+			{"filterType": common.FILTER_TYPE_LOT_SIZE, "stepSize": "0.001"},
+			{"filterType": common.FILTER_TYPE_MIN_NOTIONAL, "minNotional": minNotional},
+		}
+		symbolFilters[pair.PairSymbol] = &common.SymbolFilters{
+			TickSize: common.ToDecimal("0.00000001"),
+			StepSize: common.ToDecimal("0.001"),
+			MinNotional: common.ToDecimal(minNotional),
+		}
+	}
+	updateSnapshot(func(next *MarketSnapshot) bool {
+		next.Tickers = common.NewShardedTickers(nil)
+		next.Pairs = pairs
+		next.Filters = filters
+		next.SymbolFilters = symbolFilters
+		next.Account = &common.Account{Balances: map[string]*common.Balance{
+			"BTC": {CoinSymbol: "BTC", Free: common.ToDecimal("1")},
+			"ETH": {CoinSymbol: "ETH", Free: common.ToDecimal("10")},
+			"BNB": {CoinSymbol: "BNB", Free: common.ToDecimal("100")},
+		}}
+		return true
+	})
+}
+
+// Frame args as eye's TickersFrameEncoder sends them, payload is compressed already
+func makeTickersFrame(frameType string, seq int64, compressed string) map[string]string {
+	args := map[string]string{
+		common.TICKERS_FRAME_TYPE: frameType,
+		common.TICKERS_FRAME_SEQ: strconv.FormatInt(seq, 10),
+	}
+	if frameType == common.TICKERS_FRAME_KEY {
+		args[common.TICKERS_MAP_SERIALIZED] = compressed
+	} else {
+		args[common.TICKERS_DELTA_SERIALIZED] = compressed
+	}
+
+	return args
+}
+
+// Compressed deltas which change bid qty of n symbols back and forth, prices stay the same
+func makeAlternatingDeltas(tickers *common.TickersMap, n int) []string {
+	deltas := make([]string, 2)
+	for i, bidQty := range []int64{101, 100} {
+		delta := &common.TickersDelta{Updated: make(common.TickersMap), Removed: make([]string, 0)}
+		for symbol, ticker := range *tickers {
+			if len(delta.Updated) == n {
+				break
+			}
+			changed := *ticker
+			changed.BidQty = common.DecimalFromInt(bidQty)
+			delta.Updated[symbol] = &changed
+		}
+		deltas[i] = common.CompressString(delta.Serialize())
+	}
+
+	return deltas
+}
+
+// One detector pass as in runDetectArbBLOCKING: delta frame is decoded into the snapshot,
+// then only triangles of changed symbols are evaluated
+func BenchmarkApplyFrameAndDetect(b *testing.B) {
+	pairs, tickers, index := makeDetectionUniverse(200)
+	setDetectionSnapshot(b, pairs, "0")
+
+	for _, changedSymbols := range []int{1, 10, 100, len(pairs)} {
+		b.Run(strconv.Itoa(changedSymbols) + "_symbols", func(b *testing.B) {
+			decoder := NewTickersFrameDecoder()
+			err := decoder.ApplyFrame(makeTickersFrame(common.TICKERS_FRAME_KEY, 0, common.CompressString(tickers.Serialize())), func(tickersMap *common.TickersMap) {
+				updateTickersMap(tickersMap, nil)
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			takeChangedSymbols()
+			deltas := makeAlternatingDeltas(tickers, changedSymbols)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := decoder.ApplyFrame(makeTickersFrame(common.TICKERS_FRAME_DELTA, int64(i + 1), deltas[i % 2]), func(tickersMap *common.TickersMap) {
+					updateTickersMap(tickersMap, nil)
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				triangles := index.TrianglesForSymbols(takeChangedSymbols())
+				snapshot := getSnapshot()
+				for _, triangle := range triangles {
+					if findArb(snapshot, triangle) != nil {
+						b.Fatal("unexpected arb in " + triangle.Key)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Run with -race: tickers and account snapshots are published while detector evaluates triangles and
+// scheduler checks arbs it found. Min notional is above balances, so every arb is dropped before execution.
+func TestConcurrentDetectionAndScheduling(t *testing.T) {
+	const ITERATIONS = 200
+	pairs, tickers, index := makeDetectionUniverse(20)
+	setDetectionSnapshot(t, pairs, "1000")
+
+	// C0BTC bid 3% or 4% above the others forms arbs through C0 in every published snapshot
+	tickersUpdates := make([]*common.TickersMap, 2)
+	for i, bidPrice := range []string{"0.000103", "0.000104"} {
+		arbTickers := make(common.TickersMap, len(*tickers))
+		for symbol, ticker := range *tickers {
+			arbTickers[symbol] = ticker
+		}
+		arbTicker := *arbTickers["C0BTC"]
+		arbTicker.BidPrice = common.ToDecimal(bidPrice)
+		arbTicker.AskPrice = common.ToDecimal("0.000105")
+		arbTickers["C0BTC"] = &arbTicker
+		tickersUpdates[i] = &arbTickers
+	}
+	updateTickersMap(tickersUpdates[0], nil)
+
+	symbols := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		symbols = append(symbols, pair.PairSymbol)
+	}
+	triangles := index.TrianglesForSymbols(symbols)
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < ITERATIONS; i++ {
+			updateTickersMap(tickersUpdates[i % 2], nil)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < ITERATIONS; i++ {
+			updateSnapshot(func(next *MarketSnapshot) bool {
+				next.Account = &common.Account{Balances: map[string]*common.Balance{
+					"BTC": {CoinSymbol: "BTC", Free: common.DecimalFromInt(int64(1 + i % 2))},
+					"ETH": {CoinSymbol: "ETH", Free: common.ToDecimal("10")},
+					"BNB": {CoinSymbol: "BNB", Free: common.ToDecimal("100")},
+				}}
+				return true
+			})
+		}
+	}()
+
+	found := make(chan *arb.State, ITERATIONS)
+	go func() {
+		defer wg.Done()
+		defer close(found)
+		for i := 0; i < ITERATIONS; i++ {
+			snapshot := getSnapshot()
+			for _, triangle := range triangles {
+				if arbState := findArb(snapshot, triangle); arbState != nil {
+					select {
+					case found<-arbState:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	arbStatesFound := 0
+	go func() {
+		defer wg.Done()
+		for arbState := range found {
+			arbStatesFound++
+			ScheduleOrderExecutionIfNeeded(arbState)
+			if coinReservations.IsAnyReserved(getStateCoins(arbState)) {
+				t.Error(arbState.Id + " is executed")
+			}
+		}
+	}()
+	wg.Wait()
+	takeChangedSymbols()
+
+	if arbStatesFound == 0 {
+		t.Error("no arbs found")
+	}
+}
+
+func TestUpdateTickersMapPublishesUnchangedFrames(t *testing.T) {
+	pairs, tickers, _ := makeDetectionUniverse(1)
+	setDetectionSnapshot(t, pairs, "0")
+	updateTickersMap(tickers, nil)
+	takeChangedSymbols()
+
+	cases := []struct {
+		name string
+		frame *arb.FrameSighting
+		published bool
+	}{
+		// Same tickers fetched by brain confirm nothing
+		{"fetched by brain", nil, false},
+		// Same tickers seen by an eye confirm arbs which hold in them
+		{"frame from eye", &arb.FrameSighting{Ts: time.Now(), EyeId: 1}, true},
+	}
+	for _, c := range cases {
+		version := getSnapshot().Version
+		changed := updateTickersMap(tickers, c.frame)
+		snapshot := getSnapshot()
+		if len(changed) != 0 || (snapshot.Version != version) != c.published {
+			t.Errorf("%s: expected published %t with no changes, got %d changed", c.name, c.published, len(changed))
+			continue
+		}
+		if c.published && snapshot.TickersFrame != c.frame {
+			t.Errorf("%s: expected snapshot to come from frame", c.name)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package brain
+
+import (
+	"midas/common/arb"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Every that many dropped events subscriber is reported as slow
+const ARB_EVENTS_DROP_LOG_PERIOD = 1000
+
+type arbEventSubscriber struct {
+	events chan *arb.Event
+	dropped int64
+}
+
+var arbEventSubscribers = make(map[int]*arbEventSubscriber)
+var lastArbEventSubscriberId = -1
+var arbEventsMux sync.Mutex
+
+// Returns id to unsubscribe with and channel of arb state lifecycle events. Events are dropped
+// for subscribers which do not keep up, so publishing never blocks detector.
+func SubscribeArbEvents(bufferSize int) (int, <-chan *arb.Event) {
+	arbEventsMux.Lock()
+	defer arbEventsMux.Unlock()
+	lastArbEventSubscriberId++
+	subscriber := &arbEventSubscriber{
+		events: make(chan *arb.Event, bufferSize),
+	}
+	arbEventSubscribers[lastArbEventSubscriberId] = subscriber
+
+	return lastArbEventSubscriberId, subscriber.events
+}
+
+// Closes subscriber's channel
+func UnsubscribeArbEvents(id int) {
+	arbEventsMux.Lock()
+	defer arbEventsMux.Unlock()
+	subscriber, ok := arbEventSubscribers[id]
+	if !ok {
+		return
+	}
+	delete(arbEventSubscribers, id)
+	close(subscriber.events)
+}
+
+func publishArbEvent(eventType arb.EventType, state *arb.State, reason string) {
+	event := &arb.Event{
+		Type: eventType,
+		State: state,
+		Reason: reason,
+		Ts: time.Now(),
+	}
+
+	arbEventsMux.Lock()
+	defer arbEventsMux.Unlock()
+	for id, subscriber := range arbEventSubscribers {
+		select {
+		case subscriber.events<-event:
+		default:
+			subscriber.dropped++
+			if subscriber.dropped % ARB_EVENTS_DROP_LOG_PERIOD == 1 {
+				log.Println("Arb events subscriber " + strconv.Itoa(id) + " is slow, dropped " + strconv.FormatInt(subscriber.dropped, 10) + " events")
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package brain
+
+import (
+	"github.com/pebbe/zmq4"
+	"log"
+	"strconv"
+	"sync"
+)
+
+const ARB_EVENTS_PUBLISHER_BUFFER = 10000
+
+var arbEventsPublisherId = -1
+var arbEventsPublisherDone sync.WaitGroup
+
+// Streams arb events as JSON over ZMQ PUB on ARB_EVENTS_PORT. Each event is sent as two frames,
+// event type and serialized event, so consumers can subscribe to event types they need.
+func RunArbEventsPublisher() {
+	if brainConfig.ARB_EVENTS_PORT == 0 {
+		return
+	}
+
+	socket, err := zmq4.NewSocket(zmq4.PUB)
+	if err != nil {
+		log.Println("Unable to create arb events socket: " + err.Error())
+		return
+	}
+	err = socket.Bind(TCP_PREFIX + strconv.Itoa(brainConfig.ARB_EVENTS_PORT))
+	if err != nil {
+		log.Println("Unable to bind arb events socket: " + err.Error())
+		socket.Close()
+		return
+	}
+
+	id, events := SubscribeArbEvents(ARB_EVENTS_PUBLISHER_BUFFER)
+	arbEventsPublisherId = id
+	arbEventsPublisherDone.Add(1)
+	go func() {
+		defer arbEventsPublisherDone.Done()
+		defer socket.Close()
+		log.Println("Publishing arb events on port " + strconv.Itoa(brainConfig.ARB_EVENTS_PORT))
+		for event := range events {
+			_, err := socket.Send(string(event.Type), zmq4.SNDMORE)
+			if err == nil {
+				_, err = socket.Send(event.Serialize(), 0)
+			}
+			if err != nil {
+				log.Println("Error publishing arb event: " + err.Error())
+			}
+		}
+	}()
+}
+
+// Sends events which are already queued and closes the socket
+func StopArbEventsPublisher() {
+	if arbEventsPublisherId < 0 {
+		return
+	}
+	UnsubscribeArbEvents(arbEventsPublisherId)
+	arbEventsPublisherDone.Wait()
+}
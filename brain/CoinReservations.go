@@ -0,0 +1,133 @@
+package brain
+
+import (
+	"errors"
+	"midas/common"
+	"midas/common/arb"
+	"sort"
+	"sync"
+)
+
+// Coins of in-flight arbs with balances arbs spend from them. Each coin is held by one arb at a time,
+// so arbs on disjoint coins run concurrently and their orders never trade the same coin.
+type CoinReservations struct {
+	owners map[string]string // coin -> id of arb holding it
+	amounts map[string]common.Decimal // coin -> balance its owner spends
+	mux sync.Mutex
+}
+
+func NewCoinReservations() *CoinReservations {
+	return &CoinReservations{
+		owners: make(map[string]string),
+		amounts: make(map[string]common.Decimal),
+	}
+}
+
+var coinReservations = NewCoinReservations()
+
+func (r *CoinReservations) IsAnyReserved(coins []string) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, coin := range coins {
+		if _, ok := r.owners[coin]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reserves all coins for id along with amounts it spends from them, checking free balances cover the amounts.
+// Nothing is reserved if some coin is held by another arb or balance does not cover, reason is returned then.
+func (r *CoinReservations) TryReserve(id string, coins []string, amounts map[string]common.Decimal, free func(coin string) common.Decimal) string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, coin := range coins {
+		if owner, ok := r.owners[coin]; ok && owner != id {
+			return coin + " is reserved by " + owner
+		}
+	}
+	for coin, amount := range amounts {
+		if available := free(coin); available.LessThan(amount) {
+			return "available " + coin + " " + available.String() + " does not cover " + amount.String()
+		}
+	}
+
+	for _, coin := range coins {
+		r.owners[coin] = id
+	}
+	for coin, amount := range amounts {
+		r.amounts[coin] = amount
+	}
+
+	return ""
+}
+
+func (r *CoinReservations) Release(id string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for coin, owner := range r.owners {
+		if owner == id {
+			delete(r.owners, coin)
+			delete(r.amounts, coin)
+		}
+	}
+}
+
+// Balance of coin in-flight arb counts on, zero if coin is not reserved
+func (r *CoinReservations) GetReserved(coin string) common.Decimal {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.amounts[coin]
+}
+
+// Free balance which is not reserved by in-flight arbs, so newly detected arbs and rebalancing
+// are not sized from balance some arb is about to spend
+func getAvailableBalance(snapshot *MarketSnapshot, coin string) common.Decimal {
+	available := snapshot.GetFreeBalance(coin).Sub(coinReservations.GetReserved(coin))
+	if available.IsPositive() {
+		return available
+	}
+
+	return common.Decimal{}
+}
+
+// Every coin state's legs trade, sorted
+func getStateCoins(state *arb.State) []string {
+	seen := make(map[string]bool)
+	coins := make([]string, 0, len(state.Legs) + 1)
+	for _, leg := range state.Legs {
+		for _, coin := range []string{leg.From.CoinSymbol, leg.To.CoinSymbol} {
+			if !seen[coin] {
+				seen[coin] = true
+				coins = append(coins, coin)
+			}
+		}
+	}
+	sort.Strings(coins)
+
+	return coins
+}
+
+// Balances orders spend from coins held beforehand: every leg in parallel execution,
+// only the first one in sequential execution, as further legs spend what previous ones received.
+// Fails if cost of some order does not fit into Decimal.
+func getSpentAmounts(state *arb.State, orders []*common.OrderRequest, strategy arb.ExecutionStrategy) (map[string]common.Decimal, error) {
+	amounts := make(map[string]common.Decimal)
+	for i, leg := range state.Legs {
+		if i > 0 && strategy == arb.StrategySequential {
+			break
+		}
+		order := orders[i]
+		amount := order.Qty
+		if order.Side == common.SideBuy {
+			var ok bool
+			if amount, ok = order.Qty.Mul(order.Price); !ok {
+				return nil, errors.New("cost of " + order.Symbol + " order is out of range")
+			}
+		}
+		amounts[leg.From.CoinSymbol] = amounts[leg.From.CoinSymbol].Add(amount)
+	}
+
+	return amounts, nil
+}
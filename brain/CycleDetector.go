@@ -0,0 +1,298 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/common/arb"
+	"math"
+	"time"
+	"log"
+	"strconv"
+	"sync"
+	"midas/logging"
+)
+
+const (
+	CYCLE_MIN_LEGS = 3
+	DEFAULT_CYCLE_DETECTION_PERIOD_MILLIS = 100
+)
+
+// Arb states of N-leg cycles, kept apart from triangle states which are scheduled for execution.
+// Cycles are detection-only: they are published and logged, but never executed.
+var cycleArbStates = sync.Map{}
+
+// Directed edge of coin graph, trading From coin for To coin multiplies qty by rate
+type cycleEdge struct {
+	from common.Coin
+	to common.Coin
+	pair *common.CoinPair
+	side common.OrderSide
+	price float64
+	bookQty float64 // base coin qty available at price
+	rate float64 // after fee
+	midRate float64 // at mid price, without fee
+	weight float64 // -log(rate), negative cycle is an arb
+}
+
+// Looks for cycles of CYCLE_MIN_LEGS to CYCLE_MAX_LEGS legs which return more of start coin than they take
+func runDetectCycles() {
+	if brainConfig.CYCLE_MAX_LEGS < CYCLE_MIN_LEGS || len(brainConfig.CYCLE_START_COINS) == 0 {
+		return
+	}
+
+	period := time.Duration(brainConfig.CYCLE_DETECTION_PERIOD_MILLIS) * time.Millisecond
+	if period == 0 {
+		period = DEFAULT_CYCLE_DETECTION_PERIOD_MILLIS * time.Millisecond
+	}
+
+	detectionWg.Add(1)
+	go func() {
+		defer detectionWg.Done()
+		log.Println("Looking for cycles of up to " + strconv.Itoa(brainConfig.CYCLE_MAX_LEGS) + " legs...")
+		for {
+			select {
+			case <-stopDetection:
+				return
+			case <-time.After(period):
+			}
+
+			trianglesMux.RLock()
+			pairs := make([]*common.CoinPair, 0, len(graphPairs))
+			for _, pair := range graphPairs {
+				pairs = append(pairs, pair)
+			}
+			trianglesMux.RUnlock()
+
+			snapshot := getSnapshot()
+			edges := buildCycleEdges(snapshot, pairs)
+			for _, startCoin := range brainConfig.CYCLE_START_COINS {
+				for _, cycle := range findNegativeCycles(edges, startCoin, brainConfig.CYCLE_MAX_LEGS) {
+					arbState := makeCycleArbState(snapshot, cycle)
+					if arbState == nil {
+						continue
+					}
+					if existing, loaded := cycleArbStates.Load(arbState.Key); loaded {
+						arbState = existing.(*arb.State)
+						arbState.Touch(time.Now())
+						publishArbEvent(arb.EventUpdated, arbState, "")
+					} else {
+						valueArbState(arbState)
+						cycleArbStates.Store(arbState.Key, arbState)
+						log.Println("Detected cycle " + arbState.Key)
+						publishArbEvent(arb.EventDetected, arbState, "")
+					}
+					recordFrameSighting(arbState, snapshot)
+				}
+			}
+		}
+	}()
+}
+
+// Builds both directions of every pair which has a ticker
+func buildCycleEdges(snapshot *MarketSnapshot, pairs []*common.CoinPair) []*cycleEdge {
+	edges := make([]*cycleEdge, 0, 2 * len(pairs))
+	for _, pair := range pairs {
+		ticker := snapshot.GetTicker(pair.PairSymbol)
+		if ticker == nil || !ticker.BidPrice.IsPositive() || !ticker.AskPrice.IsPositive() {
+			continue
+		}
+
+		bidPrice := ticker.BidPrice.Float64()
+		askPrice := ticker.AskPrice.Float64()
+		midPrice := (bidPrice + askPrice) / 2.0
+
+		// Base -> quote sells base at bid
+		sellRate := applyFee(bidPrice)
+		edges = append(edges, &cycleEdge{
+			from: pair.BaseCoin,
+			to: pair.QuoteCoin,
+			pair: pair,
+			side: common.SideSell,
+			price: bidPrice,
+			bookQty: ticker.BidQty.Float64(),
+			rate: sellRate,
+			midRate: midPrice,
+			weight: -math.Log(sellRate),
+		})
+
+		// Quote -> base buys base at ask
+		buyRate := applyFee(1.0 / askPrice)
+		edges = append(edges, &cycleEdge{
+			from: pair.QuoteCoin,
+			to: pair.BaseCoin,
+			pair: pair,
+			side: common.SideBuy,
+			price: askPrice,
+			bookQty: ticker.AskQty.Float64(),
+			rate: buyRate,
+			midRate: 1.0 / midPrice,
+			weight: -math.Log(buyRate),
+		})
+	}
+
+	return edges
+}
+
+// Bellman-Ford bounded by number of legs: after k relaxation rounds dist[k][coin] holds the lightest
+// walk of exactly k legs from start coin. Walks which come back to start coin with negative weight are arbs.
+// Only the lightest walk per coin and length is kept, so returns at most one cycle per length. If that walk
+// goes through some coin twice, no cycle of that length is reported, even if a negative simple one
+// exists. This is good enough for detection, which is all cycles are used for.
+func findNegativeCycles(edges []*cycleEdge, startCoin string, maxLegs int) [][]*cycleEdge {
+	dist := make([]map[string]float64, maxLegs + 1)
+	pred := make([]map[string]*cycleEdge, maxLegs + 1)
+	dist[0] = map[string]float64{startCoin: 0}
+	pred[0] = map[string]*cycleEdge{}
+
+	cycles := make([][]*cycleEdge, 0)
+	for k := 1; k <= maxLegs; k++ {
+		dist[k] = make(map[string]float64)
+		pred[k] = make(map[string]*cycleEdge)
+		for _, edge := range edges {
+			from := edge.from.CoinSymbol
+			// Walks end once they are back at start coin
+			if from == startCoin && k > 1 {
+				continue
+			}
+			d, ok := dist[k - 1][from]
+			if !ok {
+				continue
+			}
+			to := edge.to.CoinSymbol
+			if cur, ok := dist[k][to]; !ok || d + edge.weight < cur {
+				dist[k][to] = d + edge.weight
+				pred[k][to] = edge
+			}
+		}
+
+		if k < CYCLE_MIN_LEGS {
+			continue
+		}
+		if d, ok := dist[k][startCoin]; !ok || d >= 0 {
+			continue
+		}
+		if cycle := walkBack(pred, startCoin, k); cycle != nil {
+			cycles = append(cycles, cycle)
+		}
+	}
+
+	return cycles
+}
+
+// Restores walk of k legs ending at endCoin, returns nil if it goes through some coin twice
+func walkBack(pred []map[string]*cycleEdge, endCoin string, k int) []*cycleEdge {
+	cycle := make([]*cycleEdge, k)
+	visited := make(map[string]bool)
+	coin := endCoin
+	for i := k; i > 0; i-- {
+		edge := pred[i][coin]
+		if edge == nil {
+			return nil
+		}
+		cycle[i - 1] = edge
+		coin = edge.from.CoinSymbol
+		if visited[coin] {
+			return nil
+		}
+		visited[coin] = true
+	}
+
+	return cycle
+}
+
+// Nil if cycle does not profit or its orders do not fit into Decimal
+func makeCycleArbState(snapshot *MarketSnapshot, cycle []*cycleEdge) *arb.State {
+	// Rate from start coin to the coin each leg trades from
+	cumRates := make([]float64, len(cycle) + 1)
+	cumRates[0] = 1.0
+	for i, edge := range cycle {
+		cumRates[i + 1] = cumRates[i] * edge.rate
+	}
+	profit := cumRates[len(cycle)] - 1.0
+	if profit <= 0 {
+		return nil
+	}
+
+	// Max qty of start coin which fits into every leg's book and balance
+	maxQty := math.MaxFloat64
+	usesAllBalance := false
+	for i, edge := range cycle {
+		bookQtyInFrom := edge.bookQty
+		if edge.side == common.SideBuy {
+			bookQtyInFrom = edge.bookQty * edge.price
+		}
+		maxQty = math.Min(maxQty, bookQtyInFrom / cumRates[i])
+
+		balanceInStart := getAvailableBalance(snapshot, edge.from.CoinSymbol).Float64() / cumRates[i]
+		if balanceInStart <= maxQty {
+			maxQty = balanceInStart
+			usesAllBalance = true
+		}
+	}
+
+	coins := make([]common.Coin, 0, len(cycle))
+	legs := make([]*arb.Leg, 0, len(cycle))
+	for i, edge := range cycle {
+		qtyFrom := maxQty * cumRates[i]
+		orderQty := qtyFrom
+		if edge.side == common.SideBuy {
+			orderQty = qtyFrom / edge.price
+		}
+		order, ok := makeLimitOrder(edge.pair, edge.side, orderQty, edge.price)
+		if !ok {
+			return nil
+		}
+		coins = append(coins, edge.from)
+		legs = append(legs, &arb.Leg{
+			Pair: edge.pair,
+			From: edge.from,
+			To: edge.to,
+			Order: order,
+		})
+	}
+
+	cycleInfo := arb.NewCycle(coins)
+	now := time.Now()
+	key := cycleInfo.Key + "_" + common.FloatToString(profit)
+
+	return &arb.State{
+		Id: key + "_" + strconv.FormatInt(common.UnixMillis(now), 10),
+		Key: key,
+		QtyBefore: maxQty,
+		QtyAfter: maxQty * (1 + profit),
+		ProfitRelative: profit,
+		Cycle: cycleInfo,
+		Legs: legs,
+		StartTs: now,
+		LastUpdateTs: now,
+		FrameHistory: arb.NewFrameHistory(),
+		UsesAllBalance: usesAllBalance,
+	}
+}
+
+// Same as runReportArb for cycle states
+func reportStaleCycleArbStates() {
+	cycleArbStates.Range(func(k, v interface{}) bool {
+		arbState := v.(*arb.State)
+		if time.Since(arbState.GetLastUpdateTs()) > time.Duration(brainConfig.ARB_REPORT_UPDATE_THRESHOLD_MICROS) * time.Microsecond {
+			cycleArbStates.Delete(k)
+			publishArbEvent(arb.EventExpired, arbState, "")
+			logging.QueueEvent(&logging.Event{
+				EventType: logging.EventTypeArbState,
+				Value: arbState,
+			})
+		}
+		return true
+	})
+}
+
+func reportAllCycleArbStates() {
+	cycleArbStates.Range(func(k, v interface{}) bool {
+		cycleArbStates.Delete(k)
+		publishArbEvent(arb.EventExpired, v.(*arb.State), "shutdown")
+		logging.QueueEvent(&logging.Event{
+			EventType: logging.EventTypeArbState,
+			Value: v.(*arb.State),
+		})
+		return true
+	})
+}
@@ -0,0 +1,79 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/common/arb"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Older depth is not used for sizing, top of book sizing is used instead
+	DEPTH_MAX_AGE_MILLIS = 2000
+	DEPTH_WATCHLIST_IDLE_MILLIS = 100
+)
+
+type depthSnapshot struct {
+	depth *common.Depth
+	updateTs time.Time
+}
+
+var depthsMap = make(map[string]*depthSnapshot)
+var depthsMux sync.RWMutex
+
+func updateDepth(symbol string, depth *common.Depth) {
+	depthsMux.Lock()
+	defer depthsMux.Unlock()
+	depthsMap[symbol] = &depthSnapshot{depth, time.Now()}
+}
+
+// Returns nil if there is no depth for symbol or it is too old
+func getFreshDepth(symbol string) *common.Depth {
+	depthsMux.RLock()
+	defer depthsMux.RUnlock()
+	snapshot, ok := depthsMap[symbol]
+	if !ok || time.Since(snapshot.updateTs) > DEPTH_MAX_AGE_MILLIS * time.Millisecond {
+		return nil
+	}
+
+	return snapshot.depth
+}
+
+// Symbols of currently open arbs, only their depth is worth the request weight
+func getDepthWatchlist() []string {
+	symbols := make(map[string]bool)
+	arbStates.Range(func(k, v interface{}) bool {
+		for _, leg := range v.(*arb.State).Legs {
+			symbols[leg.Pair.PairSymbol] = true
+		}
+		return true
+	})
+
+	watchlist := make([]string, 0, len(symbols))
+	for symbol := range symbols {
+		watchlist = append(watchlist, symbol)
+	}
+	sort.Strings(watchlist)
+
+	return watchlist
+}
+
+// Book side leg trades against, nil if depth is not fresh
+func makeBookLeg(symbol string, side common.OrderSide) *arb.BookLeg {
+	depth := getFreshDepth(symbol)
+	if depth == nil {
+		return nil
+	}
+
+	levels := depth.BidList
+	if side == common.SideBuy {
+		levels = depth.AskList
+	}
+
+	return &arb.BookLeg{
+		Side: side,
+		Levels: levels,
+		Fee: executionThresholds.FEE,
+	}
+}
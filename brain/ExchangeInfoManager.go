@@ -5,14 +5,9 @@ import (
 	"midas/apis/binance"
 	"time"
 	"log"
-	"math"
 	"strings"
 )
 
-var exchangeInfo *common.ExchangeInfo
-var allPairs []*common.CoinPair
-var filtersMap *common.FiltersMap
-
 const EXCHANGE_INFO_UPDATE_PERIOD_MIN = 1
 
 func RunUpdateExchangeInfo() {
@@ -30,17 +25,26 @@ func updateExchangeInfo() {
 	log.Println("Updating exchange info...")
 	info, _ := binance.GetExchangeInfo()
 	if info != nil {
-		exchangeInfo = info
-		allPairs = getAllPairs()
-		filtersMap = getFiltersMap()
+		pairs := getAllPairs(info)
+		filters := getFiltersMap(info)
+		symbolFilters := getSymbolFiltersMap(filters)
+		updateSnapshot(func(next *MarketSnapshot) bool {
+			next.ExchangeInfo = info
+			next.Pairs = pairs
+			next.Filters = filters
+			next.SymbolFilters = symbolFilters
+			return true
+		})
+		onPairsUpdated(pairs)
 		log.Println("Updating exchange info... Done")
 	}
 }
-func getAllPairs() []*common.CoinPair {
+
+func getAllPairs(info *common.ExchangeInfo) []*common.CoinPair {
 	var pairs []*common.CoinPair
 
-	for _, symbol := range exchangeInfo.Symbols {
-		// TODO dynamically add newly detected pairs
+	for _, symbol := range info.Symbols {
+		// Pairs which stop trading drop out here and their triangles are removed by onPairsUpdated
 		if strings.Compare(symbol.Status, "TRADING") != 0 {
 			continue
 		}
@@ -59,114 +63,75 @@ func getAllPairs() []*common.CoinPair {
 	return pairs
 }
 
-func getFiltersMap() *common.FiltersMap {
+func getFiltersMap(info *common.ExchangeInfo) common.FiltersMap {
 	filtersMap := make(common.FiltersMap)
-	for _, symbol := range exchangeInfo.Symbols {
+	for _, symbol := range info.Symbols {
 		filtersMap[symbol.Symbol] = symbol.Filters
 	}
 
-	return &filtersMap
+	return filtersMap
 }
 
 func initTickersMap() {
-	tickers, err := binance.GetAllTickers()
+	tickers, err := binance.GetAllTickers(0)
 	if err != nil {
 		panic("Unable to init tickers map: " + err.Error())
 	}
 
-	tickersMap = tickers
+	updateTickersMap(tickers, nil)
 }
 
-func GetMinPrice(symbol string) float64 {
+func GetMinPrice(symbol string) common.Decimal {
 	filter := GetFilter(symbol, common.FILTER_TYPE_PRICE_FILTER)
-	return common.ToFloat64((*filter)["minPrice"])
+	return common.ToDecimal((*filter)["minPrice"])
 }
 
-func GetMaxPrice(symbol string) float64 {
+func GetMaxPrice(symbol string) common.Decimal {
 	filter := GetFilter(symbol, common.FILTER_TYPE_PRICE_FILTER)
-	return common.ToFloat64((*filter)["maxPrice"])
+	return common.ToDecimal((*filter)["maxPrice"])
 }
 
-func GetTickSize(symbol string) float64 {
+func GetTickSize(symbol string) common.Decimal {
 	filter := GetFilter(symbol, common.FILTER_TYPE_PRICE_FILTER)
-	return common.ToFloat64((*filter)["tickSize"])
+	return common.ToDecimal((*filter)["tickSize"])
 }
 
-func GetMinQty(symbol string) float64 {
+func GetMinQty(symbol string) common.Decimal {
 	filter := GetFilter(symbol, common.FILTER_TYPE_LOT_SIZE)
-	return common.ToFloat64((*filter)["minQty"])
+	return common.ToDecimal((*filter)["minQty"])
 }
 
-func GetMaxQty(symbol string) float64 {
+func GetMaxQty(symbol string) common.Decimal {
 	filter := GetFilter(symbol, common.FILTER_TYPE_LOT_SIZE)
-	return common.ToFloat64((*filter)["maxQty"])
+	return common.ToDecimal((*filter)["maxQty"])
 }
 
-func GetStepSize(symbol string) float64 {
+func GetStepSize(symbol string) common.Decimal {
 	filter := GetFilter(symbol, common.FILTER_TYPE_LOT_SIZE)
-	return common.ToFloat64((*filter)["stepSize"])
+	return common.ToDecimal((*filter)["stepSize"])
 }
 
-func GetMinNotional(symbol string) float64 {
+func GetMinNotional(symbol string) common.Decimal {
 	filter := GetFilter(symbol, common.FILTER_TYPE_MIN_NOTIONAL)
-	return common.ToFloat64((*filter)["minNotional"])
+	return common.ToDecimal((*filter)["minNotional"])
 }
 
 func GetMarketNotional(symbol string, qty float64) {
 	// TODO
 }
 
-func FilterCheck(symbol string, qty float64, price float64) common.FilterCheck {
-	if price != 0 && price < GetMinPrice(symbol) {
-		return common.FilterCheckMinPrice
-	}
-
-	if price != 0 && price > GetMaxPrice(symbol) {
-		return common.FilterCheckMaxPrice
-	}
-
-	// TODO debug tick size
-	//if price != 0 && math.Mod(price - GetMinPrice(symbol), GetTickSize(symbol)) != 0 {
-	//	return common.FilterCheckTickSize
-	//}
-
-	if price != 0 && price * qty < GetMinNotional(symbol) {
-		return common.FilterCheckMinNotional
-	}
-
-	if qty < GetMinQty(symbol) {
-		return common.FilterCheckMinQty
-	}
-
-	if qty > GetMaxQty(symbol) {
-		return common.FilterCheckMaxQty
-	}
-
-	// TODO debug step size
-	//if math.Mod(qty - GetMinQty(symbol), GetStepSize(symbol)) != 0 {
-	//	return common.FilterCheckStepSize
-	//}
-
-	return common.FilterCheckOk
-}
-
-// Rounds down qty based on stepSize
-func FormatQty(symbol string, qty float64) float64 {
-	stepSize := GetStepSize(symbol)
-	factor := 1.0
-	for stepSize != 1.0 {
-		factor = factor * 10.0
-		stepSize = stepSize * 10.0
+// Rounds down qty based on stepSize, false if qty does not fit into Decimal
+func FormatQty(symbol string, qty float64) (common.Decimal, bool) {
+	d, ok := common.DecimalFromFloat(qty)
+	if !ok {
+		return common.Decimal{}, false
 	}
 
-	qty = qty * factor
-	qty = math.Floor(qty)
-
-	return qty/factor
+	return d.FloorToStep(GetStepSize(symbol)), true
 }
 
 func GetFilter(symbol string, filterName string) *common.Filter {
-	filtersList := (*filtersMap)[symbol]
+	filtersList := getSnapshot().Filters[symbol]
 	for _, filter := range filtersList {
 		if strings.Compare(filter["filterType"].(string), filterName) == 0 {
 			return &filter
@@ -176,16 +141,16 @@ func GetFilter(symbol string, filterName string) *common.Filter {
 	panic("Filter " + filterName + " for " + symbol + " does not exist")
 }
 
+// Returns nil if there is no such pair or pairs are not fetched yet
+func getPair(symbol string) *common.CoinPair {
+	return getSnapshot().GetPair(symbol)
+}
+
 func HasPair(symbol string) bool {
-	if allPairs == nil {
+	snapshot := getSnapshot()
+	if snapshot.Pairs == nil {
 		panic("Pairs are not fetched")
 	}
 
-	for _, pair := range allPairs {
-		if strings.Compare(pair.PairSymbol, symbol) == 0 {
-			return true
-		}
-	}
-
-	return false
+	return snapshot.GetPair(symbol) != nil
 }
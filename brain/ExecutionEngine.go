@@ -0,0 +1,304 @@
+package brain
+
+import (
+	"midas/common/arb"
+	"midas/common"
+	"midas/configuration"
+	"midas/logging"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var executionConfig = makeExecutionConfig(brainConfig.EXECUTION)
+
+// Reason recovery orders are not sent for when DISABLE_RECOVERY is set
+const RECOVERY_DISABLED = "RECOVERY_DISABLED"
+
+// Legs are sent in parallel if strategy is missing, as they were before strategies were configurable
+func makeExecutionConfig(config *configuration.ExecutionConfig) *configuration.ExecutionConfig {
+	executionConfig := &configuration.ExecutionConfig{}
+	if config != nil {
+		*executionConfig = *config
+	}
+	switch arb.ExecutionStrategy(executionConfig.STRATEGY) {
+	case arb.StrategySequential, arb.StrategyParallel:
+	case "":
+		executionConfig.STRATEGY = string(arb.StrategyParallel)
+	default:
+		panic("Unknown execution strategy " + executionConfig.STRATEGY)
+	}
+
+	return executionConfig
+}
+
+// Sends legs with configured strategy, then trades whatever legs which did not fill left in other coins back to start coin
+func runExecution(execution *arb.Execution) {
+	setExecutionStatus(execution, arb.ExecutionRunning)
+	if execution.Strategy == arb.StrategySequential {
+		executeSequentially(execution)
+	} else {
+		executeInParallel(execution)
+	}
+
+	if !execution.AllLegsFilled() {
+		recoverLeftovers(execution)
+	}
+
+	execution.Finish(time.Now())
+	log.Println("Execution of " + execution.State.Id + " is " + string(execution.Status) + " | " + getExecutionSummary(execution))
+	publishArbEvent(arb.EventExecuted, execution.State, string(execution.Status))
+}
+
+func setExecutionStatus(execution *arb.Execution, status arb.ExecutionStatus) {
+	execution.Status = status
+	log.Println("Execution of " + execution.State.Id + " is " + string(status))
+}
+
+func executeInParallel(execution *arb.Execution) {
+	var wg sync.WaitGroup
+	for _, leg := range execution.Legs {
+		wg.Add(1)
+		go func(leg *arb.LegExecution) {
+			defer wg.Done()
+			sendExecutionOrder(execution, leg, getLegClientOrderId(execution, leg, ""))
+		}(leg)
+	}
+	wg.Wait()
+}
+
+// Each leg spends what previous one received and is never raised to min notional, so it does not dip into
+// balances other arbs may count on. Partially filled leg is carried on, so the part which filled is completed,
+// while legs after a leg which did not fill at all are skipped.
+func executeSequentially(execution *arb.Execution) {
+	for i, leg := range execution.Legs {
+		if i > 0 {
+			previous := execution.Legs[i - 1]
+			if previous.Status != arb.LegFilled && previous.Status != arb.LegPartiallyFilled {
+				leg.Skip("previous leg is " + string(previous.Status))
+				log.Println(execution.State.Id + " leg " + leg.Request.Symbol + " is skipped, previous leg is " + string(previous.Status))
+				continue
+			}
+			resized, ok := arb.ResizeOrder(leg.Request, previous.Received)
+			if !ok {
+				// Qty which does not fit into Decimal is above any LOT_SIZE max
+				leg.Skip(string(common.FilterCheckMaxQty))
+				log.Println(execution.State.Id + " leg " + leg.Request.Symbol + " is skipped, qty is out of range")
+				continue
+			}
+			if !normalizeExecutionOrder(getSnapshot(), leg, resized, common.Decimal{}) {
+				log.Println(execution.State.Id + " leg " + leg.Request.Symbol + " is skipped, did not pass " + leg.Error)
+				continue
+			}
+		}
+		sendExecutionOrder(execution, leg, getLegClientOrderId(execution, leg, ""))
+	}
+}
+
+// Normalizes request against snapshot, order is skipped if it can not pass filters or they are missing
+func normalizeExecutionOrder(snapshot *MarketSnapshot, order *arb.LegExecution, request *common.OrderRequest, available common.Decimal) bool {
+	normalized, err := normalizeOrder(snapshot, request, available)
+	if err != nil {
+		order.Request = request
+		order.Skip(err.Error())
+		return false
+	}
+	order.Request = normalized.Request
+	if normalized.Check != common.FilterCheckOk {
+		order.Skip(string(normalized.Check))
+		return false
+	}
+
+	return true
+}
+
+// Trades leftovers back to start coin with market orders, as getting out matters more than price.
+// Coins left over are sold (unwind) and coins spent but not received back are bought back (completion).
+func recoverLeftovers(execution *arb.Execution) {
+	leftovers := execution.Leftovers()
+	coins := make([]string, 0, len(leftovers))
+	for coin := range leftovers {
+		coins = append(coins, coin)
+	}
+	if len(coins) == 0 {
+		return
+	}
+	sort.Strings(coins)
+
+	if executionConfig.DISABLE_RECOVERY {
+		log.Println(execution.State.Id + " recovery is disabled, leftovers: " + getLeftoversString(leftovers))
+	} else {
+		setExecutionStatus(execution, arb.ExecutionRecovering)
+	}
+	startCoin := execution.StartCoin()
+	for _, coin := range coins {
+		recovery := makeRecovery(getSnapshot(), coin, startCoin, leftovers[coin])
+		// Dust is recorded either way, so it does not fail execution with recovery disabled
+		if executionConfig.DISABLE_RECOVERY && recovery.Status == arb.LegPending {
+			recovery.Skip(RECOVERY_DISABLED)
+		}
+		execution.Recoveries = append(execution.Recoveries, recovery)
+		if recovery.Status != arb.LegPending {
+			log.Println(execution.State.Id + " recovery of " + leftovers[coin].String() + " " + coin + " is " + string(recovery.Status) + ": " + recovery.Error)
+			continue
+		}
+		suffix := "R" + strconv.Itoa(len(execution.Recoveries))
+		sendExecutionOrder(execution, recovery, getLegClientOrderId(execution, recovery, suffix))
+	}
+}
+
+// Market order which trades qty of coin for start coin if qty is positive,
+// or start coin for -qty of coin if it is negative
+func makeRecovery(snapshot *MarketSnapshot, coin string, startCoin string, qty common.Decimal) *arb.LegExecution {
+	from, to := coin, startCoin
+	if qty.IsNegative() {
+		from, to = startCoin, coin
+	}
+
+	pair := snapshot.GetPair(coin + startCoin)
+	if pair == nil {
+		pair = snapshot.GetPair(startCoin + coin)
+	}
+	if pair == nil {
+		recovery := arb.NewRecoveryExecution(from, to, nil)
+		recovery.Fail(errors.New("no pair for " + coin + " and " + startCoin))
+		return recovery
+	}
+	ticker := snapshot.GetTicker(pair.PairSymbol)
+	if ticker == nil || !ticker.BidPrice.IsPositive() || !ticker.AskPrice.IsPositive() {
+		recovery := arb.NewRecoveryExecution(from, to, nil)
+		recovery.Fail(errors.New("no ticker for " + pair.PairSymbol))
+		return recovery
+	}
+
+	// Qty is in base coin, amounts of quote coin are converted at the price market order is expected to fill at
+	request := &common.OrderRequest{pair.PairSymbol, common.SideSell, common.TypeMarket, common.Decimal{}, common.Decimal{}}
+	isBase := pair.BaseCoin.CoinSymbol == coin
+	ok := true
+	switch {
+	case qty.IsPositive() && isBase:
+		request.Qty = qty
+	case qty.IsPositive():
+		request.Side = common.SideBuy
+		request.Qty, ok = qty.Div(ticker.AskPrice)
+	case isBase:
+		request.Side = common.SideBuy
+		request.Qty = qty.Neg()
+	default:
+		request.Qty, ok = qty.Neg().Div(ticker.BidPrice)
+	}
+
+	recovery := arb.NewRecoveryExecution(from, to, request)
+	if !ok {
+		recovery.Fail(errors.New("qty of " + pair.PairSymbol + " for " + qty.String() + " " + coin + " is out of range"))
+		return recovery
+	}
+	// Recovery must not trade more than leftovers, so qty is never raised to min notional.
+	// Leftover which rounds down below min qty or notional can not be traded at all.
+	if !normalizeExecutionOrder(snapshot, recovery, request, common.Decimal{}) && isDustCheck(recovery.Error) {
+		recovery.SkipAsDust(recovery.Error)
+	}
+
+	return recovery
+}
+
+func isDustCheck(check string) bool {
+	return check == string(common.FilterCheckMinNotional) || check == string(common.FilterCheckMinQty)
+}
+
+func getLegClientOrderId(execution *arb.Execution, order *arb.LegExecution, suffix string) string {
+	clientOrderId := order.Request.Symbol + "_" + strconv.FormatInt(common.UnixMillis(execution.StartTs), 10)
+	if suffix != "" {
+		clientOrderId += "_" + suffix
+	}
+
+	return clientOrderId
+}
+
+// Sends order and applies response to it. Status changes are recorded from execution reports,
+// only orders exchange did not accept are recorded here.
+func sendExecutionOrder(execution *arb.Execution, order *arb.LegExecution, clientOrderId string) {
+	state := execution.State
+	request := order.Request
+	order.ClientOrderId = clientOrderId
+	order.Status = arb.LegSent
+
+	orderManager.Add(clientOrderId, request.Symbol, state)
+	res, err := placeOrder(request, clientOrderId)
+	// Test orders are not reported
+	if err != nil || EXECUTION_MODE_TEST {
+		orderManager.Remove(clientOrderId)
+	}
+
+	if err == nil {
+		if !EXECUTION_MODE_TEST {
+			orderManager.ApplyResponse(res)
+		}
+		order.Apply(res)
+		log.Println("Order " + res.Symbol + " is " + string(order.Status))
+		return
+	}
+
+	order.Fail(err)
+	log.Println("Order " + request.Symbol + " error: " + err.Error())
+	timeInForce := common.IOC
+	if request.Type == common.TypeMarket {
+		timeInForce = ""
+	}
+	balanceA, balanceB, balanceC := getTriangleBalances(state)
+	// TODO report min notional reason
+	logging.QueueEvent(&logging.Event{
+		EventType: logging.EventTypeOrderStatusChange,
+		Value: &common.OrderStatusChangeEvent{
+			OrderStatus:        common.StatusError,
+			ArbStateId:         state.Id,
+			ClientOrderId: clientOrderId,
+			Symbol: request.Symbol,
+			Side: request.Side,
+			Type: request.Type,
+			Price: request.Price,
+			OrigQty: request.Qty,
+			TimeInForce: timeInForce,
+			Fills: make([]*common.Fill, 0),
+			ErrorMessage: err.Error(),
+			TransactTime: time.Now(),
+			BalanceA: balanceA,
+			BalanceB: balanceB,
+			BalanceC: balanceC,
+		},
+	})
+}
+
+func getExecutionSummary(execution *arb.Execution) string {
+	orders := make([]string, 0, len(execution.Legs) + len(execution.Recoveries))
+	for _, order := range append(append([]*arb.LegExecution{}, execution.Legs...), execution.Recoveries...) {
+		summary := order.From + "->" + order.To + " " + string(order.Status) +
+			" spent " + order.Spent.String() +
+			" received " + order.Received.String()
+		if order.Error != "" {
+			summary += " (" + order.Error + ")"
+		}
+		orders = append(orders, summary)
+	}
+
+	return strings.Join(orders, ", ") + " | Leftovers: " + getLeftoversString(execution.Leftovers())
+}
+
+func getLeftoversString(leftovers map[string]common.Decimal) string {
+	coins := make([]string, 0, len(leftovers))
+	for coin := range leftovers {
+		coins = append(coins, coin)
+	}
+	sort.Strings(coins)
+
+	amounts := make([]string, 0, len(coins))
+	for _, coin := range coins {
+		amounts = append(amounts, coin + ": " + leftovers[coin].String())
+	}
+
+	return strings.Join(amounts, ", ")
+}
@@ -0,0 +1,93 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/common/arb"
+	"testing"
+)
+
+func makeRecoverySnapshot() *MarketSnapshot {
+	return &MarketSnapshot{
+		Tickers: common.NewShardedTickers(common.TickersMap{
+			"ETHBTC": {
+				Symbol: "ETHBTC",
+				BidPrice: common.ToDecimal("0.0299"),
+				BidQty: common.ToDecimal("100"),
+				AskPrice: common.ToDecimal("0.0301"),
+				AskQty: common.ToDecimal("100"),
+			},
+		}),
+		Pairs: []*common.CoinPair{{
+			PairSymbol: "ETHBTC",
+			BaseCoin: common.Coin{CoinSymbol: "ETH"},
+			QuoteCoin: common.Coin{CoinSymbol: "BTC"},
+		}},
+		SymbolFilters: map[string]*common.SymbolFilters{
+			"ETHBTC": {
+				MinQty: common.ToDecimal("0.01"),
+				StepSize: common.ToDecimal("0.001"),
+				MinNotional: common.ToDecimal("0.0001"),
+				MinNotionalAppliesToMarket: true,
+			},
+		},
+	}
+}
+
+func TestMakeRecovery(t *testing.T) {
+	cases := []struct {
+		name string
+		coin string
+		startCoin string
+		qty string
+		from string
+		side common.OrderSide
+		expectedQty string
+	}{
+		// Qty is in base coin, quote coin is converted at ask for buys and at bid for sells
+		{"base surplus", "ETH", "BTC", "0.5", "ETH", common.SideSell, "0.5"},
+		{"base deficit", "ETH", "BTC", "-0.5", "BTC", common.SideBuy, "0.5"},
+		{"quote surplus", "BTC", "ETH", "0.0301", "BTC", common.SideBuy, "1"},
+		{"quote deficit", "BTC", "ETH", "-0.0299", "ETH", common.SideSell, "1"},
+		{"rounded down to step", "ETH", "BTC", "0.5009", "ETH", common.SideSell, "0.5"},
+	}
+
+	for _, c := range cases {
+		recovery := makeRecovery(makeRecoverySnapshot(), c.coin, c.startCoin, common.ToDecimal(c.qty))
+		if recovery.Status != arb.LegPending {
+			t.Errorf("%s: expected pending recovery, got %s: %s", c.name, recovery.Status, recovery.Error)
+			continue
+		}
+		request := recovery.Request
+		if recovery.From != c.from || request.Symbol != "ETHBTC" || request.Type != common.TypeMarket ||
+			request.Side != c.side || request.Qty.String() != c.expectedQty {
+			t.Errorf("%s: unexpected recovery from %s: %s", c.name, recovery.From, request.String())
+		}
+	}
+}
+
+func TestMakeRecoveryNotSent(t *testing.T) {
+	cases := []struct {
+		name string
+		coin string
+		qty string
+		status arb.LegStatus
+	}{
+		{"below min notional", "ETH", "0.002", arb.LegDust},
+		// Above min notional, below min qty, can not be traded either
+		{"below min qty", "ETH", "0.005", arb.LegDust},
+		{"no pair", "XRP", "10", arb.LegFailed},
+	}
+
+	for _, c := range cases {
+		recovery := makeRecovery(makeRecoverySnapshot(), c.coin, "BTC", common.ToDecimal(c.qty))
+		if recovery.Status != c.status {
+			t.Errorf("%s: expected %s, got %s: %s", c.name, c.status, recovery.Status, recovery.Error)
+		}
+	}
+
+	snapshot := makeRecoverySnapshot()
+	snapshot.Tickers = common.NewShardedTickers(nil)
+	if recovery := makeRecovery(snapshot, "ETH", "BTC", common.ToDecimal("0.5")); recovery.Status != arb.LegFailed {
+		t.Errorf("expected recovery without ticker to fail, got %s", recovery.Status)
+	}
+}
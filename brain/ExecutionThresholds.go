@@ -0,0 +1,109 @@
+package brain
+
+import (
+	"midas/common/arb"
+	"midas/common"
+	"midas/configuration"
+	"sync"
+	"time"
+	"strconv"
+)
+
+const (
+	DEFAULT_MIN_RELATIVE_PROFIT = 0.0001
+	DEFAULT_REFERENCE_ASSET = "BTC"
+	// Weight of the latest frame in smoothed frame age
+	FRAME_AGE_SMOOTHING = 0.1
+)
+
+var executionThresholds = makeExecutionThresholds(brainConfig.EXECUTION_THRESHOLDS)
+
+// Smoothed time from tickers request to tickers frame being applied
+var frameAge time.Duration
+var frameAgeMux sync.Mutex
+
+// Fills values missing in config with defaults which match previous hardcoded behaviour
+func makeExecutionThresholds(config *configuration.ExecutionThresholdsConfig) *configuration.ExecutionThresholdsConfig {
+	thresholds := &configuration.ExecutionThresholdsConfig{}
+	if config != nil {
+		*thresholds = *config
+	}
+	if thresholds.FEE == 0 {
+		thresholds.FEE = BINANCE_BNB_FEE
+	}
+	if thresholds.MIN_RELATIVE_PROFIT == 0 {
+		thresholds.MIN_RELATIVE_PROFIT = DEFAULT_MIN_RELATIVE_PROFIT
+	}
+	if thresholds.REFERENCE_ASSET == "" {
+		thresholds.REFERENCE_ASSET = DEFAULT_REFERENCE_ASSET
+	}
+
+	return thresholds
+}
+
+func recordFrameAge(age time.Duration) {
+	frameAgeMux.Lock()
+	defer frameAgeMux.Unlock()
+	if frameAge == 0 {
+		frameAge = age
+		return
+	}
+	frameAge = time.Duration(FRAME_AGE_SMOOTHING * float64(age) + (1 - FRAME_AGE_SMOOTHING) * float64(frameAge))
+}
+
+func getFrameAge() time.Duration {
+	frameAgeMux.Lock()
+	defer frameAgeMux.Unlock()
+	return frameAge
+}
+
+// Returns name and details of the threshold state does not pass with orders, empty string if it passes all.
+// Orders are state's leg orders after normalization, in leg order.
+func checkProfitThresholds(state *arb.State, orders []*common.OrderRequest) (string, string) {
+	qtyBefore, qtyAfter := arb.GetOrdersQtys(state.Legs, orders, executionThresholds.FEE)
+	if qtyBefore <= 0 {
+		return "MIN_RELATIVE_PROFIT", "orders spend nothing"
+	}
+	profitRelative := qtyAfter / qtyBefore - 1.0
+	frameAgeMs := float64(getFrameAge()) / float64(time.Millisecond)
+	slippageBuffer := executionThresholds.SLIPPAGE_PER_LEG * float64(len(state.Legs))
+	latencyPenalty := executionThresholds.LATENCY_PENALTY_PER_MS * frameAgeMs
+	profit := profitRelative - slippageBuffer - latencyPenalty
+	details := "profit " + common.FloatToString(profitRelative) +
+		" - slippage buffer " + common.FloatToString(slippageBuffer) +
+		" - latency penalty " + common.FloatToString(latencyPenalty) +
+		" (frame age " + strconv.FormatFloat(frameAgeMs, 'f', 1, 64) + "ms)" +
+		" = " + common.FloatToString(profit)
+
+	if profit <= executionThresholds.MIN_RELATIVE_PROFIT {
+		return "MIN_RELATIVE_PROFIT", details + " <= " + common.FloatToString(executionThresholds.MIN_RELATIVE_PROFIT)
+	}
+
+	if executionThresholds.MIN_ABSOLUTE_PROFIT > 0 && len(state.Legs) > 0 {
+		startCoin := state.Legs[0].From.CoinSymbol
+		valuation, err := valueIn(profit * qtyBefore, startCoin, executionThresholds.REFERENCE_ASSET)
+		if err != nil {
+			return "MIN_ABSOLUTE_PROFIT", err.Error()
+		}
+		absoluteProfit := valuation.Mid
+		if absoluteProfit <= executionThresholds.MIN_ABSOLUTE_PROFIT {
+			return "MIN_ABSOLUTE_PROFIT", details + ", " + common.FloatToString(absoluteProfit) + " " + executionThresholds.REFERENCE_ASSET +
+				" <= " + common.FloatToString(executionThresholds.MIN_ABSOLUTE_PROFIT)
+		}
+	}
+
+	return "", ""
+}
+
+// Expresses profit of a new arb state in reference asset, so arbs starting with different coins compare
+func valueArbState(state *arb.State) {
+	if len(state.Legs) == 0 {
+		return
+	}
+	valuation, err := valueIn(state.QtyAfter - state.QtyBefore, state.Legs[0].From.CoinSymbol, executionThresholds.REFERENCE_ASSET)
+	if err != nil {
+		return
+	}
+	state.ProfitInRef = valuation.Mid
+	state.RefAsset = valuation.RefAsset
+}
@@ -0,0 +1,100 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/configuration"
+	"time"
+	"log"
+	"strconv"
+	"sync"
+	"reflect"
+)
+
+const EYE_SETTINGS_UPDATE_PERIOD_MIN = 1
+
+// Settings currently pushed to eyes, nil if brain config has no eye_settings section
+var eyeSettings *common.EyeSettings
+var eyeSettingsConfig *configuration.EyeSettingsConfig
+var eyeSettingsMux sync.Mutex
+
+// Pushes settings from brain config to eyes and re-reads config periodically so
+// changes reach connected eyes without restarting them
+func RunEyeSettingsUpdates() {
+	updateEyeSettings(brainConfig.EYE_SETTINGS)
+	go func() {
+		for {
+			time.Sleep(time.Duration(EYE_SETTINGS_UPDATE_PERIOD_MIN) * time.Minute)
+			config, err := configuration.ReloadBrainConfig()
+			if err != nil {
+				log.Println("Unable to reload eye settings: " + err.Error())
+				continue
+			}
+			updateEyeSettings(config.EYE_SETTINGS)
+		}
+	}()
+}
+
+func updateEyeSettings(config *configuration.EyeSettingsConfig) {
+	eyeSettingsMux.Lock()
+	if config == nil || reflect.DeepEqual(config, eyeSettingsConfig) {
+		eyeSettingsMux.Unlock()
+		return
+	}
+
+	version := int64(1)
+	if eyeSettings != nil {
+		version = eyeSettings.Version + 1
+	}
+	eyeSettingsConfig = config
+	eyeSettings = &common.EyeSettings{
+		Version: version,
+		Symbols: config.SYMBOLS,
+		RequestTimeoutMillis: config.REQUEST_TIMEOUT_MILLIS,
+		UseWebSocketStreams: config.USE_WEBSOCKET_STREAMS,
+	}
+	eyeSettingsMux.Unlock()
+
+	log.Println("Eye settings changed, pushing version " + strconv.FormatInt(version, 10))
+	for _, eyeHandle := range getEyeHandles() {
+		if eyeHandle.IsReady() {
+			pushEyeSettings(eyeHandle)
+		}
+	}
+}
+
+func pushEyeSettings(eyeHandle *EyeHandle) {
+	eyeSettingsMux.Lock()
+	settings := eyeSettings
+	eyeSettingsMux.Unlock()
+
+	if settings == nil {
+		return
+	}
+
+	message := common.Message{
+		common.SET_SETTINGS,
+		map[string]string{
+			common.EYE_SETTINGS_SERIALIZED: settings.Serialize(),
+			common.SETTINGS_VERSION: strconv.FormatInt(settings.Version, 10),
+		},
+		nil,
+		"",
+	}
+	eyeHandle.Send(&message)
+}
+
+func handleSettingsAck(eyeId int, versionStr string, err string) {
+	if err != "" {
+		log.Println("Eye " + strconv.Itoa(eyeId) + " failed to apply settings version " + versionStr + ": " + err)
+		return
+	}
+
+	version, parseErr := strconv.ParseInt(versionStr, 10, 64)
+	if parseErr != nil {
+		log.Println("Bad settings ack from eye " + strconv.Itoa(eyeId) + ": " + parseErr.Error())
+		return
+	}
+
+	getEyeHandle(eyeId).setSettingsVersion(version)
+	log.Println("Eye " + strconv.Itoa(eyeId) + " applied settings version " + versionStr)
+}
@@ -4,14 +4,21 @@ import (
 	"github.com/pebbe/zmq4"
 	"strconv"
 	"midas/common"
+	"midas/common/arb"
 	"time"
-	"encoding/json"
 	"log"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"midas/apis/binance"
 )
 
 const (
 	INPUT_BUFFER_SIZE = 10000
 	TCP_PREFIX = "tcp://*:"
+	EYES_CLEANUP_TIMEOUT_SEC = 5
+	// How often receiving routines check whether eyes handler is stopped
+	RECEIVE_TIMEOUT_MILLIS = 500
 )
 
 type EyeState int
@@ -29,6 +36,94 @@ type EyeHandle struct {
 	EyeId int
 	PortPair *PortPair
 	EyeState EyeState
+	TickersFrameDecoders map[string]*TickersFrameDecoder // exchange -> decoder, eyes encode frames per exchange
+	SettingsVersion int64 // last settings version acknowledged by eye
+	InDone chan struct{} // closed when ChannelIn is drained and SocketIn is closed
+	OutDone chan struct{} // closed when receiving routine exits and SocketOut is closed
+	WeightBudget *WeightBudget
+	closed bool
+	mux sync.RWMutex
+}
+
+// Queues message for the eye, returns false if eye handle is already closed
+func (h *EyeHandle) Send(message *common.Message) bool {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	if h.closed {
+		return false
+	}
+	*h.ChannelIn<-message.SerializeMessage()
+	return true
+}
+
+func (h *EyeHandle) GetTickersFrameDecoder(exchange string) *TickersFrameDecoder {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	decoder, ok := h.TickersFrameDecoders[exchange]
+	if !ok {
+		decoder = NewTickersFrameDecoder()
+		h.TickersFrameDecoders[exchange] = decoder
+	}
+
+	return decoder
+}
+
+// Sends last message and stops accepting new ones, socket is closed once channel is drained
+func (h *EyeHandle) Close(lastMessage *common.Message) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.closed {
+		return
+	}
+	*h.ChannelIn<-lastMessage.SerializeMessage()
+	h.closed = true
+	close(*h.ChannelIn)
+}
+
+// Marks in or out connection as confirmed, returns true when the other one is confirmed too
+func (h *EyeHandle) confirmConnection(confirmed EyeState) bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	switch {
+	case h.EyeState == NOT_READY:
+		h.EyeState = confirmed
+	case h.EyeState != confirmed && h.EyeState != READY:
+		h.EyeState = READY
+		return true
+	}
+
+	return false
+}
+
+func (h *EyeHandle) IsReady() bool {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	return h.EyeState == READY
+}
+
+func (h *EyeHandle) setSettingsVersion(version int64) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.SettingsVersion = version
+}
+
+// Handles of connected eyes, safe to iterate while new eyes connect
+func getEyeHandles() []*EyeHandle {
+	eyesMux.RLock()
+	defer eyesMux.RUnlock()
+	handles := make([]*EyeHandle, 0, len(eyes))
+	for _, eyeHandle := range eyes {
+		handles = append(handles, eyeHandle)
+	}
+
+	return handles
+}
+
+// Nil if there is no eye with such id
+func getEyeHandle(eyeId int) *EyeHandle {
+	eyesMux.RLock()
+	defer eyesMux.RUnlock()
+	return eyes[eyeId]
 }
 
 type PortPair struct {
@@ -41,11 +136,9 @@ type AvgFetchTimeStat struct {
 	NumSamples int
 }
 
-// No need for mutex as we simply update this variable with a new map instance on each write
-// TODO decide where this belongs (ExchangeInfoManager?)
-var tickersMap *common.TickersMap
-
 var eyes = make(map[int]*EyeHandle)
+var eyesMux sync.RWMutex
+var eyesHandlerStopped int32 = 0
 var lastConnectedEyeId = -1
 
 var pairsPerExchange = map[string][]string{
@@ -57,42 +150,67 @@ var lastReqSentTs = time.Now()
 
 // TODO merge depth and ticker updates in a single function
 func ScheduleDepthUpdates() {
-		for exchange, pairList := range pairsPerExchange {
-			go func() {
-				var pairIndex = 0
-				for {
-					for _, eyeHandle := range eyes {
-						pair := pairList[pairIndex]
-						delay := getDelayMicroSeconds(common.DEPTH_REQ, exchange)
-						message := common.Message{
-							common.DEPTH_REQ,
-							map[string]string{
-								common.CURRENCY_PAIR: pair,
-								common.EXCHANGE: exchange,
-							},
-							&common.TraceInfo{
-								BrainReqSentTs: time.Now(),
-							},
-							"",
-						}
-						time.Sleep(time.Duration(delay) * time.Microsecond)
-						*eyeHandle.ChannelIn<-message.SerializeMessage()
-						pairIndex++
-						if pairIndex == len(pairList) {
-							pairIndex = 0
+	for exchange := range pairsPerExchange {
+		go func(exchange string) {
+			var pairIndex = 0
+			for atomic.LoadInt32(&eyesHandlerStopped) == 0 {
+				pairList := getDepthWatchlist()
+				if len(pairList) == 0 {
+					time.Sleep(DEPTH_WATCHLIST_IDLE_MILLIS * time.Millisecond)
+					continue
+				}
+				sent := false
+				// Earliest time an eye over budget may take a request again
+				var availableAt time.Time
+				for _, eyeHandle := range getEyeHandles() {
+					if pairIndex >= len(pairList) {
+						pairIndex = 0
+					}
+					pair := pairList[pairIndex]
+					delay := getDelayMicroSeconds(common.DEPTH_REQ, exchange)
+					message := common.Message{
+						common.DEPTH_REQ,
+						map[string]string{
+							common.CURRENCY_PAIR: pair,
+							common.EXCHANGE: exchange,
+						},
+						&common.TraceInfo{
+							BrainReqSentTs: time.Now(),
+						},
+						"",
+					}
+					time.Sleep(time.Duration(delay) * time.Microsecond)
+					weight := binance.GetDepthRequestWeight(binance.MAX_DEPTH)
+					if !eyeHandle.WeightBudget.TryReserve(weight) {
+						// Eye is out of weight for now, pair goes to the next eye
+						if at := eyeHandle.WeightBudget.AvailableAt(weight, time.Now()); availableAt.IsZero() || at.Before(availableAt) {
+							availableAt = at
 						}
+						continue
 					}
+					message.Args[common.WEIGHT_RESERVED] = strconv.FormatInt(weight, 10)
+					eyeHandle.Send(&message)
+					pairIndex++
+					sent = true
 				}
-			} ()
-		}
+				if !sent && !availableAt.IsZero() {
+					// Every eye is out of weight, nothing can be sent until the first window resets
+					time.Sleep(time.Until(availableAt))
+				}
+			}
+		} (exchange)
+	}
 }
 
 // TODO merge depth and ticker updates in a single function
 func ScheduleTickerUpdates() {
 	for exchange, _ := range pairsPerExchange {
-		go func() {
-			for {
-				for _, eyeHandle := range eyes {
+		go func(exchange string) {
+			for atomic.LoadInt32(&eyesHandlerStopped) == 0 {
+				sent := false
+				// Earliest time an eye over budget may take a request again
+				var availableAt time.Time
+				for _, eyeHandle := range getEyeHandles() {
 					delay := getDelayMicroSeconds(common.TICKERS_MAP_REQ, exchange)
 					message := common.Message{
 						common.TICKERS_MAP_REQ,
@@ -104,22 +222,42 @@ func ScheduleTickerUpdates() {
 						},
 						"",
 					}
+					if eyeHandle.GetTickersFrameDecoder(exchange).NeedsKeyframe() {
+						message.Args[common.FORCE_KEYFRAME] = "true"
+					}
 					time.Sleep(time.Duration(delay) * time.Microsecond)
-					*eyeHandle.ChannelIn<-message.SerializeMessage()
+					// Weight budgets follow Binance rate limits
+					if exchange == common.BINANCE {
+						if !eyeHandle.WeightBudget.TryReserve(binance.ALL_TICKERS_REQUEST_WEIGHT) {
+							if at := eyeHandle.WeightBudget.AvailableAt(binance.ALL_TICKERS_REQUEST_WEIGHT, time.Now()); availableAt.IsZero() || at.Before(availableAt) {
+								availableAt = at
+							}
+							continue
+						}
+						message.Args[common.WEIGHT_RESERVED] = strconv.Itoa(binance.ALL_TICKERS_REQUEST_WEIGHT)
+					}
+					eyeHandle.Send(&message)
+					sent = true
+				}
+				if !sent && !availableAt.IsZero() {
+					// Every eye is out of weight, nothing can be sent until the first window resets
+					time.Sleep(time.Until(availableAt))
 				}
 			}
-		} ()
+		} (exchange)
 	}
 }
 
 func getDelayMicroSeconds(command string, exchange string) int {
 	switch command {
-	case common.TICKERS_MAP_REQ:
-		numEyes := len(eyes)
+	case common.TICKERS_MAP_REQ, common.DEPTH_REQ:
+		numEyes := len(getEyeHandles())
+		if numEyes == 0 {
+			return 0
+		}
 		delay := int(brainConfig.FETCH_DELAYS_MICROS[exchange][command]/numEyes)
 		//log.Println("Delay: " + strconv.Itoa(delay) + "micros")
 		return delay
-		// TODO handle depth
 	default:
 		return 0
 	}
@@ -129,6 +267,9 @@ func SetupRequestReceiver() {
 	go func() {
 		requestReceiver, _ := zmq4.NewSocket(zmq4.REP)
 		requestReceiver.Bind(TCP_PREFIX + strconv.Itoa(brainConfig.CONNECTION_RECEIVER_PORT))
+		if brainConfig.IN_PROCESS_EYES > 0 {
+			requestReceiver.Bind(common.InprocEndpoint(brainConfig.CONNECTION_RECEIVER_PORT))
+		}
 		for {
 			request, error := requestReceiver.Recv(0)
 			if error != nil {
@@ -154,23 +295,33 @@ func handleNewConnectionRequest(requestReceiver *zmq4.Socket, requestSerialized
 		// first connection
 		portIn = brainConfig.BASE_PORT
 	} else {
-		lastEyeHandle := eyes[lastConnectedEyeId]
+		lastEyeHandle := getEyeHandle(lastConnectedEyeId)
 		portIn = lastEyeHandle.PortPair.PortIn + 2
 	}
 	portOut := portIn + 1
 	portPair := PortPair{portIn, portOut}
+	inProcess := request.Args[common.TRANSPORT] == common.TRANSPORT_INPROC
 	channelIn := make(chan string, INPUT_BUFFER_SIZE)
-	socketIn := setupInSocket(portIn, eyeId, &channelIn)
-	socketOut := setupOutSocket(portOut, eyeId)
+	inDone := make(chan struct{})
+	outDone := make(chan struct{})
+	socketIn := setupInSocket(portIn, eyeId, &channelIn, inDone, inProcess)
+	socketOut := setupOutSocket(portOut, eyeId, outDone, inProcess)
 	eyeHandle := EyeHandle{
-		&channelIn,
-		socketIn,
-		socketOut,
-		eyeId,
-		&portPair,
-		NOT_READY,
+		ChannelIn: &channelIn,
+		SocketIn: socketIn,
+		SocketOut: socketOut,
+		EyeId: eyeId,
+		PortPair: &portPair,
+		EyeState: NOT_READY,
+		TickersFrameDecoders: make(map[string]*TickersFrameDecoder),
+		SettingsVersion: 0,
+		InDone: inDone,
+		OutDone: outDone,
+		WeightBudget: NewWeightBudget(),
 	}
+	eyesMux.Lock()
 	eyes[eyeId] = &eyeHandle
+	eyesMux.Unlock()
 	message := common.Message{
 		common.CONFIRM_PORTS,
 		map[string]string{
@@ -184,31 +335,70 @@ func handleNewConnectionRequest(requestReceiver *zmq4.Socket, requestSerialized
 	lastConnectedEyeId = eyeId
 }
 
+// Stops scheduling requests to eyes. From then on messages which can not be delivered right away,
+// e.g. to eyes which are already gone, are dropped rather than blocking cleanup.
+func StopEyesHandler() {
+	atomic.StoreInt32(&eyesHandlerStopped, 1)
+}
+
+// Sends KILL_EYE to all eyes and waits for their channels to drain.
+// Out sockets are owned by receiving routines and are released on exit
 func CleanupEyesHandler() {
-	for eyeId := range eyes {
-		eyeInterface := eyes[eyeId]
+	StopEyesHandler()
+	for _, eyeInterface := range getEyeHandles() {
 		message := common.Message{
 			common.KILL_EYE,
 			nil,
 			nil,
 			"",
 		}
-		*eyeInterface.ChannelIn<-message.SerializeMessage()
-		// TODO block until channel is empty
-		eyeInterface.SocketIn.Close()
-		eyeInterface.SocketOut.Close()
+		eyeInterface.Close(&message)
+	}
+
+	timeout := time.After(time.Duration(EYES_CLEANUP_TIMEOUT_SEC) * time.Second)
+	for _, eyeInterface := range getEyeHandles() {
+		select {
+		case <-eyeInterface.InDone:
+		case <-timeout:
+			log.Println("Timed out draining eye " + strconv.Itoa(eyeInterface.EyeId))
+			return
+		}
+		select {
+		case <-eyeInterface.OutDone:
+		case <-timeout:
+			log.Println("Timed out closing out socket of eye " + strconv.Itoa(eyeInterface.EyeId))
+			return
+		}
+	}
+	log.Println("Eyes handler cleaned up")
+}
+
+func bindEndpoint(port int, inProcess bool) string {
+	if inProcess {
+		return common.InprocEndpoint(port)
 	}
+
+	return TCP_PREFIX + strconv.Itoa(port)
 }
 
-func setupOutSocket(portOut int, eyeId int) *zmq4.Socket {
+func setupOutSocket(portOut int, eyeId int, outDone chan struct{}, inProcess bool) *zmq4.Socket {
 	out, _ := zmq4.NewSocket(zmq4.PULL)
-	out.Bind(TCP_PREFIX + strconv.Itoa(portOut))
+	out.Bind(bindEndpoint(portOut, inProcess))
+	out.SetRcvtimeo(time.Duration(RECEIVE_TIMEOUT_MILLIS) * time.Millisecond)
 	log.Println("Waiting for eye " + strconv.Itoa(eyeId) + " to confirm out connection on port " + strconv.Itoa(portOut))
 	go func(){
-		for {
+		defer close(outDone)
+		defer out.Close()
+		for atomic.LoadInt32(&eyesHandlerStopped) == 0 {
 			msg, error := out.Recv(0)
 			if error != nil {
+				if zmq4.AsErrno(error) == zmq4.Errno(syscall.EAGAIN) {
+					continue
+				}
 				log.Println("Out error: " + error.Error())
+				return
+			}
+			if msg == "" {
 				continue
 			}
 			go func() {
@@ -220,18 +410,26 @@ func setupOutSocket(portOut int, eyeId int) *zmq4.Socket {
 	return out
 }
 
-func setupInSocket(portIn int, eyeId int, channelIn *chan string) *zmq4.Socket {
+func setupInSocket(portIn int, eyeId int, channelIn *chan string, inDone chan struct{}, inProcess bool) *zmq4.Socket {
 	in, _ := zmq4.NewSocket(zmq4.PUSH)
-	in.Bind(TCP_PREFIX + strconv.Itoa(portIn))
+	in.Bind(bindEndpoint(portIn, inProcess))
 	log.Println("Waiting for eye " + strconv.Itoa(eyeId) + " to confirm in connection on port " + strconv.Itoa(portIn))
 	go func(){
-		for {
-			msg := <-*channelIn
-			_ , error := in.Send(msg,0)
+		for msg := range *channelIn {
+			flags := zmq4.Flag(0)
+			if atomic.LoadInt32(&eyesHandlerStopped) == 1 {
+				flags = zmq4.DONTWAIT
+			}
+			_ , error := in.Send(msg, flags)
 			if error != nil {
 				log.Println("In error: " + error.Error())
 			}
 		}
+
+		// Channel is closed and drained, give socket time to deliver queued messages
+		in.SetLinger(time.Duration(EYES_CLEANUP_TIMEOUT_SEC) * time.Second)
+		in.Close()
+		close(inDone)
 	}()
 
 	return in
@@ -243,48 +441,99 @@ func handleMessage(messageSerialized string, eyeId int) {
 	args := message.Args
 	err := message.ErrorMsg
 	switch command {
-	case common.DEPTH_RESP:
-		depthSerialized := args[common.DEPTH_SERIALIZED]
-		depth := common.DeserializeDepth(depthSerialized)
-		dj, _ := json.Marshal(depth)
-		log.Println("Received depth update: " + string(dj))
+	case common.DEPTH_RESP, common.TICKERS_MAP_RESP:
+		settleEyeWeight(eyeId, args)
+	}
 
-	case common.TICKERS_MAP_RESP:
-		// TODO generalize to all
-		if lastReqSentTs.After(message.TraceInfo.BrainReqSentTs) {
-			// Frame dropped
+	switch command {
+	case common.DEPTH_RESP:
+		if err != "" {
+			log.Println("Error from eye " + strconv.Itoa(eyeId) + ": " + err)
 			return
 		}
+		depth := common.DeserializeDepth(args[common.DEPTH_SERIALIZED])
+		updateDepth(args[common.CURRENCY_PAIR], depth)
 
+	case common.TICKERS_MAP_RESP:
 		// TODO proper error handling
 		if err != "" {
 			log.Println("Error from eye " + strconv.Itoa(eyeId) + ": " + err)
 			return
 		}
 
-		updateFrameCounters()
-		tickersMapSerialized := args[common.TICKERS_MAP_SERIALIZED]
-		tickersMap = common.DeserializeTickersMap(tickersMapSerialized)
+		exchange := args[common.EXCHANGE]
+		if exchange == "" {
+			exchange = common.BINANCE
+		}
+
+		// Frame has to be decoded even if dropped, following deltas are based on it
+		decodeErr := getEyeHandle(eyeId).GetTickersFrameDecoder(exchange).ApplyFrame(args, func(eyeTickersMap *common.TickersMap) {
+			// TODO generalize to all
+			if lastReqSentTs.After(message.TraceInfo.BrainReqSentTs) {
+				// Frame dropped
+				return
+			}
+
+			if exchange != common.BINANCE {
+				updateExchangeTickersMap(exchange, eyeTickersMap)
+				return
+			}
+
+			recordFrameAge(time.Since(message.TraceInfo.BrainReqSentTs))
+			updateTickersMap(eyeTickersMap, &arb.FrameSighting{Ts: time.Now(), EyeId: eyeId})
+		})
+		if decodeErr != nil {
+			log.Println("Error decoding tickers frame from eye " + strconv.Itoa(eyeId) + ": " + decodeErr.Error())
+		}
+
+	case common.SETTINGS_ACK:
+		handleSettingsAck(eyeId, args[common.SETTINGS_VERSION], err)
+
+	case common.STATUS_RESP:
+		handleEyeStatus(eyeId, args[common.EYE_STATUS_SERIALIZED])
 
 	case common.CONF_OUT:
 		log.Println("Eye " + strconv.Itoa(eyeId) + " confirmed out")
-		eyeState := eyes[eyeId].EyeState
-		switch eyeState {
-		case NOT_READY:
-			eyes[eyeId].EyeState = OUT_READY
-		case IN_READY:
-			eyes[eyeId].EyeState = READY
+		eyeHandle := getEyeHandle(eyeId)
+		if eyeHandle.confirmConnection(OUT_READY) {
 			log.Println("Eye " + strconv.Itoa(eyeId) + " is ready")
+			pushEyeSettings(eyeHandle)
 		}
 	case common.CONF_IN:
 		log.Println("Eye " + strconv.Itoa(eyeId) + " confirmed in")
-		eyeState := eyes[eyeId].EyeState
-		switch eyeState {
-		case NOT_READY:
-			eyes[eyeId].EyeState = IN_READY
-		case OUT_READY:
-			eyes[eyeId].EyeState = READY
+		eyeHandle := getEyeHandle(eyeId)
+		if eyeHandle.confirmConnection(IN_READY) {
 			log.Println("Eye " + strconv.Itoa(eyeId) + " is ready")
+			pushEyeSettings(eyeHandle)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// Publishes snapshot with tickers from source, notifies detector and returns symbols that changed.
+// Frame is nil for tickers brain fetched itself. Frame which changes nothing is published too,
+// arbs which still hold in it are confirmed by it.
+func updateTickersMap(source *common.TickersMap, frame *arb.FrameSighting) []string {
+	var delta *common.TickersDelta
+	published := updateSnapshot(func(next *MarketSnapshot) bool {
+		delta = next.Tickers.Diff(source)
+		next.TickersFrame = frame
+		if delta.IsEmpty() {
+			return frame != nil
+		}
+		next.Tickers = next.Tickers.WithDelta(delta)
+		return true
+	})
+
+	if !published {
+		return nil
+	}
+
+	changed := make([]string, 0, len(delta.Updated) + len(delta.Removed))
+	for symbol := range delta.Updated {
+		changed = append(changed, symbol)
+	}
+	changed = append(changed, delta.Removed...)
+	notifyTickersChanged(changed)
+
+	return changed
+}
@@ -0,0 +1,90 @@
+package brain
+
+import (
+	"midas/common"
+	"time"
+	"log"
+	"strconv"
+	"sync"
+	"sort"
+	"fmt"
+)
+
+const EYES_STATUS_UPDATE_PERIOD_MIN = 1
+
+type EyeStatusRecord struct {
+	EyeId int
+	Status *common.EyeStatus
+	ReceivedTs time.Time
+}
+
+// eyeId -> *EyeStatusRecord
+var eyeStatuses = sync.Map{}
+
+func RunEyesStatusUpdates() {
+	go func() {
+		for {
+			time.Sleep(time.Duration(EYES_STATUS_UPDATE_PERIOD_MIN) * time.Minute)
+			logFleetStatus()
+			RequestEyesStatus()
+		}
+	}()
+}
+
+func RequestEyesStatus() {
+	for _, eyeHandle := range getEyeHandles() {
+		message := common.Message{
+			common.STATUS_REQ,
+			nil,
+			&common.TraceInfo{
+				BrainReqSentTs: time.Now(),
+			},
+			"",
+		}
+		eyeHandle.Send(&message)
+	}
+}
+
+func handleEyeStatus(eyeId int, statusSerialized string) {
+	eyeStatuses.Store(eyeId, &EyeStatusRecord{
+		EyeId: eyeId,
+		Status: common.DeserializeEyeStatus(statusSerialized),
+		ReceivedTs: time.Now(),
+	})
+}
+
+// Returns latest known status of every eye that responded, sorted by eye id
+func GetFleetStatus() []*EyeStatusRecord {
+	records := make([]*EyeStatusRecord, 0)
+	eyeStatuses.Range(func(k, v interface{}) bool {
+		records = append(records, v.(*EyeStatusRecord))
+		return true
+	})
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].EyeId < records[j].EyeId
+	})
+
+	return records
+}
+
+func logFleetStatus() {
+	records := GetFleetStatus()
+	log.Println("Fleet status: " + strconv.Itoa(len(records)) + " of " + strconv.Itoa(len(getEyeHandles())) + " eyes reported")
+	for _, record := range records {
+		status := record.Status
+		log.Println(fmt.Sprintf(
+			"Eye %d | Version: %s | Settings version: %d | Uptime: %s | Requests: %v | Errors: %v | Avg latency micros: %v | Max latency micros: %v | Used weight: %v | Budget used: %v | Reported %s ago",
+			record.EyeId,
+			status.Version,
+			status.SettingsVersion,
+			status.Uptime.String(),
+			status.RequestCounts,
+			status.ErrorCounts,
+			status.AvgLatencyMicros,
+			status.MaxLatencyMicros,
+			status.UsedWeightHeaders,
+			getEyeHandle(record.EyeId).WeightBudget.Usage(),
+			time.Since(record.ReceivedTs).String(),
+		))
+	}
+}
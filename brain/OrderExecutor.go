@@ -3,12 +3,8 @@ package brain
 import (
 	"midas/common/arb"
 	"midas/common"
-	"time"
-	"midas/logging"
-	"midas/apis/binance"
-	"sync/atomic"
-	"strconv"
 	"log"
+	"sync"
 )
 
 const EXECUTION_MODE_TEST = false
@@ -44,197 +40,136 @@ const EXECUTION_MODE_TEST = false
 //	rl.numTriggers++
 //}
 
-//TODO IMPLEMENT THIS
-//type ExecutableStates struct {
-//	coins sync.Map
-//	mux sync.Mutex
-//}
-//
-//func (ec *ExecutableStates) HasOverlapOrStore(state *arb.State) bool {
-//	ec.mux.Lock()
-//	defer ec.mux.Unlock()
-//	_, hasA := ec.coins.LoadOrStore(state.Triangle.CoinA.CoinSymbol, true)
-//	_, hasB := ec.coins.LoadOrStore(state.Triangle.CoinB.CoinSymbol, true)
-//	_, hasC := ec.coins.LoadOrStore(state.Triangle.CoinC.CoinSymbol, true)
-//
-//	return hasA || hasB || hasC
-//}
-//
-//func (ec *ExecutableStates) Delete(state *arb.State) {
-//	ec.mux.Lock()
-//	defer ec.mux.Unlock()
-//	ec.coins.Delete(state.Triangle.CoinA.CoinSymbol)
-//	ec.coins.Delete(state.Triangle.CoinB.CoinSymbol)
-//	ec.coins.Delete(state.Triangle.CoinC.CoinSymbol)
-//}
-//
-//var atomicExecutingStates = &ExecutableStates{
-//	coins: sync.Map{},
-//	mux: sync.Mutex{},
-//}
+var inFlightOrders sync.WaitGroup
+var executionStopped = false
+var executionMux sync.Mutex
 
-var routineCounter int64 = 0
-var isBusy = false
+// Rejects new executions and blocks until orders which are already sent are finished
+func StopOrderExecution() {
+	executionMux.Lock()
+	executionStopped = true
+	executionMux.Unlock()
+
+	log.Println("Waiting for in-flight orders...")
+	inFlightOrders.Wait()
+	log.Println("Waiting for in-flight orders... Done")
+}
 
 func ScheduleOrderExecutionIfNeeded(state *arb.State) {
-	if !shouldExecute(state) {
+	executionMux.Lock()
+	defer executionMux.Unlock()
+	if executionStopped {
 		return
 	}
 
-	// async schedule 3 trades
-	isBusy = true
-	log.Println("Started execution for " + state.Id)
-	now := time.Now()
-	ts := common.UnixMillis(now)
-	atomic.AddInt64(&routineCounter, 3)
-
-	for _, orderRequest := range state.Orders {
-		go func(request *common.OrderRequest) {
-			// generate order Id
-			clientOrderId := request.Symbol + "_" + strconv.FormatInt(common.UnixMillis(now), 10)
-			// get balances and log
-			logging.QueueEvent(&logging.Event{
-				EventType:logging.EventTypeOrderStatusChange,
-				Value: &common.OrderStatusChangeEvent{
-					OrderStatus: common.StatusNew,
-					ArbStateId: state.Id,
-					ClientOrderId: clientOrderId,
-					Symbol: request.Symbol,
-					Side: request.Side,
-					Type: request.Type,
-					Price: request.Price,
-					OrigQty: request.Qty,
-					ExecutedQty: 0.0,
-					CumulativeQuoteQty: 0.0,
-					TimeInForce: common.IOC,
-					Fills: make([]*common.Fill, 0),
-					ErrorMessage: "",
-					TransactTime: time.Now(),
-					BalanceA: account.Balances[state.Triangle.CoinA.CoinSymbol].Free,
-					BalanceB: account.Balances[state.Triangle.CoinB.CoinSymbol].Free,
-					BalanceC: account.Balances[state.Triangle.CoinC.CoinSymbol].Free,
-				},
-			})
-			res, err := binance.NewOrder(
-				request.Symbol,
-				request.Side,
-				common.TypeLimit,
-				request.Qty,
-				request.Price,
-				clientOrderId,
-				ts,
-				EXECUTION_MODE_TEST,
-			)
-
-			// TODO proper wait for balance to be updated
-			// TODO panic if not updated?
-			// TODO discrepancies in balances logging among arb currencies
-			// TODO THIS ALSO MAKES US IGNORE POTENTIAL ARB OPPS FOR EXECUTION
-
-			BIG_FUCKING_DELAY_DELET_THIS := time.Duration(100) * time.Millisecond
-			time.Sleep(BIG_FUCKING_DELAY_DELET_THIS)
-
-			// get balances and log
-			if err == nil {
-				log.Println("Order " + res.Symbol + " is executed")
-				logging.QueueEvent(&logging.Event{
-					EventType: logging.EventTypeOrderStatusChange,
-					Value: &common.OrderStatusChangeEvent{
-						OrderStatus:        res.Status,
-						ArbStateId:         state.Id,
-						ClientOrderId:      res.ClientOrderID,
-						Symbol:             res.Symbol,
-						Side:               res.Side,
-						Type:               res.Type,
-						Price:              res.Price,
-						OrigQty:            res.OrigQty,
-						ExecutedQty:        res.ExecutedQty,
-						CumulativeQuoteQty: res.CumulativeQuoteQty,
-						TimeInForce:        res.TimeInForce,
-						Fills:              res.Fills,
-						ErrorMessage:       "",
-						TransactTime:       res.TransactTime,
-						BalanceA:           account.Balances[state.Triangle.CoinA.CoinSymbol].Free,
-						BalanceB:           account.Balances[state.Triangle.CoinB.CoinSymbol].Free,
-						BalanceC:           account.Balances[state.Triangle.CoinC.CoinSymbol].Free,
-					},
-				})
-			} else {
-				log.Println("Order " + request.Symbol + " error: " + err.Error())
-				// TODO report min notional reason
-				logging.QueueEvent(&logging.Event{
-					EventType: logging.EventTypeOrderStatusChange,
-					Value: &common.OrderStatusChangeEvent{
-						OrderStatus:        common.StatusError,
-						ArbStateId:         state.Id,
-						ClientOrderId: clientOrderId,
-						Symbol: request.Symbol,
-						Side: request.Side,
-						Type: request.Type,
-						Price: request.Price,
-						OrigQty: request.Qty,
-						ExecutedQty: 0.0,
-						CumulativeQuoteQty: 0.0,
-						TimeInForce: common.IOC,
-						Fills: make([]*common.Fill, 0),
-						ErrorMessage: err.Error(),
-						TransactTime: time.Now(),
-						BalanceA: account.Balances[state.Triangle.CoinA.CoinSymbol].Free,
-						BalanceB: account.Balances[state.Triangle.CoinB.CoinSymbol].Free,
-						BalanceC: account.Balances[state.Triangle.CoinC.CoinSymbol].Free,
-					},
-				})
-			}
-
-			// remove from active orders
-			atomic.AddInt64(&routineCounter, -1)
-			if routineCounter == 0 {
-				log.Println("Finished execution for " + state.Id)
-				isBusy = false
-			}
-		}(orderRequest)
-
-		// Increase ts for next trade
-		// TODO time sleep 1 ms for ts to increase properly?
-		ts++
+	orderRequests := shouldExecute(state)
+	if orderRequests == nil {
+		return
 	}
+
+	publishArbEvent(arb.EventScheduled, state, "")
+	log.Println("Started execution for " + state.Id)
+	execution := arb.NewExecution(state, arb.ExecutionStrategy(executionConfig.STRATEGY), orderRequests)
+	inFlightOrders.Add(1)
+
+	go func() {
+		defer inFlightOrders.Done()
+		runExecution(execution)
+
+		log.Println("Finished execution for " + state.Id)
+		coinReservations.Release(state.Id)
+	}()
 }
 
-func shouldExecute(state *arb.State) bool {
-	// check frame count first
-	//if state.GetFrameUpdateCount() < 2 {
-	//	return false
-	//}
+// Free balances of triangle coins in latest snapshot, zeros for cycles which are not triangles
+func getTriangleBalances(state *arb.State) (float64, float64, float64) {
+	if state.Triangle == nil {
+		return 0, 0, 0
+	}
+	snapshot := getSnapshot()
+	return snapshot.GetFreeBalance(state.Triangle.CoinA.CoinSymbol).Float64(),
+		snapshot.GetFreeBalance(state.Triangle.CoinB.CoinSymbol).Float64(),
+		snapshot.GetFreeBalance(state.Triangle.CoinC.CoinSymbol).Float64()
+}
 
-	// TODO check if there is no active trades for arb with same coins
+// Returns orders of state's legs normalized to symbol filters and in leg order if state should be executed,
+// nil otherwise. State's own orders are left as detected. State is marked scheduled only once its coins are
+// reserved, so state rejected for filters, thresholds or balances which may change is checked again on next frames.
+func shouldExecute(state *arb.State) []*common.OrderRequest {
 	// TODO decide if we should also check arb states with diff prices/timestamps
-	if isBusy {
-		return false
+	if state.IsScheduled() {
+		return nil
 	}
 
-	if state.ScheduledForExecution {
-		return false
+	// Not dropped, may be executed once arbs on same coins finish
+	coins := getStateCoins(state)
+	if coinReservations.IsAnyReserved(coins) {
+		return nil
 	}
-	state.ScheduledForExecution = true
-
-	for _, orderRequest := range state.Orders {
-		check := FilterCheck(orderRequest.Symbol, orderRequest.Qty, orderRequest.Price)
-		if check != common.FilterCheckOk {
 
-			msg := string(check)
-			if check == common.FilterCheckMinNotional && state.UsesAllBalance {
-				msg += "_ALL_BALANCE"
-			}
+	// Not dropped, checked again on next frames
+	if !isPersistenceConfirmed(state) {
+		return nil
+	}
 
-			log.Println(state.Id + " is dropped. Did not pass " + msg + " for pair " + orderRequest.Symbol + " Price: " + common.FloatToString(orderRequest.Price) + " Qty: " + common.FloatToString(orderRequest.Qty) + " | Tick size: " + common.FloatToString(GetTickSize(orderRequest.Symbol)) + " | Min price: " + common.FloatToString(GetMinPrice(orderRequest.Symbol)) + " | Min notional: " + common.FloatToString(GetMinNotional(orderRequest.Symbol)) + " | Step size: " + common.FloatToString(GetStepSize(orderRequest.Symbol)))
-			return false
+	snapshot := getSnapshot()
+	normalizedOrders, failed, err := normalizeArbOrders(snapshot, state)
+	if err != nil {
+		dropState(state, "NO_FILTERS: " + err.Error(), err.Error())
+		return nil
+	}
+	if failed != nil {
+		check := failed.Check
+		symbol := failed.Request.Symbol
+		msg := string(check)
+		if check == common.FilterCheckMinNotional && state.UsesAllBalance {
+			msg += "_ALL_BALANCE"
 		}
+
+		// Filters exist, as normalization got as far as checking them
+		filters := snapshot.SymbolFilters[symbol]
+		dropState(state, msg + " " + symbol, "Did not pass " + msg + " for pair " + symbol + " Price: " + failed.Request.Price.String() + " Qty: " + failed.Request.Qty.String() + " | Tick size: " + filters.TickSize.String() + " | Min price: " + filters.MinPrice.String() + " | Min notional: " + filters.MinNotional.String() + " | Step size: " + filters.StepSize.String())
+		return nil
+	}
+
+	orderRequests := make([]*common.OrderRequest, 0, len(normalizedOrders))
+	for _, normalized := range normalizedOrders {
+		orderRequests = append(orderRequests, normalized.Request)
+	}
+
+	// Rounding changes what arb spends and returns, so thresholds are checked on orders which are sent
+	if threshold, details := checkProfitThresholds(state, orderRequests); threshold != "" {
+		dropState(state, threshold + ": " + details, "Did not pass " + threshold + ": " + details)
+		return nil
 	}
 
-	if state.ProfitRelative <= 0.0001 {
-		log.Println(state.Id + " is dropped. Profit is too low")
-		return false
+	// Coins are free as scheduling is serialized, balances may not cover orders of all legs though
+	amounts, err := getSpentAmounts(state, orderRequests, arb.ExecutionStrategy(executionConfig.STRATEGY))
+	if err != nil {
+		dropState(state, "RESERVATION: " + err.Error(), "Could not reserve balances: " + err.Error())
+		return nil
 	}
+	if reason := coinReservations.TryReserve(state.Id, coins, amounts, snapshot.GetFreeBalance); reason != "" {
+		dropState(state, "RESERVATION: " + reason, "Could not reserve balances: " + reason)
+		return nil
+	}
+	// Scheduling is serialized, so state which was not scheduled above is still not
+	state.MarkScheduled()
 
-	return true
-}
\ No newline at end of file
+	for _, normalized := range normalizedOrders {
+		if len(normalized.Adjustments) > 0 {
+			log.Println(state.Id + " order " + normalized.Request.Symbol + " is adjusted: " + normalized.AdjustmentsString())
+		}
+	}
+
+	return orderRequests
+}
+
+// State is checked again on next frames, so it is reported as dropped only when the reason changes
+func dropState(state *arb.State, reason string, details string) {
+	if !state.Reject(reason) {
+		return
+	}
+	publishArbEvent(arb.EventDropped, state, reason)
+	log.Println(state.Id + " is dropped. " + details)
+}
@@ -0,0 +1,360 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/common/arb"
+	"midas/configuration"
+	"midas/logging"
+	"midas/apis/binance"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Open orders of all symbols weigh 40, so they are not requested too often
+	DEFAULT_ORDERS_RECONCILE_PERIOD_MILLIS = 60000
+	// Orders closed by order response are kept that long for their execution reports
+	FINAL_ORDER_RETENTION = time.Duration(10) * time.Second
+	// Orders are checked against TTL that often, or every TTL if it is shorter
+	ORDER_TTL_CHECK_PERIOD_MILLIS = 100
+)
+
+var orderManagerConfig = makeOrderManagerConfig(brainConfig.ORDER_MANAGER)
+
+// Order brain placed or found open on exchange
+type ManagedOrder struct {
+	ClientOrderId string
+	OrderId int64 // zero until exchange accepts order
+	Symbol string
+	State *arb.State // nil for orders which are not arb legs, e.g. rebalancing trades or orders found open at startup
+	Status common.OrderStatus
+	ExecutedQty common.Decimal
+	CreatedTs time.Time
+	UpdatedTs time.Time
+	Placed bool // placed by brain, only such orders are canceled when they outlive TTL
+	CancelTs time.Time // last time TTL cancel was sent, zero if never
+}
+
+// Keeps orders by client order id and by order id until exchange reports them closed
+type OrderManager struct {
+	byClientOrderId map[string]*ManagedOrder
+	byOrderId map[int64]*ManagedOrder
+	mux sync.Mutex
+}
+
+func NewOrderManager() *OrderManager {
+	return &OrderManager{
+		byClientOrderId: make(map[string]*ManagedOrder),
+		byOrderId: make(map[int64]*ManagedOrder),
+	}
+}
+
+var orderManager = NewOrderManager()
+
+var stopOrderManager = make(chan struct{})
+var stopOrderManagerOnce sync.Once
+var orderManagerWg sync.WaitGroup
+
+func makeOrderManagerConfig(config *configuration.OrderManagerConfig) *configuration.OrderManagerConfig {
+	orderManagerConfig := &configuration.OrderManagerConfig{}
+	if config != nil {
+		*orderManagerConfig = *config
+	}
+	if orderManagerConfig.RECONCILE_PERIOD_MILLIS == 0 {
+		orderManagerConfig.RECONCILE_PERIOD_MILLIS = DEFAULT_ORDERS_RECONCILE_PERIOD_MILLIS
+	}
+
+	return orderManagerConfig
+}
+
+func isFinalOrderStatus(status common.OrderStatus) bool {
+	switch status {
+	case common.StatusFilled, common.StatusCanceled, common.StatusRejected, common.StatusExpired:
+		return true
+	default:
+		return false
+	}
+}
+
+// Has to be called before order is sent, as its first report may arrive before order response
+func (m *OrderManager) Add(clientOrderId string, symbol string, state *arb.State) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	now := time.Now()
+	m.byClientOrderId[clientOrderId] = &ManagedOrder{
+		ClientOrderId: clientOrderId,
+		Symbol: symbol,
+		State: state,
+		Status: common.StatusNew,
+		CreatedTs: now,
+		UpdatedTs: now,
+		Placed: true,
+	}
+}
+
+func (m *OrderManager) Remove(clientOrderId string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.remove(clientOrderId)
+}
+
+func (m *OrderManager) remove(clientOrderId string) {
+	order, ok := m.byClientOrderId[clientOrderId]
+	if !ok {
+		return
+	}
+	delete(m.byClientOrderId, clientOrderId)
+	delete(m.byOrderId, order.OrderId)
+}
+
+// Returns order with given ids, adding it if it is unknown
+func (m *OrderManager) getOrAdd(clientOrderId string, orderId int64, symbol string, createdTs time.Time) *ManagedOrder {
+	order, ok := m.byClientOrderId[clientOrderId]
+	if !ok {
+		order, ok = m.byOrderId[orderId]
+	}
+	if !ok {
+		order = &ManagedOrder{
+			ClientOrderId: clientOrderId,
+			Symbol: symbol,
+			Status: common.StatusNew,
+			CreatedTs: createdTs,
+		}
+		m.byClientOrderId[clientOrderId] = order
+	}
+	if orderId != 0 && order.OrderId == 0 {
+		order.OrderId = orderId
+		m.byOrderId[orderId] = order
+	}
+
+	return order
+}
+
+func (m *OrderManager) update(order *ManagedOrder, status common.OrderStatus, executedQty common.Decimal) {
+	order.Status = status
+	order.ExecutedQty = executedQty
+	order.UpdatedTs = time.Now()
+}
+
+// Order stays known even if response is final, as its execution reports may still be on their way.
+// It is forgotten on final report or on next reconciliation.
+func (m *OrderManager) ApplyResponse(res *common.ExecutedOrderFullResponse) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	// Already reported closed
+	if _, ok := m.byClientOrderId[res.ClientOrderID]; !ok && isFinalOrderStatus(res.Status) {
+		return
+	}
+	order := m.getOrAdd(res.ClientOrderID, res.OrderID, res.Symbol, res.TransactTime)
+	m.update(order, res.Status, res.ExecutedQty)
+}
+
+// Applies execution report and returns arb state of order, nil if order is not an arb leg.
+// Orders are forgotten once they are reported closed.
+func (m *OrderManager) ApplyEvent(event *common.OrderStatusChangeEvent) *arb.State {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	order := m.getOrAdd(event.ClientOrderId, event.OrderId, event.Symbol, event.TransactTime)
+	m.update(order, event.OrderStatus, event.ExecutedQty)
+	if isFinalOrderStatus(order.Status) {
+		m.remove(order.ClientOrderId)
+	}
+
+	return order.State
+}
+
+func (m *OrderManager) ApplyExchangeOrder(exchangeOrder *common.ExchangeOrder) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	order := m.getOrAdd(exchangeOrder.ClientOrderID, exchangeOrder.OrderID, exchangeOrder.Symbol, exchangeOrder.Time)
+	m.update(order, exchangeOrder.Status, exchangeOrder.ExecutedQty)
+}
+
+func (m *OrderManager) MarkCanceling(clientOrderId string, ts time.Time) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if order, ok := m.byClientOrderId[clientOrderId]; ok {
+		order.CancelTs = ts
+	}
+}
+
+// Copies of open orders brain placed which are older than ttl at ts and were not canceled within ttl
+func (m *OrderManager) GetExpiredOrders(ttl time.Duration, ts time.Time) []ManagedOrder {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	orders := make([]ManagedOrder, 0)
+	for _, order := range m.byClientOrderId {
+		if order.Placed && !isFinalOrderStatus(order.Status) && ts.Sub(order.CreatedTs) > ttl && ts.Sub(order.CancelTs) > ttl {
+			orders = append(orders, *order)
+		}
+	}
+
+	return orders
+}
+
+// Copies of orders which are not known to be closed
+func (m *OrderManager) GetOpenOrders() []ManagedOrder {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	orders := make([]ManagedOrder, 0, len(m.byClientOrderId))
+	for _, order := range m.byClientOrderId {
+		if !isFinalOrderStatus(order.Status) {
+			orders = append(orders, *order)
+		}
+	}
+
+	return orders
+}
+
+// Takes open orders exchange listed at requestTs: unknown ones are added, known ones which are missing are closed
+// and forgotten, as are orders whose final status came with order response FINAL_ORDER_RETENTION ago.
+// Orders added after requestTs may be missing from the list only because they are newer, so they are left as is.
+func (m *OrderManager) Reconcile(open []*common.ExchangeOrder, requestTs time.Time) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	listed := make(map[string]bool)
+	for _, exchangeOrder := range open {
+		order := m.getOrAdd(exchangeOrder.ClientOrderID, exchangeOrder.OrderID, exchangeOrder.Symbol, exchangeOrder.Time)
+		if order.State == nil && order.UpdatedTs.IsZero() {
+			log.Println("Found open order " + order.ClientOrderId + " on " + order.Symbol)
+		}
+		m.update(order, exchangeOrder.Status, exchangeOrder.ExecutedQty)
+		listed[order.ClientOrderId] = true
+	}
+
+	for clientOrderId, order := range m.byClientOrderId {
+		if listed[clientOrderId] || !order.CreatedTs.Before(requestTs) {
+			continue
+		}
+		if isFinalOrderStatus(order.Status) {
+			if requestTs.Sub(order.UpdatedTs) < FINAL_ORDER_RETENTION {
+				continue
+			}
+		} else {
+			log.Println("Order " + clientOrderId + " on " + order.Symbol + " is closed, its final report was missed")
+		}
+		m.remove(clientOrderId)
+	}
+}
+
+// Reconciles open orders with exchange at startup and then periodically.
+// Orders brain placed are canceled once they outlive TTL, which is checked on its own period.
+func RunOrderManager() {
+	reconcileOrders()
+	runOrderManagerLoop(time.Duration(orderManagerConfig.RECONCILE_PERIOD_MILLIS) * time.Millisecond, reconcileOrders)
+
+	if orderManagerConfig.ORDER_TTL_MILLIS > 0 {
+		ttl := time.Duration(orderManagerConfig.ORDER_TTL_MILLIS) * time.Millisecond
+		checkPeriod := time.Duration(ORDER_TTL_CHECK_PERIOD_MILLIS) * time.Millisecond
+		if ttl < checkPeriod {
+			checkPeriod = ttl
+		}
+		runOrderManagerLoop(checkPeriod, cancelExpiredOrders)
+	}
+}
+
+func runOrderManagerLoop(period time.Duration, run func()) {
+	orderManagerWg.Add(1)
+	go func() {
+		defer orderManagerWg.Done()
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopOrderManager:
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
+
+// Stops reconciliation and TTL checks, then cancels every open order if configured to
+func StopOrderManager() {
+	stopOrderManagerOnce.Do(func() {
+		close(stopOrderManager)
+	})
+	orderManagerWg.Wait()
+
+	if orderManagerConfig.CANCEL_ON_SHUTDOWN {
+		CancelAllOrders()
+	}
+}
+
+func reconcileOrders() {
+	requestTs := time.Now()
+	open, err := getExchangeOpenOrders()
+	if err != nil {
+		log.Println("Failed to reconcile open orders")
+		return
+	}
+	orderManager.Reconcile(open, requestTs)
+}
+
+// Paper orders never stay open
+func getExchangeOpenOrders() ([]*common.ExchangeOrder, error) {
+	if isPaperTrading() {
+		return nil, nil
+	}
+
+	return binance.GetOpenOrders("")
+}
+
+// Cancel which fails, e.g. as order has just filled, is retried once TTL passes again
+func cancelExpiredOrders() {
+	ttl := time.Duration(orderManagerConfig.ORDER_TTL_MILLIS) * time.Millisecond
+	now := time.Now()
+	for _, order := range orderManager.GetExpiredOrders(ttl, now) {
+		log.Println("Order " + order.ClientOrderId + " on " + order.Symbol + " outlived TTL, canceling...")
+		orderManager.MarkCanceling(order.ClientOrderId, now)
+		cancelOrder(order)
+	}
+}
+
+func cancelOrder(order ManagedOrder) bool {
+	res, err := binance.CancelOrder(order.Symbol, order.OrderId, order.ClientOrderId)
+	if err != nil {
+		return false
+	}
+	orderManager.ApplyExchangeOrder(res)
+
+	return true
+}
+
+// Cancels every open order, known to brain or not, and returns how many were canceled
+func CancelAllOrders() int {
+	log.Println("Canceling all open orders...")
+	requestTs := time.Now()
+	open, err := getExchangeOpenOrders()
+	if err != nil {
+		log.Println("Canceling all open orders... Failed to get open orders")
+		return 0
+	}
+	orderManager.Reconcile(open, requestTs)
+
+	canceled := 0
+	for _, order := range orderManager.GetOpenOrders() {
+		if cancelOrder(order) {
+			canceled++
+		}
+	}
+	log.Println("Canceling all open orders... Done, canceled " + strconv.Itoa(canceled))
+
+	return canceled
+}
+
+// Records order status change reported by exchange. Orders which are not arb legs are recorded without arb state.
+func handleOrderStatusChange(event *common.OrderStatusChangeEvent) {
+	if state := orderManager.ApplyEvent(event); state != nil {
+		event.ArbStateId = state.Id
+		event.BalanceA, event.BalanceB, event.BalanceC = getTriangleBalances(state)
+	}
+
+	logging.QueueEvent(&logging.Event{
+		EventType: logging.EventTypeOrderStatusChange,
+		Value: event,
+	})
+}
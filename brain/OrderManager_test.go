@@ -0,0 +1,157 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/common/arb"
+	"testing"
+	"time"
+)
+
+var testOrderState = &arb.State{Id: "ETHBTC_BNBETH_BNBBTC"}
+
+// Order A is placed by brain and accepted as 1, order B is placed and not accepted yet
+func makeTestOrderManager() *OrderManager {
+	m := NewOrderManager()
+	m.Add("A", "ETHBTC", testOrderState)
+	m.ApplyResponse(&common.ExecutedOrderFullResponse{Symbol: "ETHBTC", OrderID: 1, ClientOrderID: "A", Status: common.StatusNew})
+	m.Add("B", "BNBETH", nil)
+
+	return m
+}
+
+func TestOrderManagerApplyResponse(t *testing.T) {
+	cases := []struct {
+		name string
+		res *common.ExecutedOrderFullResponse
+		known bool
+		status common.OrderStatus
+	}{
+		{"accepted", &common.ExecutedOrderFullResponse{Symbol: "BNBETH", OrderID: 2, ClientOrderID: "B", Status: common.StatusNew}, true, common.StatusNew},
+		// Kept for execution reports still on their way
+		{"filled", &common.ExecutedOrderFullResponse{Symbol: "BNBETH", OrderID: 2, ClientOrderID: "B", Status: common.StatusFilled, ExecutedQty: common.ToDecimal("1")}, true, common.StatusFilled},
+		// Final report came before response
+		{"already closed", &common.ExecutedOrderFullResponse{Symbol: "BNBBTC", OrderID: 3, ClientOrderID: "C", Status: common.StatusExpired}, false, ""},
+		{"unknown open", &common.ExecutedOrderFullResponse{Symbol: "BNBBTC", OrderID: 3, ClientOrderID: "C", Status: common.StatusPartiallyFilled}, true, common.StatusPartiallyFilled},
+	}
+
+	for _, c := range cases {
+		m := makeTestOrderManager()
+		m.ApplyResponse(c.res)
+		order, ok := m.byClientOrderId[c.res.ClientOrderID]
+		if ok != c.known {
+			t.Errorf("%s: expected order to be known %t", c.name, c.known)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if order.Status != c.status || order.ExecutedQty != c.res.ExecutedQty || m.byOrderId[c.res.OrderID] != order {
+			t.Errorf("%s: unexpected order %+v", c.name, *order)
+		}
+	}
+}
+
+func TestOrderManagerApplyEvent(t *testing.T) {
+	cases := []struct {
+		name string
+		event *common.OrderStatusChangeEvent
+		state *arb.State
+		known bool
+	}{
+		{"partially filled", &common.OrderStatusChangeEvent{OrderStatus: common.StatusPartiallyFilled, ClientOrderId: "A", OrderId: 1, ExecutedQty: common.ToDecimal("0.5")}, testOrderState, true},
+		{"filled", &common.OrderStatusChangeEvent{OrderStatus: common.StatusFilled, ClientOrderId: "A", OrderId: 1, ExecutedQty: common.ToDecimal("1")}, testOrderState, false},
+		// Reports of an order canceled by someone else may carry another client order id
+		{"found by order id", &common.OrderStatusChangeEvent{OrderStatus: common.StatusCanceled, ClientOrderId: "web_1", OrderId: 1}, testOrderState, false},
+		// Report came before response, order id is learnt from it
+		{"not accepted yet", &common.OrderStatusChangeEvent{OrderStatus: common.StatusNew, ClientOrderId: "B", OrderId: 2}, nil, true},
+		{"unknown", &common.OrderStatusChangeEvent{OrderStatus: common.StatusNew, ClientOrderId: "C", OrderId: 3, Symbol: "BNBBTC"}, nil, true},
+	}
+
+	for _, c := range cases {
+		m := makeTestOrderManager()
+		if state := m.ApplyEvent(c.event); state != c.state {
+			t.Errorf("%s: expected state %v, got %v", c.name, c.state, state)
+		}
+		order, ok := m.byOrderId[c.event.OrderId]
+		if ok != c.known {
+			t.Errorf("%s: expected order to be known %t", c.name, c.known)
+			continue
+		}
+		if ok && (order.Status != c.event.OrderStatus || order.ExecutedQty != c.event.ExecutedQty) {
+			t.Errorf("%s: unexpected order %+v", c.name, *order)
+		}
+		if !ok && (len(m.byClientOrderId) != 1 || m.byClientOrderId["B"] == nil) {
+			t.Errorf("%s: expected only B to be left, got %v", c.name, m.byClientOrderId)
+		}
+	}
+}
+
+func TestOrderManagerReconcile(t *testing.T) {
+	listedA := &common.ExchangeOrder{Symbol: "ETHBTC", OrderID: 1, ClientOrderID: "A", Status: common.StatusPartiallyFilled, ExecutedQty: common.ToDecimal("0.5")}
+	listedC := &common.ExchangeOrder{Symbol: "BNBBTC", OrderID: 3, ClientOrderID: "C", Status: common.StatusNew, Time: time.Now().Add(-time.Hour)}
+	cases := []struct {
+		name string
+		open []*common.ExchangeOrder
+		requestTs time.Time
+		// B was added before requestTs if it is later than now
+		expected []string
+	}{
+		{"listed", []*common.ExchangeOrder{listedA}, time.Now().Add(time.Second), []string{"A"}},
+		// B is not listed only because it was sent after request
+		{"added after request", []*common.ExchangeOrder{listedA}, time.Now().Add(-time.Second), []string{"A", "B"}},
+		{"missed final reports", nil, time.Now().Add(time.Second), []string{}},
+		{"found open", []*common.ExchangeOrder{listedA, listedC}, time.Now().Add(time.Second), []string{"A", "C"}},
+	}
+
+	for _, c := range cases {
+		m := makeTestOrderManager()
+		m.Reconcile(c.open, c.requestTs)
+		if len(m.byClientOrderId) != len(c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, m.byClientOrderId)
+			continue
+		}
+		for _, clientOrderId := range c.expected {
+			if m.byClientOrderId[clientOrderId] == nil {
+				t.Errorf("%s: expected %v, got %v", c.name, c.expected, m.byClientOrderId)
+			}
+		}
+	}
+
+	// Orders found open are not canceled on TTL, orders brain placed are
+	m := makeTestOrderManager()
+	m.Reconcile([]*common.ExchangeOrder{listedA, listedC}, time.Now().Add(-time.Second))
+	if c := m.byClientOrderId["C"]; c == nil || c.Placed || c.State != nil || !c.CreatedTs.Equal(listedC.Time) {
+		t.Errorf("expected C to be adopted with exchange time, got %v", c)
+	}
+	expired := m.GetExpiredOrders(time.Minute, time.Now().Add(2 * time.Minute))
+	if len(expired) != 2 {
+		t.Errorf("expected A and B to expire, got %v", expired)
+	}
+	m.MarkCanceling("A", time.Now().Add(2 * time.Minute))
+	if expired := m.GetExpiredOrders(time.Minute, time.Now().Add(2 * time.Minute)); len(expired) != 1 || expired[0].ClientOrderId != "B" {
+		t.Errorf("expected only B to expire while A is being canceled, got %v", expired)
+	}
+}
+
+func TestOrderManagerReconcileRetention(t *testing.T) {
+	cases := []struct {
+		name string
+		sinceFinal time.Duration
+		kept bool
+	}{
+		{"within retention", FINAL_ORDER_RETENTION / 2, true},
+		{"past retention", FINAL_ORDER_RETENTION * 2, false},
+	}
+
+	for _, c := range cases {
+		m := makeTestOrderManager()
+		m.ApplyResponse(&common.ExecutedOrderFullResponse{Symbol: "ETHBTC", OrderID: 1, ClientOrderID: "A", Status: common.StatusFilled, ExecutedQty: common.ToDecimal("1")})
+		m.Reconcile(nil, time.Now().Add(c.sinceFinal))
+		if _, ok := m.byClientOrderId["A"]; ok != c.kept {
+			t.Errorf("%s: expected filled order to be kept %t", c.name, c.kept)
+		}
+		if _, ok := m.byOrderId[1]; ok != c.kept {
+			t.Errorf("%s: expected order id to be kept %t", c.name, c.kept)
+		}
+	}
+}
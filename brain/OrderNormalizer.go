@@ -0,0 +1,124 @@
+package brain
+
+import (
+	"errors"
+	"midas/common"
+	"midas/common/arb"
+	"math"
+)
+
+// Adjustment of a leg scaled along with another leg of the arb
+const ADJUSTMENT_FILTER_LEG_BALANCE = "LEG_BALANCE"
+
+func getSymbolFiltersMap(filtersMap common.FiltersMap) map[string]*common.SymbolFilters {
+	symbolFilters := make(map[string]*common.SymbolFilters)
+	for symbol, filters := range filtersMap {
+		symbolFilters[symbol] = common.NewSymbolFilters(filters)
+	}
+
+	return symbolFilters
+}
+
+// Fails if symbol is not in exchange info, e.g. when it was delisted after arb was detected
+func getSymbolFilters(snapshot *MarketSnapshot, symbol string) (*common.SymbolFilters, error) {
+	filters, ok := snapshot.SymbolFilters[symbol]
+	if !ok {
+		return nil, errors.New("Filters for " + symbol + " do not exist")
+	}
+
+	return filters, nil
+}
+
+// Rounds order to symbol filters and raises it to min notional if balance allows, see common.SymbolFilters.Normalize.
+// Request itself is left as is, Check of the result tells if normalized order can be sent.
+func NormalizeOrder(snapshot *MarketSnapshot, request *common.OrderRequest) (*common.NormalizedOrder, error) {
+	return normalizeOrder(snapshot, request, getSpentBalance(snapshot, request))
+}
+
+func normalizeOrder(snapshot *MarketSnapshot, request *common.OrderRequest, available common.Decimal) (*common.NormalizedOrder, error) {
+	filters, err := getSymbolFilters(snapshot, request.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	return filters.Normalize(request, getRefPrice(snapshot, request.Symbol), available), nil
+}
+
+// Normalizes orders of all legs of state so they keep passing amounts on to each other. Legs are normalized
+// on their own first, then, if any qty changed, all detected orders are scaled by the ratio of the leg which
+// changed the most: up to the leg raised to min notional, otherwise down to the leg rounded down the most.
+// Scaled orders are only rounded down, so less than a step of a middle coin may be left over.
+// Returns normalized orders in leg order, or the first order which does not pass filters.
+// Fails if filters of some leg's symbol are missing.
+func normalizeArbOrders(snapshot *MarketSnapshot, state *arb.State) ([]*common.NormalizedOrder, *common.NormalizedOrder, error) {
+	normalizedOrders := make([]*common.NormalizedOrder, 0, len(state.Legs))
+	minRatio, maxRatio := 1.0, 1.0
+	for _, leg := range state.Legs {
+		normalized, err := NormalizeOrder(snapshot, leg.Order)
+		if err != nil {
+			return nil, nil, err
+		}
+		if normalized.Check != common.FilterCheckOk {
+			return nil, normalized, nil
+		}
+		ratio := normalized.Request.Qty.Float64() / leg.Order.Qty.Float64()
+		minRatio = math.Min(minRatio, ratio)
+		maxRatio = math.Max(maxRatio, ratio)
+		normalizedOrders = append(normalizedOrders, normalized)
+	}
+
+	scale := minRatio
+	if maxRatio > 1.0 {
+		scale = maxRatio
+	}
+	if scale == 1.0 {
+		return normalizedOrders, nil, nil
+	}
+
+	for i, leg := range state.Legs {
+		scaled, ok := arb.ScaleOrder(leg.Order, scale)
+		if !ok {
+			// Qty which does not fit into Decimal is above any LOT_SIZE max
+			return nil, &common.NormalizedOrder{Request: scaled, Check: common.FilterCheckMaxQty}, nil
+		}
+		normalized, err := normalizeOrder(snapshot, scaled, common.Decimal{})
+		if err != nil {
+			return nil, nil, err
+		}
+		if normalized.Check != common.FilterCheckOk {
+			return nil, normalized, nil
+		}
+		normalized.Adjustments = append([]common.OrderAdjustment{
+			{ADJUSTMENT_FILTER_LEG_BALANCE, common.ADJUSTMENT_FIELD_QTY, leg.Order.Qty, scaled.Qty},
+		}, normalized.Adjustments...)
+		normalizedOrders[i] = normalized
+	}
+
+	return normalizedOrders, nil, nil
+}
+
+// Mid price stands for exchange's average price which PERCENT_PRICE uses, zero if there is no ticker
+func getRefPrice(snapshot *MarketSnapshot, symbol string) common.Decimal {
+	ticker := snapshot.GetTicker(symbol)
+	if ticker == nil {
+		return common.Decimal{}
+	}
+
+	// Halving always fits
+	mid, _ := ticker.BidPrice.Add(ticker.AskPrice).Div(common.DecimalFromInt(2))
+	return mid
+}
+
+// Available balance of quote coin for buy orders and of base coin for sell orders
+func getSpentBalance(snapshot *MarketSnapshot, request *common.OrderRequest) common.Decimal {
+	pair := snapshot.GetPair(request.Symbol)
+	if pair == nil {
+		return common.Decimal{}
+	}
+
+	if request.Side == common.SideBuy {
+		return getAvailableBalance(snapshot, pair.QuoteCoin.CoinSymbol)
+	}
+
+	return getAvailableBalance(snapshot, pair.BaseCoin.CoinSymbol)
+}
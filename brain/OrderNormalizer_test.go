@@ -0,0 +1,137 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/common/arb"
+	"testing"
+)
+
+func makeNormalizerPair(base string, quote string) *common.CoinPair {
+	return &common.CoinPair{
+		PairSymbol: base + quote,
+		BaseCoin: common.Coin{CoinSymbol: base},
+		QuoteCoin: common.Coin{CoinSymbol: quote},
+	}
+}
+
+// BTC -> ETH -> BNB -> BTC, ETHBTC has min notional of 0.05 BTC
+func makeNormalizerState(qtyETH string, qtyBNB string) (*MarketSnapshot, *arb.State) {
+	ethbtc, bnbeth, bnbbtc := makeNormalizerPair("ETH", "BTC"), makeNormalizerPair("BNB", "ETH"), makeNormalizerPair("BNB", "BTC")
+	step := common.ToDecimal("0.01")
+	snapshot := &MarketSnapshot{
+		Tickers: common.NewShardedTickers(nil),
+		Account: &common.Account{Balances: map[string]*common.Balance{
+			"BTC": {CoinSymbol: "BTC", Free: common.ToDecimal("1")},
+		}},
+		Pairs: []*common.CoinPair{ethbtc, bnbeth, bnbbtc},
+		SymbolFilters: map[string]*common.SymbolFilters{
+			"ETHBTC": {StepSize: step, MinNotional: common.ToDecimal("0.05"), MinNotionalAppliesToMarket: true},
+			"BNBETH": {StepSize: step},
+			"BNBBTC": {StepSize: step},
+		},
+	}
+	state := &arb.State{
+		Id: "ETHBTC_BNBETH_BNBBTC",
+		Legs: []*arb.Leg{
+			{Pair: ethbtc, From: ethbtc.QuoteCoin, To: ethbtc.BaseCoin,
+				Order: &common.OrderRequest{"ETHBTC", common.SideBuy, common.TypeLimit, common.ToDecimal(qtyETH), common.ToDecimal("0.03")}},
+			{Pair: bnbeth, From: bnbeth.QuoteCoin, To: bnbeth.BaseCoin,
+				Order: &common.OrderRequest{"BNBETH", common.SideBuy, common.TypeLimit, common.ToDecimal(qtyBNB), common.ToDecimal("0.1")}},
+			{Pair: bnbbtc, From: bnbbtc.BaseCoin, To: bnbbtc.QuoteCoin,
+				Order: &common.OrderRequest{"BNBBTC", common.SideSell, common.TypeLimit, common.ToDecimal(qtyBNB), common.ToDecimal("0.0031")}},
+		},
+	}
+
+	return snapshot, state
+}
+
+func getNormalizedQtys(normalizedOrders []*common.NormalizedOrder) []string {
+	qtys := make([]string, 0, len(normalizedOrders))
+	for _, normalized := range normalizedOrders {
+		qtys = append(qtys, normalized.Request.Qty.String())
+	}
+
+	return qtys
+}
+
+func TestNormalizeArbOrders(t *testing.T) {
+	cases := []struct {
+		name string
+		qtyETH string
+		qtyBNB string
+		expected []string
+	}{
+		{"unchanged", "2", "20", []string{"2", "20", "20"}},
+		// 2.005 ETH is rounded down to 2, BNB legs follow
+		{"scaled down to step", "2.005", "20.05", []string{"2", "20", "20"}},
+		// 0.03 BTC is raised to 1.67 ETH for min notional, BNB legs follow
+		{"scaled up to min notional", "1", "10", []string{"1.67", "16.7", "16.7"}},
+	}
+
+	for _, c := range cases {
+		snapshot, state := makeNormalizerState(c.qtyETH, c.qtyBNB)
+		normalizedOrders, failed, err := normalizeArbOrders(snapshot, state)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err.Error())
+			continue
+		}
+		if failed != nil {
+			t.Errorf("%s: unexpected %s for %s", c.name, failed.Check, failed.Request.String())
+			continue
+		}
+		qtys := getNormalizedQtys(normalizedOrders)
+		for i := range qtys {
+			if qtys[i] != c.expected[i] {
+				t.Errorf("%s: expected %v, got %v", c.name, c.expected, qtys)
+				break
+			}
+		}
+	}
+}
+
+func TestNormalizeArbOrdersDropsUnbalancedRaise(t *testing.T) {
+	// Not enough BTC to raise first leg to min notional
+	snapshot, state := makeNormalizerState("1", "10")
+	snapshot.Account.Balances["BTC"].Free = common.ToDecimal("0.04")
+
+	normalizedOrders, failed, _ := normalizeArbOrders(snapshot, state)
+	if normalizedOrders != nil || failed == nil || failed.Check != common.FilterCheckMinNotional || failed.Request.Symbol != "ETHBTC" {
+		t.Errorf("expected arb to be dropped for ETHBTC min notional, got %v", getNormalizedQtys(normalizedOrders))
+	}
+}
+
+func TestNormalizeArbOrdersFailsWithoutFilters(t *testing.T) {
+	// BNBETH delisted between detection and execution
+	snapshot, state := makeNormalizerState("2", "20")
+	delete(snapshot.SymbolFilters, "BNBETH")
+
+	if normalizedOrders, failed, err := normalizeArbOrders(snapshot, state); err == nil {
+		t.Errorf("expected error for missing filters, got %v and %v", getNormalizedQtys(normalizedOrders), failed)
+	}
+}
+
+func TestShouldExecuteRetriesRejectedState(t *testing.T) {
+	snapshot, state := makeNormalizerState("1", "10")
+	state.FrameHistory = arb.NewFrameHistory()
+	prev := getSnapshot()
+	t.Cleanup(func() {
+		currentSnapshot.Store(prev)
+		coinReservations.Release(state.Id)
+	})
+
+	// Not enough BTC to raise first leg to min notional
+	snapshot.Account.Balances["BTC"].Free = common.ToDecimal("0.04")
+	currentSnapshot.Store(snapshot)
+	if orders := shouldExecute(state); orders != nil || state.IsScheduled() {
+		t.Fatal("expected state to be rejected without being scheduled")
+	}
+
+	// Balances arrive on a later frame, legs may spend from every coin at once
+	funded, _ := makeNormalizerState("1", "10")
+	funded.Account.Balances["ETH"] = &common.Balance{CoinSymbol: "ETH", Free: common.ToDecimal("10")}
+	funded.Account.Balances["BNB"] = &common.Balance{CoinSymbol: "BNB", Free: common.ToDecimal("100")}
+	currentSnapshot.Store(funded)
+	if orders := shouldExecute(state); orders == nil || !state.IsScheduled() {
+		t.Error("expected state to be scheduled once balance covers it")
+	}
+}
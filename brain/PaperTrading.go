@@ -0,0 +1,102 @@
+package brain
+
+import (
+	"midas/apis/binance"
+	"midas/apis/paper"
+	"midas/common"
+	"midas/configuration"
+	"errors"
+	"log"
+	"time"
+)
+
+// Nil unless paper trading is configured
+var paperExchange = makePaperExchange(brainConfig.PAPER_TRADING)
+
+func makePaperExchange(config *configuration.PaperTradingConfig) *paper.Exchange {
+	if config == nil {
+		return nil
+	}
+
+	fee := config.FEE
+	if fee == 0 {
+		fee = executionThresholds.FEE
+	}
+	// Bad config stops brain on start, as it does when config can not be parsed
+	balances := make(map[string]common.Decimal)
+	for coin, balance := range config.BALANCES {
+		d, ok := common.DecimalFromFloat(balance)
+		if !ok {
+			panic("Paper trading balance of " + coin + " is out of range")
+		}
+		balances[coin] = d
+	}
+	feeDecimal, ok := common.DecimalFromFloat(fee)
+	if !ok {
+		panic("Paper trading fee is out of range")
+	}
+
+	return paper.NewExchange(balances, feeDecimal)
+}
+
+func isPaperTrading() bool {
+	return paperExchange != nil
+}
+
+// Sends order to Binance, or fills it on paper exchange when paper trading
+func placeOrder(request *common.OrderRequest, clientOrderId string) (*common.ExecutedOrderFullResponse, error) {
+	if isPaperTrading() {
+		return placePaperOrder(request, clientOrderId)
+	}
+
+	return binance.NewOrder(
+		request.Symbol,
+		request.Side,
+		request.Type,
+		request.Qty,
+		request.Price,
+		clientOrderId,
+		common.UnixMillis(time.Now()),
+		EXECUTION_MODE_TEST,
+	)
+}
+
+// Fills order against fresh depth, or top of book if there is none, then delivers what user data stream would:
+// account update and execution report
+func placePaperOrder(request *common.OrderRequest, clientOrderId string) (*common.ExecutedOrderFullResponse, error) {
+	snapshot := getSnapshot()
+	pair := snapshot.GetPair(request.Symbol)
+	if pair == nil {
+		return nil, errors.New("Unknown symbol " + request.Symbol)
+	}
+	depth := getFreshDepth(request.Symbol)
+	if depth == nil {
+		if ticker := snapshot.GetTicker(request.Symbol); ticker != nil {
+			depth = paper.DepthFromTicker(ticker)
+		}
+	}
+
+	res, err := paperExchange.NewOrder(pair, request, clientOrderId, depth, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	updateAccount(paperExchange.GetAccount())
+	handleOrderStatusChange(&common.OrderStatusChangeEvent{
+		OrderStatus: res.Status,
+		ClientOrderId: res.ClientOrderID,
+		OrderId: res.OrderID,
+		Symbol: res.Symbol,
+		Side: res.Side,
+		Type: res.Type,
+		Price: res.Price,
+		OrigQty: res.OrigQty,
+		ExecutedQty: res.ExecutedQty,
+		CumulativeQuoteQty: res.CumulativeQuoteQty,
+		TimeInForce: res.TimeInForce,
+		Fills: res.Fills,
+		TransactTime: res.TransactTime,
+	})
+	log.Println("Paper order " + clientOrderId + " is " + string(res.Status) + " | " + paperExchange.String())
+
+	return res, nil
+}
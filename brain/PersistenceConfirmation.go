@@ -0,0 +1,32 @@
+package brain
+
+import (
+	"midas/common/arb"
+	"time"
+)
+
+// Filters out arbs made by a single stale or glitched frame
+func isPersistenceConfirmed(state *arb.State) bool {
+	config := brainConfig.PERSISTENCE
+	if config == nil {
+		return true
+	}
+
+	frames, eyes := state.FrameHistory.Confirmations(state.GetLastUpdateTs())
+	if eyes < config.MIN_DISTINCT_EYES {
+		return false
+	}
+
+	if config.MIN_FRAMES == 0 && config.MIN_DURATION_MILLIS == 0 {
+		return true
+	}
+	if config.MIN_FRAMES > 0 && frames >= config.MIN_FRAMES {
+		return true
+	}
+	lastedFor := state.GetLastUpdateTs().Sub(state.StartTs)
+	if config.MIN_DURATION_MILLIS > 0 && lastedFor >= time.Duration(config.MIN_DURATION_MILLIS) * time.Millisecond {
+		return true
+	}
+
+	return false
+}
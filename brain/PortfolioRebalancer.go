@@ -1,7 +1,6 @@
 package brain
 
 import (
-	"midas/apis/binance"
 	"strings"
 	"midas/common"
 	"log"
@@ -28,31 +27,48 @@ const (
 func RebalancePortfolio() {
 	log.Println("Rebalancing portfolio...")
 	checkWeights()
-	checkAccountInfoIsFetched()
+	// Plan is made from a single snapshot, trades themselves are normalized against latest balances
+	snapshot := getSnapshot()
+	checkAccountInfoIsFetched(snapshot)
 
-	qtys := projectBTCQtys()
+	qtys := projectBTCQtys(snapshot)
 	log.Println("Qtys: ", qtys)
-	trades := scheduleTrades(qtys)
+	trades := scheduleTrades(snapshot, qtys)
 	log.Println("Trades: ", trades)
 	log.Println("Num Trades: ", len(trades))
 	executeTrades(trades)
 	log.Println("Rebalancing portfolio... Done")
 }
 
-func projectBTCQtys() map[string]float64 {
+func projectBTCQtys(snapshot *MarketSnapshot) map[string]float64 {
 	totalEstimatedBTCQty := 0.0
 	numCoins := 0
 	projectedBTCQtys := make(map[string]float64)
+	// Coins out of universe are sold to BTC, BTC itself is kept since every trade goes through it
+	excludedCoins := make(map[string]bool)
 
 	// TODO make sure we use only arb coins and arb pairs
 	// Find eligible coins and estimate total BTC value
-	for _, balance := range account.Balances {
-		estimatedBTCQty, _, _, _ := estimateBTCQty(balance, false)
-		if estimatedBTCQty < 0 {
+	for _, balance := range snapshot.Account.Balances {
+		valuation, err := valueIn(balance.Free.Float64(), balance.CoinSymbol, "BTC")
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		estimatedBTCQty := valuation.Mid
+
+		// Rebalancing trades go straight to and from BTC, coins valued through other paths only add to total
+		if !hasBTCPair(snapshot, balance.CoinSymbol) {
+			totalEstimatedBTCQty += estimatedBTCQty
 			continue
 		}
 
 		projectedBTCQtys[balance.CoinSymbol] = 0.0
+		if balance.CoinSymbol != "BTC" && !isCoinAllowed(balance.CoinSymbol) {
+			excludedCoins[balance.CoinSymbol] = true
+			totalEstimatedBTCQty += estimatedBTCQty
+			continue
+		}
 		totalEstimatedBTCQty += estimatedBTCQty
 		numCoins++
 	}
@@ -60,6 +76,9 @@ func projectBTCQtys() map[string]float64 {
 	// Calc projected btc values for weighted coins first
 	totalWeight := 1.0
 	for coinSymbol, weight := range weights {
+		if coinSymbol != "BTC" && !isCoinAllowed(coinSymbol) {
+			continue
+		}
 		projectedBTCQtys[coinSymbol] = totalEstimatedBTCQty * weight
 		totalWeight -= weight
 		numCoins--
@@ -71,7 +90,7 @@ func projectBTCQtys() map[string]float64 {
 	// Calc projected btc values for other coins
 	weightPerCoin := totalWeight/float64(numCoins)
 	for coinSymbol, _ := range projectedBTCQtys {
-		if _, ok := weights[coinSymbol]; !ok {
+		if _, ok := weights[coinSymbol]; !ok && !excludedCoins[coinSymbol] {
 			projectedBTCQtys[coinSymbol] = weightPerCoin * totalEstimatedBTCQty
 		}
 	}
@@ -79,19 +98,23 @@ func projectBTCQtys() map[string]float64 {
 	return projectedBTCQtys
 }
 
-func estimateBTCQty(coinBalance *common.Balance, shouldPanic bool) (float64, float64, string, common.OrderSide) {
+func hasBTCPair(snapshot *MarketSnapshot, coinSymbol string) bool {
+	return coinSymbol == "BTC" || snapshot.GetPair(coinSymbol + "BTC") != nil || snapshot.GetPair("BTC" + coinSymbol) != nil
+}
+
+func estimateBTCQty(snapshot *MarketSnapshot, coinBalance *common.Balance, shouldPanic bool) (float64, float64, string, common.OrderSide) {
 	coinSymbol := coinBalance.CoinSymbol
-	coinQty := coinBalance.Free
+	coinQty := coinBalance.Free.Float64()
 	if strings.Compare(coinSymbol, "BTC") == 0 {
 		return coinQty, 1.0, "", ""
 	}
 
 	pairSymbol := coinSymbol + "BTC"
 	side := common.SideSell
-	if !HasPair(pairSymbol) {
+	if snapshot.GetPair(pairSymbol) == nil {
 		pairSymbol = "BTC" + coinSymbol
 		side = common.SideBuy
-		if !HasPair(pairSymbol) {
+		if snapshot.GetPair(pairSymbol) == nil {
 			msg := "Pair for BTC and " + coinSymbol + " does not exist"
 			if shouldPanic {
 				panic(msg)
@@ -102,7 +125,7 @@ func estimateBTCQty(coinBalance *common.Balance, shouldPanic bool) (float64, flo
 		}
 	}
 
-	ticker := (*tickersMap)[pairSymbol]
+	ticker := snapshot.GetTicker(pairSymbol)
 	if ticker == nil {
 		msg := "Ticker for BTC and " + coinSymbol + " does not exist"
 		if shouldPanic {
@@ -113,7 +136,7 @@ func estimateBTCQty(coinBalance *common.Balance, shouldPanic bool) (float64, flo
 		}
 	}
 
-	midPrice := (ticker.BidPrice + ticker.AskPrice)/2.0
+	midPrice := (ticker.BidPrice.Float64() + ticker.AskPrice.Float64())/2.0
 
 	estimatedBTCQty := 0.0
 	if side == common.SideSell {
@@ -125,14 +148,14 @@ func estimateBTCQty(coinBalance *common.Balance, shouldPanic bool) (float64, flo
 	return estimatedBTCQty, midPrice, pairSymbol, side
 }
 
-func scheduleTrades(projectedBTCQtys map[string]float64) []*common.OrderRequest {
+func scheduleTrades(snapshot *MarketSnapshot, projectedBTCQtys map[string]float64) []*common.OrderRequest {
 	toBTC := make([]*common.OrderRequest, 0)
 	fromBTC := make([]*common.OrderRequest, 0)
 	for coinSymbol, projectedBTCQty := range projectedBTCQtys {
 		if strings.Compare(coinSymbol, "BTC") == 0 {
 			continue
 		}
-		estimatedBTCQty, midPrice, pairSymbol, side := estimateBTCQty(account.Balances[coinSymbol], true)
+		estimatedBTCQty, midPrice, pairSymbol, side := estimateBTCQty(snapshot, snapshot.GetBalance(coinSymbol), true)
 
 		deltaBTCQty := math.Abs(projectedBTCQty - estimatedBTCQty)
 		deltaCoinQty := 0.0
@@ -146,12 +169,17 @@ func scheduleTrades(projectedBTCQtys map[string]float64) []*common.OrderRequest
 		if projectedBTCQty < estimatedBTCQty {
 			// to BTC
 			if deltaBTCQty > EXECUTION_THRESHOLD * estimatedBTCQty {
+				qty, ok := FormatQty(pairSymbol, deltaCoinQty)
+				if !ok {
+					log.Println("Rebalancing qty of " + pairSymbol + " is out of range")
+					continue
+				}
 				toBTC = append(toBTC, &common.OrderRequest{
 					pairSymbol,
 					side,
 					common.TypeMarket,
-					FormatQty(pairSymbol, deltaCoinQty),
-					0.0,
+					qty,
+					common.Decimal{},
 				})
 			}
 		} else {
@@ -164,12 +192,17 @@ func scheduleTrades(projectedBTCQtys map[string]float64) []*common.OrderRequest
 					side = common.SideSell
 					deltaCoinQty = deltaBTCQty * (1 - VALUE_ERR)
 				}
+				qty, ok := FormatQty(pairSymbol, deltaCoinQty)
+				if !ok {
+					log.Println("Rebalancing qty of " + pairSymbol + " is out of range")
+					continue
+				}
 				fromBTC = append(fromBTC, &common.OrderRequest{
 					pairSymbol,
 					side,
 					common.TypeMarket,
-					FormatQty(pairSymbol, deltaCoinQty),
-					0.0,
+					qty,
+					common.Decimal{},
 				})
 			}
 		}
@@ -181,25 +214,36 @@ func scheduleTrades(projectedBTCQtys map[string]float64) []*common.OrderRequest
 
 func executeTrades(scheduledTrades []*common.OrderRequest) {
 	// TODO implement proper executor
-	for _, orderRequest := range scheduledTrades {
-		ts := common.UnixMillis(time.Now())
-		clientOrderId := fmt.Sprintf("%s%d", orderRequest.Symbol, ts)
-		res, err := binance.NewOrder(
-			orderRequest.Symbol,
-			orderRequest.Side,
-			orderRequest.Type,
-			orderRequest.Qty,
-			orderRequest.Price,
-			clientOrderId,
-			ts,
-			EXECUTION_MODE_TEST,
-		)
+	for _, scheduledTrade := range scheduledTrades {
+		normalized, err := NormalizeOrder(getSnapshot(), scheduledTrade)
+		if err != nil {
+			log.Println("Skipping trade " + scheduledTrade.String() + ": " + err.Error())
+			continue
+		}
+		if normalized.Check != common.FilterCheckOk {
+			log.Println("Skipping trade " + scheduledTrade.String() + ", did not pass " + string(normalized.Check))
+			continue
+		}
+		if len(normalized.Adjustments) > 0 {
+			log.Println("Trade " + scheduledTrade.String() + " is adjusted: " + normalized.AdjustmentsString())
+		}
+		orderRequest := normalized.Request
+
+		clientOrderId := fmt.Sprintf("%s%d", orderRequest.Symbol, common.UnixMillis(time.Now()))
+		orderManager.Add(clientOrderId, orderRequest.Symbol, nil)
+		res, err := placeOrder(orderRequest, clientOrderId)
 
+		if err != nil || EXECUTION_MODE_TEST {
+			orderManager.Remove(clientOrderId)
+		}
 		if err != nil {
 			log.Println("Qty: ", orderRequest.Qty)
 			log.Println("Symbol: ", orderRequest.Symbol)
 			log.Println("Min notional", GetMinNotional(orderRequest.Symbol))
 		} else {
+			if !EXECUTION_MODE_TEST {
+				orderManager.ApplyResponse(res)
+			}
 			log.Println("Executed trade: ", orderRequest)
 			log.Println("Executed trade res: ", res)
 		}
@@ -219,13 +263,15 @@ func checkWeights() {
 }
 
 func checkCoinsAreFetched() {
+	trianglesMux.RLock()
+	defer trianglesMux.RUnlock()
 	if len(arbCoins) == 0 {
 		panic("Portfolio rebalancer error: rebalancing before arb coins are fetched")
 	}
 }
 
-func checkAccountInfoIsFetched() {
-	if account == nil {
+func checkAccountInfoIsFetched(snapshot *MarketSnapshot) {
+	if snapshot.Account == nil {
 		panic("Portfolio rebalancer error: rebalancing before account info is fetched")
 	}
 }
\ No newline at end of file
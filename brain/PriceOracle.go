@@ -0,0 +1,117 @@
+package brain
+
+import (
+	"midas/common"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// Longest chain of trades a coin is valued through
+	ORACLE_MAX_HOPS = 3
+	ORACLE_EDGES_TTL_MILLIS = 100
+	REFERENCE_ASSET_USD = "USD"
+)
+
+// Stablecoins USD is valued through, 1:1
+var usdStablecoins = []string{"USDT", "BUSD", "USDC", "TUSD", "PAX"}
+
+// Edges are rebuilt from tickers at most every ORACLE_EDGES_TTL_MILLIS
+var oracleEdges []*cycleEdge
+var oracleEdgesTs time.Time
+var oracleMux sync.Mutex
+
+// Values qty of coin in refAsset (BTC, USDT, USD, ...) through the path which gives the most of refAsset
+func valueIn(qty float64, coin string, refAsset string) (*common.Valuation, error) {
+	if refAsset == REFERENCE_ASSET_USD {
+		var best *common.Valuation
+		for _, stablecoin := range usdStablecoins {
+			valuation, err := valueIn(qty, coin, stablecoin)
+			if err == nil && (best == nil || valuation.Executable > best.Executable) {
+				best = valuation
+			}
+		}
+		if best == nil {
+			return nil, errors.New("No path from " + coin + " to " + refAsset)
+		}
+		best.RefAsset = REFERENCE_ASSET_USD
+		return best, nil
+	}
+
+	valuation := &common.Valuation{
+		Coin: coin,
+		Qty: qty,
+		RefAsset: refAsset,
+		Mid: qty,
+		Executable: qty,
+		Path: make([]string, 0),
+	}
+	if coin == refAsset {
+		return valuation, nil
+	}
+
+	path := findBestPath(getOracleEdges(), coin, refAsset, ORACLE_MAX_HOPS)
+	if path == nil {
+		return nil, errors.New("No path from " + coin + " to " + refAsset)
+	}
+	for _, edge := range path {
+		valuation.Executable *= edge.rate
+		valuation.Mid *= edge.midRate
+		valuation.Path = append(valuation.Path, edge.pair.PairSymbol)
+	}
+
+	return valuation, nil
+}
+
+func getOracleEdges() []*cycleEdge {
+	oracleMux.Lock()
+	defer oracleMux.Unlock()
+	if oracleEdges == nil || time.Since(oracleEdgesTs) > ORACLE_EDGES_TTL_MILLIS * time.Millisecond {
+		snapshot := getSnapshot()
+		oracleEdges = buildCycleEdges(snapshot, snapshot.Pairs)
+		oracleEdgesTs = time.Now()
+	}
+
+	return oracleEdges
+}
+
+// Same relaxation as findNegativeCycles, walks stop at target coin and the lightest one wins
+func findBestPath(edges []*cycleEdge, from string, to string, maxHops int) []*cycleEdge {
+	dist := make([]map[string]float64, maxHops + 1)
+	pred := make([]map[string]*cycleEdge, maxHops + 1)
+	dist[0] = map[string]float64{from: 0}
+	pred[0] = map[string]*cycleEdge{}
+
+	var best []*cycleEdge
+	bestDist := 0.0
+	for k := 1; k <= maxHops; k++ {
+		dist[k] = make(map[string]float64)
+		pred[k] = make(map[string]*cycleEdge)
+		for _, edge := range edges {
+			edgeFrom := edge.from.CoinSymbol
+			if edgeFrom == to || edge.to.CoinSymbol == from {
+				continue
+			}
+			d, ok := dist[k - 1][edgeFrom]
+			if !ok {
+				continue
+			}
+			if cur, ok := dist[k][edge.to.CoinSymbol]; !ok || d + edge.weight < cur {
+				dist[k][edge.to.CoinSymbol] = d + edge.weight
+				pred[k][edge.to.CoinSymbol] = edge
+			}
+		}
+
+		d, ok := dist[k][to]
+		if !ok || (best != nil && d >= bestDist) {
+			continue
+		}
+		if path := walkBack(pred, to, k); path != nil {
+			best = path
+			bestDist = d
+		}
+	}
+
+	return best
+}
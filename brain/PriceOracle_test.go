@@ -0,0 +1,97 @@
+package brain
+
+import (
+	"midas/common"
+	"strings"
+	"testing"
+)
+
+func makeOraclePair(base string, quote string) *common.CoinPair {
+	return &common.CoinPair{
+		PairSymbol: base + quote,
+		BaseCoin: common.Coin{CoinSymbol: base},
+		QuoteCoin: common.Coin{CoinSymbol: quote},
+	}
+}
+
+func makeOracleTicker(symbol string, bidPrice string, askPrice string) *common.Ticker {
+	return &common.Ticker{
+		Symbol: symbol,
+		BidPrice: common.ToDecimal(bidPrice),
+		BidQty: common.ToDecimal("1"),
+		AskPrice: common.ToDecimal(askPrice),
+		AskQty: common.ToDecimal("1"),
+	}
+}
+
+func makeOracleEdges() []*cycleEdge {
+	pairs := []*common.CoinPair{
+		makeOraclePair("BTC", "USDT"),
+		makeOraclePair("ETH", "BTC"),
+		makeOraclePair("ETH", "USDT"),
+		makeOraclePair("XRP", "BTC"),
+		makeOraclePair("LTC", "BTC"),
+		makeOraclePair("LTC", "USDT"),
+		// No ticker
+		makeOraclePair("DOGE", "BTC"),
+	}
+	snapshot := &MarketSnapshot{
+		Tickers: common.NewShardedTickers(common.TickersMap{
+			"BTCUSDT": makeOracleTicker("BTCUSDT", "50000", "50010"),
+			"ETHBTC": makeOracleTicker("ETHBTC", "0.03", "0.0301"),
+			// Same as 0.03 * 50000 through BTC, which pays one more fee
+			"ETHUSDT": makeOracleTicker("ETHUSDT", "1500", "1510"),
+			"XRPBTC": makeOracleTicker("XRPBTC", "0.00001", "0.0000101"),
+			"LTCBTC": makeOracleTicker("LTCBTC", "0.002", "0.00201"),
+			// Direct book pays less than the one through BTC, 0.002 * 50000 = 100
+			"LTCUSDT": makeOracleTicker("LTCUSDT", "50", "150"),
+		}),
+		Pairs: pairs,
+	}
+
+	return buildCycleEdges(snapshot, pairs)
+}
+
+func TestFindBestPath(t *testing.T) {
+	cases := []struct {
+		name string
+		from string
+		to string
+		maxHops int
+		expectedPath string // pair symbols, empty if there is no path
+	}{
+		{"direct", "ETH", "BTC", ORACLE_MAX_HOPS, "ETHBTC"},
+		{"direct reversed", "BTC", "ETH", ORACLE_MAX_HOPS, "ETHBTC"},
+		{"through BTC", "XRP", "USDT", ORACLE_MAX_HOPS, "XRPBTC,BTCUSDT"},
+		{"through BTC reversed", "USDT", "XRP", ORACLE_MAX_HOPS, "BTCUSDT,XRPBTC"},
+		{"fewer fees", "ETH", "USDT", ORACLE_MAX_HOPS, "ETHUSDT"},
+		{"through BTC pays more", "LTC", "USDT", ORACLE_MAX_HOPS, "LTCBTC,BTCUSDT"},
+		{"too many hops", "XRP", "USDT", 1, ""},
+		{"no ticker", "DOGE", "BTC", ORACLE_MAX_HOPS, ""},
+		{"no pair", "ADA", "BTC", ORACLE_MAX_HOPS, ""},
+	}
+
+	edges := makeOracleEdges()
+	for _, c := range cases {
+		path := findBestPath(edges, c.from, c.to, c.maxHops)
+		symbols := make([]string, 0, len(path))
+		for _, edge := range path {
+			symbols = append(symbols, edge.pair.PairSymbol)
+		}
+		if strings.Join(symbols, ",") != c.expectedPath {
+			t.Errorf("%s: expected path %q, got %q", c.name, c.expectedPath, strings.Join(symbols, ","))
+			continue
+		}
+		if len(path) == 0 {
+			continue
+		}
+		if path[0].from.CoinSymbol != c.from || path[len(path) - 1].to.CoinSymbol != c.to {
+			t.Errorf("%s: path goes from %s to %s", c.name, path[0].from.CoinSymbol, path[len(path) - 1].to.CoinSymbol)
+		}
+		for i := 1; i < len(path); i++ {
+			if path[i].from != path[i - 1].to {
+				t.Errorf("%s: path is broken at %s", c.name, path[i].pair.PairSymbol)
+			}
+		}
+	}
+}
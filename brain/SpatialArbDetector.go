@@ -0,0 +1,227 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/common/arb"
+	"midas/logging"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SPATIAL_DETECTION_PERIOD_MILLIS = 100
+	// Used for exchanges missing in EXCHANGE_FEES
+	DEFAULT_EXCHANGE_FEE = 0.002
+)
+
+
+var spatialArbStates = sync.Map{}
+
+func updateExchangeTickersMap(exchange string, source *common.TickersMap) {
+	updateSnapshot(func(next *MarketSnapshot) bool {
+		prev := next.ExchangeTickers[exchange]
+		delta := prev.Diff(source)
+		if delta.IsEmpty() {
+			return false
+		}
+		tickers := prev.WithDelta(delta)
+
+		exchangeTickers := make(map[string]*common.ShardedTickers, len(next.ExchangeTickers) + 1)
+		for otherExchange, otherTickers := range next.ExchangeTickers {
+			exchangeTickers[otherExchange] = otherTickers
+		}
+		exchangeTickers[exchange] = tickers
+		next.ExchangeTickers = exchangeTickers
+		return true
+	})
+}
+
+// Publishes account of exchange other than Binance, fetched by whatever trades on that exchange
+func SetExchangeAccount(exchange string, account *common.Account) {
+	updateSnapshot(func(next *MarketSnapshot) bool {
+		exchangeAccounts := make(map[string]*common.Account, len(next.ExchangeAccounts) + 1)
+		for otherExchange, otherAccount := range next.ExchangeAccounts {
+			exchangeAccounts[otherExchange] = otherAccount
+		}
+		exchangeAccounts[exchange] = account
+		next.ExchangeAccounts = exchangeAccounts
+		return true
+	})
+}
+
+func getExchangeTicker(snapshot *MarketSnapshot, exchange string, symbol string) *common.Ticker {
+	if exchange == common.BINANCE {
+		return snapshot.GetTicker(symbol)
+	}
+
+	return snapshot.ExchangeTickers[exchange].Get(symbol)
+}
+
+func getExchangeFreeBalance(snapshot *MarketSnapshot, exchange string, coinSymbol string) float64 {
+	if exchange == common.BINANCE {
+		return getAvailableBalance(snapshot, coinSymbol).Float64()
+	}
+
+	exchangeAccount := snapshot.ExchangeAccounts[exchange]
+	if exchangeAccount == nil {
+		return 0
+	}
+	balance, ok := exchangeAccount.Balances[coinSymbol]
+	if !ok || balance == nil {
+		return 0
+	}
+
+	return balance.Free.Float64()
+}
+
+func getExchangeFee(exchange string) float64 {
+	if fee, ok := brainConfig.EXCHANGE_FEES[exchange]; ok {
+		return fee
+	}
+	if exchange == common.BINANCE {
+		return executionThresholds.FEE
+	}
+
+	return DEFAULT_EXCHANGE_FEE
+}
+
+// Symbol -> exchanges listing it in pairsPerExchange, only symbols on more than one exchange
+func getSpatialSymbols() map[string][]string {
+	exchangesPerSymbol := make(map[string][]string)
+	for exchange, symbols := range pairsPerExchange {
+		for _, symbol := range symbols {
+			exchangesPerSymbol[symbol] = append(exchangesPerSymbol[symbol], exchange)
+		}
+	}
+	for symbol, exchanges := range exchangesPerSymbol {
+		if len(exchanges) < 2 {
+			delete(exchangesPerSymbol, symbol)
+		}
+	}
+
+	return exchangesPerSymbol
+}
+
+// Compares books of symbols listed on several exchanges, does nothing with a single venue.
+// Eyes only serve Binance for now, so it starts once eyes and pairsPerExchange get another exchange.
+func runDetectSpatialArb() {
+	spatialSymbols := getSpatialSymbols()
+	if len(spatialSymbols) == 0 {
+		return
+	}
+
+	detectionWg.Add(1)
+	go func() {
+		defer detectionWg.Done()
+		log.Println("Looking for spatial arbs in " + strconv.Itoa(len(spatialSymbols)) + " symbols...")
+		for {
+			select {
+			case <-stopDetection:
+				return
+			case <-time.After(SPATIAL_DETECTION_PERIOD_MILLIS * time.Millisecond):
+			}
+
+			snapshot := getSnapshot()
+			for symbol, exchanges := range spatialSymbols {
+				for _, buyExchange := range exchanges {
+					for _, sellExchange := range exchanges {
+						if buyExchange == sellExchange {
+							continue
+						}
+						arbState := findSpatialArb(snapshot, symbol, buyExchange, sellExchange)
+						if arbState == nil {
+							continue
+						}
+						res, loaded := spatialArbStates.LoadOrStore(arbState.Key, arbState)
+						if loaded {
+							res.(*arb.SpatialState).Touch(time.Now())
+						} else {
+							log.Println("Detected spatial " + arbState.Key)
+						}
+					}
+				}
+			}
+			reportStaleSpatialArbStates()
+		}
+	}()
+}
+
+// Buys symbol at ask on buyExchange and sells it at bid on sellExchange
+func findSpatialArb(snapshot *MarketSnapshot, symbol string, buyExchange string, sellExchange string) *arb.SpatialState {
+	buyTicker := getExchangeTicker(snapshot, buyExchange, symbol)
+	sellTicker := getExchangeTicker(snapshot, sellExchange, symbol)
+	if buyTicker == nil || sellTicker == nil || !buyTicker.AskPrice.IsPositive() {
+		return nil
+	}
+	buyPrice := buyTicker.AskPrice.Float64()
+	sellPrice := sellTicker.BidPrice.Float64()
+
+	buyFee := getExchangeFee(buyExchange)
+	sellFee := getExchangeFee(sellExchange)
+	// Fee is taken from base bought and from quote received
+	profit := sellPrice * (1 - sellFee) * (1 - buyFee) / buyPrice - 1
+	if profit <= 0 {
+		return nil
+	}
+
+	pair := snapshot.GetPair(symbol)
+	if pair == nil {
+		return nil
+	}
+
+	// Quote is spent on buy side and base is sold on sell side, both from inventory held there
+	bookQty := math.Min(buyTicker.AskQty.Float64(), sellTicker.BidQty.Float64())
+	inventoryQty := math.Min(
+		getExchangeFreeBalance(snapshot, buyExchange, pair.QuoteCoin.CoinSymbol) / buyPrice,
+		getExchangeFreeBalance(snapshot, sellExchange, pair.BaseCoin.CoinSymbol))
+	qty := math.Min(bookQty, inventoryQty)
+
+	now := time.Now()
+	key := symbol + "_" + buyExchange + "_" + sellExchange + "_" + common.FloatToString(profit)
+
+	return &arb.SpatialState{
+		Id: key + "_" + strconv.FormatInt(common.UnixMillis(now), 10),
+		Key: key,
+		Symbol: symbol,
+		BuyExchange: buyExchange,
+		SellExchange: sellExchange,
+		BuyPrice: buyPrice,
+		SellPrice: sellPrice,
+		BuyFee: buyFee,
+		SellFee: sellFee,
+		Qty: qty,
+		ProfitRelative: profit,
+		ProfitInQuote: qty * buyPrice * profit,
+		InventoryLimited: inventoryQty < bookQty,
+		StartTs: now,
+		LastUpdateTs: now,
+	}
+}
+
+func reportStaleSpatialArbStates() {
+	spatialArbStates.Range(func(k, v interface{}) bool {
+		arbState := v.(*arb.SpatialState)
+		if time.Since(arbState.GetLastUpdateTs()) > time.Duration(brainConfig.ARB_REPORT_UPDATE_THRESHOLD_MICROS) * time.Microsecond {
+			spatialArbStates.Delete(k)
+			logging.QueueEvent(&logging.Event{
+				EventType: logging.EventTypeSpatialArbState,
+				Value: arbState,
+			})
+		}
+		return true
+	})
+}
+
+func reportAllSpatialArbStates() {
+	spatialArbStates.Range(func(k, v interface{}) bool {
+		spatialArbStates.Delete(k)
+		logging.QueueEvent(&logging.Event{
+			EventType: logging.EventTypeSpatialArbState,
+			Value: v.(*arb.SpatialState),
+		})
+		return true
+	})
+}
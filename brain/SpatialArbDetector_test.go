@@ -0,0 +1,171 @@
+package brain
+
+import (
+	"math"
+	"midas/common"
+	"midas/configuration"
+	"testing"
+)
+
+func almostEqual(a float64, b float64) bool {
+	return math.Abs(a - b) < 1e-9
+}
+
+func makeSpatialTicker(symbol string, bidPrice string, bidQty string, askPrice string, askQty string) common.TickersMap {
+	return common.TickersMap{
+		symbol: {
+			Symbol: symbol,
+			BidPrice: common.ToDecimal(bidPrice),
+			BidQty: common.ToDecimal(bidQty),
+			AskPrice: common.ToDecimal(askPrice),
+			AskQty: common.ToDecimal(askQty),
+		},
+	}
+}
+
+func makeSpatialAccount(coinSymbol string, free string) *common.Account {
+	return &common.Account{Balances: map[string]*common.Balance{
+		coinSymbol: {CoinSymbol: coinSymbol, Free: common.ToDecimal(free)},
+	}}
+}
+
+// VENUE_A has a configured fee, VENUE_B uses the default one. BTC is held on VENUE_A and ETH on VENUE_B.
+// Previous config is restored after the test.
+func makeSpatialSnapshot(t *testing.T, tickersA common.TickersMap, tickersB common.TickersMap) *MarketSnapshot {
+	prevConfig := brainConfig
+	t.Cleanup(func() {
+		brainConfig = prevConfig
+	})
+
+	brainConfig = &configuration.BrainConfig{
+		EXCHANGE_FEES: map[string]float64{"VENUE_A": 0.001},
+	}
+
+	return &MarketSnapshot{
+		Tickers: common.NewShardedTickers(nil),
+		ExchangeTickers: map[string]*common.ShardedTickers{
+			"VENUE_A": common.NewShardedTickers(tickersA),
+			"VENUE_B": common.NewShardedTickers(tickersB),
+		},
+		ExchangeAccounts: map[string]*common.Account{
+			"VENUE_A": makeSpatialAccount("BTC", "10"),
+			"VENUE_B": makeSpatialAccount("ETH", "10"),
+		},
+		Pairs: []*common.CoinPair{{
+			PairSymbol: "ETHBTC",
+			BaseCoin: common.Coin{CoinSymbol: "ETH"},
+			QuoteCoin: common.Coin{CoinSymbol: "BTC"},
+		}},
+	}
+}
+
+func TestFindSpatialArb(t *testing.T) {
+	snapshot := makeSpatialSnapshot(t,
+		makeSpatialTicker("ETHBTC", "0.0998", "5", "0.1", "2"),
+		makeSpatialTicker("ETHBTC", "0.101", "1.5", "0.1012", "3"))
+
+	state := findSpatialArb(snapshot, "ETHBTC", "VENUE_A", "VENUE_B")
+	if state == nil {
+		t.Fatal("expected arb buying on VENUE_A and selling on VENUE_B")
+	}
+	if state.BuyFee != 0.001 || state.SellFee != DEFAULT_EXCHANGE_FEE {
+		t.Errorf("expected configured fee on VENUE_A and default one on VENUE_B, got %f and %f", state.BuyFee, state.SellFee)
+	}
+	expectedProfit := 0.101 * (1 - DEFAULT_EXCHANGE_FEE) * (1 - 0.001) / 0.1 - 1
+	if !almostEqual(state.ProfitRelative, expectedProfit) {
+		t.Errorf("expected profit %f after fees, got %f", expectedProfit, state.ProfitRelative)
+	}
+	// Bid on VENUE_B is smaller than ask on VENUE_A
+	if state.Qty != 1.5 || state.InventoryLimited || !almostEqual(state.ProfitInQuote, 1.5 * 0.1 * expectedProfit) {
+		t.Errorf("expected 1.5 limited by sell book, got %f for %f", state.Qty, state.ProfitInQuote)
+	}
+
+	if state := findSpatialArb(snapshot, "ETHBTC", "VENUE_B", "VENUE_A"); state != nil {
+		t.Errorf("expected no arb in other direction, got %s", state.String())
+	}
+	if state := findSpatialArb(snapshot, "BNBBTC", "VENUE_A", "VENUE_B"); state != nil {
+		t.Errorf("expected no arb for symbol without tickers, got %s", state.String())
+	}
+}
+
+func TestFindSpatialArbInventoryLimited(t *testing.T) {
+	snapshot := makeSpatialSnapshot(t,
+		makeSpatialTicker("ETHBTC", "0.0998", "5", "0.1", "2"),
+		makeSpatialTicker("ETHBTC", "0.101", "1.5", "0.1012", "3"))
+
+	cases := []struct {
+		name string
+		accounts map[string]*common.Account
+		expectedQty float64
+	}{
+		// 0.05 BTC buys 0.5 ETH at 0.1
+		{"quote on buy venue", map[string]*common.Account{
+			"VENUE_A": makeSpatialAccount("BTC", "0.05"),
+			"VENUE_B": makeSpatialAccount("ETH", "10"),
+		}, 0.5},
+		{"base on sell venue", map[string]*common.Account{
+			"VENUE_A": makeSpatialAccount("BTC", "10"),
+			"VENUE_B": makeSpatialAccount("ETH", "0.7"),
+		}, 0.7},
+		// Venue without account holds nothing
+		{"no account", map[string]*common.Account{
+			"VENUE_A": makeSpatialAccount("BTC", "10"),
+		}, 0},
+	}
+	for _, c := range cases {
+		snapshot.ExchangeAccounts = c.accounts
+		state := findSpatialArb(snapshot, "ETHBTC", "VENUE_A", "VENUE_B")
+		if state == nil {
+			t.Errorf("%s: expected arb", c.name)
+			continue
+		}
+		if !almostEqual(state.Qty, c.expectedQty) || !state.InventoryLimited {
+			t.Errorf("%s: expected %f limited by inventory, got %f", c.name, c.expectedQty, state.Qty)
+		}
+		if !almostEqual(state.ProfitInQuote, c.expectedQty * 0.1 * state.ProfitRelative) {
+			t.Errorf("%s: expected profit for %f, got %f", c.name, c.expectedQty, state.ProfitInQuote)
+		}
+	}
+}
+
+func TestFindSpatialArbFeesEatSpread(t *testing.T) {
+	// 0.25% spread is less than 0.3% paid in fees on both venues
+	snapshot := makeSpatialSnapshot(t,
+		makeSpatialTicker("ETHBTC", "0.0998", "5", "0.1", "2"),
+		makeSpatialTicker("ETHBTC", "0.10025", "5", "0.1012", "3"))
+
+	if state := findSpatialArb(snapshot, "ETHBTC", "VENUE_A", "VENUE_B"); state != nil {
+		t.Errorf("expected fees to eat the spread, got %s", state.String())
+	}
+
+	// Same books are profitable without fees on VENUE_C
+	snapshot.ExchangeTickers["VENUE_C"] = snapshot.ExchangeTickers["VENUE_B"]
+	snapshot.ExchangeAccounts["VENUE_C"] = snapshot.ExchangeAccounts["VENUE_B"]
+	brainConfig.EXCHANGE_FEES["VENUE_C"] = 0
+	if state := findSpatialArb(snapshot, "ETHBTC", "VENUE_A", "VENUE_C"); state == nil || state.SellFee != 0 {
+		t.Error("expected arb on venue without fee")
+	}
+}
+
+func TestSetExchangeAccount(t *testing.T) {
+	prev := getSnapshot()
+	t.Cleanup(func() {
+		currentSnapshot.Store(prev)
+	})
+
+	SetExchangeAccount("VENUE_A", makeSpatialAccount("BTC", "1"))
+	before := getSnapshot()
+	SetExchangeAccount("VENUE_B", makeSpatialAccount("ETH", "2"))
+	snapshot := getSnapshot()
+
+	if getExchangeFreeBalance(snapshot, "VENUE_A", "BTC") != 1 || getExchangeFreeBalance(snapshot, "VENUE_B", "ETH") != 2 {
+		t.Error("expected balances of both venues")
+	}
+	if getExchangeFreeBalance(snapshot, "VENUE_C", "BTC") != 0 || getExchangeFreeBalance(snapshot, "VENUE_A", "ETH") != 0 {
+		t.Error("expected no inventory for unknown venue and coin")
+	}
+	// Published snapshots are never modified
+	if before.ExchangeAccounts["VENUE_B"] != nil {
+		t.Error("expected previous snapshot to stay without VENUE_B")
+	}
+}
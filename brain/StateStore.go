@@ -0,0 +1,91 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/common/arb"
+	"sync"
+	"sync/atomic"
+)
+
+// Consistent view of market and account state. Snapshots are never mutated once published:
+// updates build the next one from the latest and swap it in, so readers need no locks and
+// everything read from one snapshot belongs together.
+type MarketSnapshot struct {
+	Version int64
+	Tickers *common.ShardedTickers
+	TickersFrame *arb.FrameSighting // eye frame tickers come from, nil if brain fetched them itself
+	ExchangeTickers map[string]*common.ShardedTickers // exchanges other than Binance, symbols in Binance format (base + quote)
+	Account *common.Account // nil until account info is fetched
+	ExchangeAccounts map[string]*common.Account // exchanges other than Binance, exchanges without account hold nothing
+	ExchangeInfo *common.ExchangeInfo // nil until exchange info is fetched
+	Pairs []*common.CoinPair
+	Filters common.FiltersMap
+	SymbolFilters map[string]*common.SymbolFilters
+}
+
+var currentSnapshot atomic.Value
+// Serializes writers, so no update is lost between reading the latest snapshot and publishing the next one
+var snapshotWriteMux sync.Mutex
+
+func init() {
+	currentSnapshot.Store(&MarketSnapshot{
+		Tickers: common.NewShardedTickers(nil),
+		Filters: make(common.FiltersMap),
+		SymbolFilters: make(map[string]*common.SymbolFilters),
+	})
+}
+
+// Latest published snapshot, must not be modified
+func getSnapshot() *MarketSnapshot {
+	return currentSnapshot.Load().(*MarketSnapshot)
+}
+
+// Calls update with a copy of the latest snapshot and publishes it under the next version,
+// unless update returns false. Copy is shallow: update has to replace maps and pointers it changes, not modify them.
+func updateSnapshot(update func(next *MarketSnapshot) bool) bool {
+	snapshotWriteMux.Lock()
+	defer snapshotWriteMux.Unlock()
+
+	next := *getSnapshot()
+	if !update(&next) {
+		return false
+	}
+	next.Version++
+	currentSnapshot.Store(&next)
+
+	return true
+}
+
+// Nil if there is no ticker for symbol
+func (s *MarketSnapshot) GetTicker(symbol string) *common.Ticker {
+	return s.Tickers.Get(symbol)
+}
+
+func (s *MarketSnapshot) GetBalance(coinSymbol string) *common.Balance {
+	if s.Account == nil {
+		return nil
+	}
+
+	return s.Account.Balances[coinSymbol]
+}
+
+// Zero if account is not fetched or has no such coin
+func (s *MarketSnapshot) GetFreeBalance(coinSymbol string) common.Decimal {
+	balance := s.GetBalance(coinSymbol)
+	if balance == nil {
+		return common.Decimal{}
+	}
+
+	return balance.Free
+}
+
+// Nil if there is no such pair or pairs are not fetched yet
+func (s *MarketSnapshot) GetPair(symbol string) *common.CoinPair {
+	for _, pair := range s.Pairs {
+		if pair.PairSymbol == symbol {
+			return pair
+		}
+	}
+
+	return nil
+}
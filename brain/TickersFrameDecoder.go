@@ -0,0 +1,82 @@
+package brain
+
+import (
+	"midas/common"
+	"strconv"
+	"sync"
+	"errors"
+)
+
+// Rebuilds full tickers map from keyframes and deltas sent by a single eye
+type TickersFrameDecoder struct {
+	tickersMap common.TickersMap
+	lastSeq int64
+	needsKeyframe bool
+	mux sync.Mutex
+}
+
+func NewTickersFrameDecoder() *TickersFrameDecoder {
+	return &TickersFrameDecoder{
+		tickersMap: make(common.TickersMap),
+		lastSeq: -1,
+		needsKeyframe: true,
+	}
+}
+
+func (d *TickersFrameDecoder) NeedsKeyframe() bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.needsKeyframe
+}
+
+// Applies frame from message args to the eye's map and calls onApplied with it.
+// onApplied runs under decoder lock, map must not be retained after it returns
+func (d *TickersFrameDecoder) ApplyFrame(args map[string]string, onApplied func(tickersMap *common.TickersMap)) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	seq, err := strconv.ParseInt(args[common.TICKERS_FRAME_SEQ], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	switch args[common.TICKERS_FRAME_TYPE] {
+	case common.TICKERS_FRAME_KEY:
+		if seq <= d.lastSeq {
+			return errors.New("stale keyframe " + strconv.FormatInt(seq, 10))
+		}
+		serialized, err := common.DecompressString(args[common.TICKERS_MAP_SERIALIZED])
+		if err != nil {
+			d.needsKeyframe = true
+			return err
+		}
+		keyframe := common.DeserializeTickersMap(serialized)
+		delta := common.DiffTickersMaps(&d.tickersMap, keyframe)
+		d.tickersMap.ApplyDelta(delta)
+	case common.TICKERS_FRAME_DELTA:
+		if d.lastSeq < 0 || seq != d.lastSeq + 1 {
+			// Frame is missing or reordered, base is unknown until next keyframe
+			d.needsKeyframe = true
+			return errors.New("unexpected delta frame " + strconv.FormatInt(seq, 10) + " after " + strconv.FormatInt(d.lastSeq, 10))
+		}
+		serialized, err := common.DecompressString(args[common.TICKERS_DELTA_SERIALIZED])
+		if err != nil {
+			d.needsKeyframe = true
+			return err
+		}
+		delta, err := common.DeserializeTickersDelta(serialized)
+		if err != nil {
+			d.needsKeyframe = true
+			return err
+		}
+		d.tickersMap.ApplyDelta(delta)
+	default:
+		return errors.New("unknown tickers frame type " + args[common.TICKERS_FRAME_TYPE])
+	}
+
+	d.lastSeq = seq
+	d.needsKeyframe = false
+	onApplied(&d.tickersMap)
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package brain
+
+import (
+	"midas/common"
+	"strconv"
+	"testing"
+)
+
+// Encodes frames the way eye's TickersFrameEncoder does: keyframe first, then diffs with the previous frame
+type testFrameEncoder struct {
+	lastSent *common.TickersMap
+	seq int64
+}
+
+func (e *testFrameEncoder) keyframe(tickers *common.TickersMap) map[string]string {
+	e.seq++
+	e.lastSent = tickers
+	return map[string]string{
+		common.TICKERS_FRAME_TYPE: common.TICKERS_FRAME_KEY,
+		common.TICKERS_FRAME_SEQ: strconv.FormatInt(e.seq, 10),
+		common.TICKERS_MAP_SERIALIZED: common.CompressString(tickers.Serialize()),
+	}
+}
+
+// Delta of the first frame is based on an empty map
+func (e *testFrameEncoder) delta(tickers *common.TickersMap) map[string]string {
+	e.seq++
+	if e.lastSent == nil {
+		e.lastSent = &common.TickersMap{}
+	}
+	delta := common.DiffTickersMaps(e.lastSent, tickers)
+	e.lastSent = tickers
+	return map[string]string{
+		common.TICKERS_FRAME_TYPE: common.TICKERS_FRAME_DELTA,
+		common.TICKERS_FRAME_SEQ: strconv.FormatInt(e.seq, 10),
+		common.TICKERS_DELTA_SERIALIZED: common.CompressString(delta.Serialize()),
+	}
+}
+
+// Copy of the decoder's map as onApplied sees it, nil if frame was not applied
+func applyTestFrame(t *testing.T, decoder *TickersFrameDecoder, args map[string]string) (common.TickersMap, error) {
+	var applied common.TickersMap
+	err := decoder.ApplyFrame(args, func(tickersMap *common.TickersMap) {
+		applied = make(common.TickersMap, len(*tickersMap))
+		for symbol, ticker := range *tickersMap {
+			applied[symbol] = ticker
+		}
+	})
+	if err == nil && applied == nil {
+		t.Error("frame is applied without calling onApplied")
+	}
+
+	return applied, err
+}
+
+func expectTickers(t *testing.T, name string, expected *common.TickersMap, actual common.TickersMap) {
+	if actual == nil || len(actual) != len(*expected) || !common.DiffTickersMaps(expected, &actual).IsEmpty() {
+		t.Errorf("%s: expected %s, got %s", name, expected.Serialize(), actual.Serialize())
+	}
+}
+
+func makeFrameTickers(tickers ...*common.Ticker) *common.TickersMap {
+	tickersMap := make(common.TickersMap)
+	for _, ticker := range tickers {
+		tickersMap[ticker.Symbol] = ticker
+	}
+
+	return &tickersMap
+}
+
+func makeFrameTicker(symbol string, bid string, bidQty string, ask string) *common.Ticker {
+	return &common.Ticker{
+		Symbol: symbol,
+		BidPrice: common.ToDecimal(bid),
+		BidQty: common.ToDecimal(bidQty),
+		AskPrice: common.ToDecimal(ask),
+		AskQty: common.ToDecimal("1"),
+	}
+}
+
+func TestApplyFrameRoundTrip(t *testing.T) {
+	frames := []*common.TickersMap{
+		makeFrameTickers(
+			makeFrameTicker("ETHBTC", "0.03", "1", "0.031"),
+			makeFrameTicker("BNBBTC", "0.002", "1", "0.0021"),
+		),
+		// Changed and added
+		makeFrameTickers(
+			makeFrameTicker("ETHBTC", "0.0301", "1", "0.031"),
+			makeFrameTicker("BNBBTC", "0.002", "1", "0.0021"),
+			makeFrameTicker("LTCBTC", "0.005", "2", "0.0051"),
+		),
+		// Unchanged
+		makeFrameTickers(
+			makeFrameTicker("ETHBTC", "0.0301", "1", "0.031"),
+			makeFrameTicker("BNBBTC", "0.002", "1", "0.0021"),
+			makeFrameTicker("LTCBTC", "0.005", "2", "0.0051"),
+		),
+		// Removed
+		makeFrameTickers(
+			makeFrameTicker("LTCBTC", "0.005", "3", "0.0051"),
+		),
+	}
+
+	encoder := &testFrameEncoder{}
+	decoder := NewTickersFrameDecoder()
+	if !decoder.NeedsKeyframe() {
+		t.Error("expected new decoder to need keyframe")
+	}
+	for i, frame := range frames {
+		var args map[string]string
+		if i == 0 {
+			args = encoder.keyframe(frame)
+		} else {
+			args = encoder.delta(frame)
+		}
+		applied, err := applyTestFrame(t, decoder, args)
+		if err != nil {
+			t.Fatalf("frame %d: unexpected error %s", i, err.Error())
+		}
+		expectTickers(t, "frame " + args[common.TICKERS_FRAME_SEQ], frame, applied)
+	}
+	if decoder.NeedsKeyframe() {
+		t.Error("expected decoder in sync")
+	}
+
+	// Keyframe drops symbols missing from it
+	last := makeFrameTickers(makeFrameTicker("XRPBTC", "0.00001", "1", "0.000011"))
+	applied, err := applyTestFrame(t, decoder, encoder.keyframe(last))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTickers(t, "keyframe", last, applied)
+}
+
+func TestApplyFrameResyncAfterMissedDelta(t *testing.T) {
+	first := makeFrameTickers(makeFrameTicker("ETHBTC", "0.03", "1", "0.031"))
+	second := makeFrameTickers(makeFrameTicker("ETHBTC", "0.0301", "1", "0.031"))
+	third := makeFrameTickers(
+		makeFrameTicker("ETHBTC", "0.0302", "1", "0.031"),
+		makeFrameTicker("BNBBTC", "0.002", "1", "0.0021"),
+	)
+
+	encoder := &testFrameEncoder{}
+	decoder := NewTickersFrameDecoder()
+	if _, err := applyTestFrame(t, decoder, encoder.delta(first)); err == nil {
+		t.Error("expected delta before first keyframe to be rejected")
+	}
+	if _, err := applyTestFrame(t, decoder, encoder.keyframe(first)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Frame of second is lost, delta of third is based on it
+	encoder.delta(second)
+	if applied, err := applyTestFrame(t, decoder, encoder.delta(third)); err == nil || applied != nil {
+		t.Error("expected delta after missed frame to be rejected")
+	}
+	if !decoder.NeedsKeyframe() {
+		t.Error("expected decoder to need keyframe after missed delta")
+	}
+	if _, err := applyTestFrame(t, decoder, encoder.delta(third)); err == nil {
+		t.Error("expected deltas to be rejected until keyframe")
+	}
+
+	applied, err := applyTestFrame(t, decoder, encoder.keyframe(third))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTickers(t, "resync keyframe", third, applied)
+	if decoder.NeedsKeyframe() {
+		t.Error("expected decoder in sync after keyframe")
+	}
+
+	// Keyframes arriving late are stale
+	stale := encoder.keyframe(first)
+	encoder.seq--
+	encoder.lastSent = third
+	stale[common.TICKERS_FRAME_SEQ] = strconv.FormatInt(encoder.seq - 1, 10)
+	if _, err := applyTestFrame(t, decoder, stale); err == nil {
+		t.Error("expected stale keyframe to be rejected")
+	}
+
+	applied, err = applyTestFrame(t, decoder, encoder.delta(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTickers(t, "delta after resync", second, applied)
+}
@@ -0,0 +1,191 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/common/arb"
+	"log"
+	"strconv"
+	"sync"
+)
+
+// coin -> neighbour coin -> pair trading them
+var coinGraph = make(map[string]map[string]*common.CoinPair)
+// symbol -> pair currently in coinGraph
+var graphPairs = make(map[string]*common.CoinPair)
+
+// Guards coinGraph, graphPairs, arbTriangles, arbCoins, arbPairs and triangleIndex
+var trianglesMux sync.RWMutex
+var trianglesInitialized = false
+
+// Brings triangles in line with pairs: triangles of new pairs are added through adjacency lookups,
+// triangles of pairs which are gone or changed are removed. Pairs and triangles out of universe are left out,
+// rescan looks for triangles of pairs already in graph, needed when triangle lists of universe change.
+func updateArbTriangles(pairs []*common.CoinPair, rescan bool) (added []*arb.Triangle, removed []*arb.Triangle) {
+	trianglesMux.Lock()
+	defer trianglesMux.Unlock()
+
+	newPairs := make(map[string]*common.CoinPair)
+	for _, pair := range pairs {
+		if isPairAllowed(pair) {
+			newPairs[pair.PairSymbol] = pair
+		}
+	}
+
+	for symbol, pair := range graphPairs {
+		newPair, ok := newPairs[symbol]
+		if !ok || *newPair != *pair {
+			removed = append(removed, removePairFromGraph(pair)...)
+		}
+	}
+
+	for key, triangle := range arbTriangles {
+		if !isTriangleAllowed(key) {
+			delete(arbTriangles, key)
+			triangleIndex.Remove(triangle)
+			removed = append(removed, triangle)
+		}
+	}
+
+	for symbol, pair := range newPairs {
+		if _, ok := graphPairs[symbol]; !ok {
+			added = append(added, addPairToGraph(pair)...)
+		} else if rescan {
+			added = append(added, findPairTriangles(pair)...)
+		}
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		rebuildArbCoinsAndPairs()
+	}
+	trianglesInitialized = true
+
+	return added, removed
+}
+
+func addPairToGraph(pair *common.CoinPair) []*arb.Triangle {
+	base := pair.BaseCoin.CoinSymbol
+	quote := pair.QuoteCoin.CoinSymbol
+	graphPairs[pair.PairSymbol] = pair
+	linkCoins(base, quote, pair)
+	linkCoins(quote, base, pair)
+
+	return findPairTriangles(pair)
+}
+
+// Adds triangles pair closes which are not tracked yet
+func findPairTriangles(pair *common.CoinPair) []*arb.Triangle {
+	base := pair.BaseCoin.CoinSymbol
+	quote := pair.QuoteCoin.CoinSymbol
+
+	// Every coin connected to both base and quote closes a triangle
+	added := make([]*arb.Triangle, 0)
+	for coinC, pairBC := range coinGraph[quote] {
+		if coinC == base {
+			continue
+		}
+		pairAC, ok := coinGraph[base][coinC]
+		if !ok {
+			continue
+		}
+
+		key, triangle := makeTriangle(pair, pairBC, pairAC)
+		if arbTriangles[key] == nil && isTriangleAllowed(key) {
+			arbTriangles[key] = triangle
+			triangleIndex.Add(triangle)
+			added = append(added, triangle)
+		}
+	}
+
+	return added
+}
+
+func removePairFromGraph(pair *common.CoinPair) []*arb.Triangle {
+	delete(graphPairs, pair.PairSymbol)
+	unlinkCoins(pair.BaseCoin.CoinSymbol, pair.QuoteCoin.CoinSymbol)
+	unlinkCoins(pair.QuoteCoin.CoinSymbol, pair.BaseCoin.CoinSymbol)
+
+	removed := make([]*arb.Triangle, 0)
+	for key, triangle := range triangleIndex.TrianglesForSymbols([]string{pair.PairSymbol}) {
+		delete(arbTriangles, key)
+		triangleIndex.Remove(triangle)
+		removed = append(removed, triangle)
+	}
+
+	return removed
+}
+
+func linkCoins(coinA string, coinB string, pair *common.CoinPair) {
+	neighbours, ok := coinGraph[coinA]
+	if !ok {
+		neighbours = make(map[string]*common.CoinPair)
+		coinGraph[coinA] = neighbours
+	}
+	neighbours[coinB] = pair
+}
+
+func unlinkCoins(coinA string, coinB string) {
+	neighbours, ok := coinGraph[coinA]
+	if !ok {
+		return
+	}
+	delete(neighbours, coinB)
+	if len(neighbours) == 0 {
+		delete(coinGraph, coinA)
+	}
+}
+
+func rebuildArbCoinsAndPairs() {
+	coins := make(map[string]bool)
+	pairs := make(map[string]bool)
+	for _, triangle := range arbTriangles {
+		coins[triangle.CoinA.CoinSymbol] = true
+		coins[triangle.CoinB.CoinSymbol] = true
+		coins[triangle.CoinC.CoinSymbol] = true
+		for _, symbol := range triangle.Symbols() {
+			pairs[symbol] = true
+		}
+	}
+	arbCoins = coins
+	arbPairs = pairs
+}
+
+// Called on each exchange info update, triangles are only tracked once detector is initialized
+func onPairsUpdated(pairs []*common.CoinPair) {
+	trianglesMux.RLock()
+	initialized := trianglesInitialized
+	trianglesMux.RUnlock()
+	if !initialized {
+		return
+	}
+
+	added, removed := updateArbTriangles(pairs, false)
+	logTrianglesChange(added, removed)
+	notifyTrianglesAdded(added)
+}
+
+// Evaluates new triangles right away instead of waiting for their tickers to change
+func notifyTrianglesAdded(added []*arb.Triangle) {
+	if len(added) == 0 {
+		return
+	}
+	symbols := make([]string, 0)
+	for _, triangle := range added {
+		symbols = append(symbols, triangle.Symbols()...)
+	}
+	notifyTickersChanged(symbols)
+}
+
+func logTrianglesChange(added []*arb.Triangle, removed []*arb.Triangle) {
+	for _, triangle := range added {
+		log.Println("Triangle appeared: " + triangle.Key + " " + triangle.PairAB.PairSymbol + ", " + triangle.PairBC.PairSymbol + ", " + triangle.PairAC.PairSymbol)
+	}
+	for _, triangle := range removed {
+		log.Println("Triangle went away: " + triangle.Key + " " + triangle.PairAB.PairSymbol + ", " + triangle.PairBC.PairSymbol + ", " + triangle.PairAC.PairSymbol)
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		trianglesMux.RLock()
+		numTriangles := len(arbTriangles)
+		trianglesMux.RUnlock()
+		log.Println("Arb triangles: " + strconv.Itoa(numTriangles) + " (+" + strconv.Itoa(len(added)) + " -" + strconv.Itoa(len(removed)) + ")")
+	}
+}
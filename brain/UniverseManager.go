@@ -0,0 +1,118 @@
+package brain
+
+import (
+	"midas/common"
+	"midas/configuration"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const UNIVERSE_UPDATE_PERIOD_MIN = 1
+
+// Allow and deny lists as sets, nil allow set means everything is allowed
+type universeLists struct {
+	allowedCoins map[string]bool
+	deniedCoins map[string]bool
+	allowedSymbols map[string]bool
+	deniedSymbols map[string]bool
+	allowedTriangles map[string]bool
+	deniedTriangles map[string]bool
+}
+
+var universeConfig = brainConfig.UNIVERSE
+var universe = makeUniverseLists(brainConfig.UNIVERSE)
+var universeMux sync.RWMutex
+
+// Re-reads universe from brain config periodically, changed lists are applied to arb triangles right away
+func RunUniverseUpdates() {
+	go func() {
+		for {
+			time.Sleep(time.Duration(UNIVERSE_UPDATE_PERIOD_MIN) * time.Minute)
+			config, err := configuration.ReloadBrainConfig()
+			if err != nil {
+				log.Println("Unable to reload universe: " + err.Error())
+				continue
+			}
+			updateUniverse(config.UNIVERSE)
+		}
+	}()
+}
+
+func updateUniverse(config *configuration.UniverseConfig) {
+	universeMux.Lock()
+	if reflect.DeepEqual(config, universeConfig) {
+		universeMux.Unlock()
+		return
+	}
+	universeConfig = config
+	universe = makeUniverseLists(config)
+	universeMux.Unlock()
+
+	log.Println("Universe changed, updating arb triangles...")
+	trianglesMux.RLock()
+	initialized := trianglesInitialized
+	trianglesMux.RUnlock()
+	if !initialized {
+		return
+	}
+	added, removed := updateArbTriangles(getSnapshot().Pairs, true)
+	logTrianglesChange(added, removed)
+	notifyTrianglesAdded(added)
+}
+
+func makeUniverseLists(config *configuration.UniverseConfig) *universeLists {
+	if config == nil {
+		return &universeLists{}
+	}
+
+	return &universeLists{
+		allowedCoins: toSet(config.ALLOWED_COINS),
+		deniedCoins: toSet(config.DENIED_COINS),
+		allowedSymbols: toSet(config.ALLOWED_SYMBOLS),
+		deniedSymbols: toSet(config.DENIED_SYMBOLS),
+		allowedTriangles: toSet(config.ALLOWED_TRIANGLES),
+		deniedTriangles: toSet(config.DENIED_TRIANGLES),
+	}
+}
+
+func toSet(list []string) map[string]bool {
+	if len(list) == 0 {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, item := range list {
+		set[item] = true
+	}
+
+	return set
+}
+
+func isAllowed(item string, allowed map[string]bool, denied map[string]bool) bool {
+	if denied[item] {
+		return false
+	}
+
+	return allowed == nil || allowed[item]
+}
+
+func isCoinAllowed(coinSymbol string) bool {
+	universeMux.RLock()
+	defer universeMux.RUnlock()
+	return isAllowed(coinSymbol, universe.allowedCoins, universe.deniedCoins)
+}
+
+func isPairAllowed(pair *common.CoinPair) bool {
+	universeMux.RLock()
+	defer universeMux.RUnlock()
+	return isAllowed(pair.BaseCoin.CoinSymbol, universe.allowedCoins, universe.deniedCoins) &&
+		isAllowed(pair.QuoteCoin.CoinSymbol, universe.allowedCoins, universe.deniedCoins) &&
+		isAllowed(pair.PairSymbol, universe.allowedSymbols, universe.deniedSymbols)
+}
+
+func isTriangleAllowed(key string) bool {
+	universeMux.RLock()
+	defer universeMux.RUnlock()
+	return isAllowed(key, universe.allowedTriangles, universe.deniedTriangles)
+}
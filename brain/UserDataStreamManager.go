@@ -1,10 +1,13 @@
 package brain
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"github.com/gorilla/websocket"
 	"midas/apis/binance"
+	"sync"
 	"time"
 	"encoding/json"
 	"midas/common"
@@ -17,30 +20,48 @@ const (
 	EXECUTION_REPORT_EVENT_TYPE = "executionReport"
 )
 
+// Execution types of execution reports, the rest (NEW, CANCELED, REJECTED, EXPIRED) match order statuses
+const EXECUTION_TYPE_TRADE = "TRADE"
+
 var listenKey *string
+var userDataStream *websocket.Conn
+var userDataStreamStopped = false
+var userDataStreamMux sync.Mutex
+// Released once reading routine stops handling messages
+var userDataStreamWg sync.WaitGroup
 
 func StartUserDataStream() {
-	listenKey, err := binance.GetUserDataStreamListenKey()
+	userDataStreamMux.Lock()
+	defer userDataStreamMux.Unlock()
+	if userDataStreamStopped {
+		return
+	}
+
+	key, err := binance.GetUserDataStreamListenKey()
 	if err != nil {
 		log.Println("Failed to obtain listenKey")
 		return
 	}
-	url := fmt.Sprintf("wss://stream.binance.com:9443/ws/%s", *listenKey)
+	listenKey = key
+	url := fmt.Sprintf("wss://stream.binance.com:9443/ws/%s", *key)
 	log.Println("Connecting to user data stream websocket: ", url)
 	c, _, err := websocket.DefaultDialer.Dial(url, nil)
 	if err != nil {
 		log.Println("Error connecting to user data stream websocket: ", err)
 		return
 	}
+	userDataStream = c
 
 	c.SetCloseHandler(
 		func(code int, text string) error {
-			restartUserDataStream()
+			go restartUserDataStream()
 			return nil
 		},
 	)
 
+	userDataStreamWg.Add(1)
 	go func() {
+		defer userDataStreamWg.Done()
 		defer c.Close()
 		for {
 			_, message, err := c.ReadMessage()
@@ -55,18 +76,39 @@ func StartUserDataStream() {
 	// keep alive user data stream
 	go func() {
 		for {
-			if listenKey != nil {
-				binance.PingUserDataStream(listenKey)
-				log.Println("Pinging user data stream websocket...")
-				time.Sleep(time.Duration(KEEP_ALIVE_USER_DATA_STREAM_PERIOD_MINS) * time.Minute)
+			time.Sleep(time.Duration(KEEP_ALIVE_USER_DATA_STREAM_PERIOD_MINS) * time.Minute)
+			userDataStreamMux.Lock()
+			if userDataStreamStopped || userDataStream != c {
+				userDataStreamMux.Unlock()
+				return
 			}
+			binance.PingUserDataStream(key)
+			userDataStreamMux.Unlock()
+			log.Println("Pinging user data stream websocket...")
 		}
 	} ()
 }
 
+// Closes stream and blocks until message being handled is done, so no order events are queued afterwards
+func StopUserDataStream() {
+	userDataStreamMux.Lock()
+	userDataStreamStopped = true
+	if userDataStream != nil {
+		userDataStream.Close()
+		userDataStream = nil
+	}
+	userDataStreamMux.Unlock()
+
+	userDataStreamWg.Wait()
+}
+
 func restartUserDataStream() {
 	log.Println("Reconnecting to user data stream...")
-	binance.CloseUserDataStream(listenKey)
+	userDataStreamMux.Lock()
+	if listenKey != nil {
+		binance.CloseUserDataStream(listenKey)
+	}
+	userDataStreamMux.Unlock()
 	StartUserDataStream()
 }
 
@@ -110,8 +152,8 @@ func handleMsg(message []byte) {
 			Balances: make(map[string]*common.Balance),
 		}
 		for _, b := range rawAccount.Balances {
-			f := common.ToFloat64(b.Free)
-			l := common.ToFloat64(b.Locked)
+			f := common.ToDecimal(b.Free)
+			l := common.ToDecimal(b.Locked)
 
 			acc.Balances[b.Asset] = &common.Balance{
 				CoinSymbol: b.Asset,
@@ -120,12 +162,85 @@ func handleMsg(message []byte) {
 			}
 		}
 
-		if account == nil || account.LastUpdateTs.Before(acc.LastUpdateTs) {
-			account = acc
-		}
+		updateAccount(acc)
 	case EXECUTION_REPORT_EVENT_TYPE:
-		// TODO handle order execution updates
+		event, err := parseExecutionReport(message)
+		if err != nil {
+			log.Println("Error unmarshaling execution report: ", err)
+			return
+		}
+
+		handleOrderStatusChange(event)
 	default:
 		return
 	}
+}
+
+// Keys differ only in case and json matches struct fields case-insensitively when a key is not declared,
+// so reports are decoded into a map and read by exact keys
+func parseExecutionReport(message []byte) (*common.OrderStatusChangeEvent, error) {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	// Order ids do not survive float64
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	orderId, err := getReportInt(fields, "i")
+	if err != nil {
+		return nil, err
+	}
+	transactTime, err := getReportInt(fields, "T")
+	if err != nil {
+		return nil, err
+	}
+
+	event := &common.OrderStatusChangeEvent{
+		OrderStatus: common.OrderStatus(getReportString(fields, "X")),
+		ClientOrderId: getReportString(fields, "c"),
+		OrderId: orderId,
+		Symbol: getReportString(fields, "s"),
+		Side: common.OrderSide(getReportString(fields, "S")),
+		Type: common.OrderType(getReportString(fields, "o")),
+		Price: common.ToDecimal(getReportString(fields, "p")),
+		OrigQty: common.ToDecimal(getReportString(fields, "q")),
+		ExecutedQty: common.ToDecimal(getReportString(fields, "z")),
+		CumulativeQuoteQty: common.ToDecimal(getReportString(fields, "Z")),
+		TimeInForce: common.TimeInForce(getReportString(fields, "f")),
+		Fills: make([]*common.Fill, 0),
+		TransactTime: common.TimeFromUnixTimestampFloat(float64(transactTime)),
+	}
+	// Canceled orders are reported under client order id of the cancel request
+	if origClientOrderId := getReportString(fields, "C"); origClientOrderId != "" {
+		event.ClientOrderId = origClientOrderId
+	}
+	if rejectReason := getReportString(fields, "r"); rejectReason != "NONE" {
+		event.ErrorMessage = rejectReason
+	}
+	if getReportString(fields, "x") == EXECUTION_TYPE_TRADE {
+		event.Fills = append(event.Fills, &common.Fill{
+			Price: common.ToDecimal(getReportString(fields, "L")),
+			Qty: common.ToDecimal(getReportString(fields, "l")),
+			Commission: common.ToDecimal(getReportString(fields, "n")),
+			CommissionAsset: getReportString(fields, "N"),
+		})
+	}
+
+	return event, nil
+}
+
+// Empty string if key is missing or null, e.g. commission asset before first fill
+func getReportString(fields map[string]interface{}, key string) string {
+	value, _ := fields[key].(string)
+	return value
+}
+
+func getReportInt(fields map[string]interface{}, key string) (int64, error) {
+	number, ok := fields[key].(json.Number)
+	if !ok {
+		return 0, errors.New("Execution report has no number " + key)
+	}
+
+	return number.Int64()
 }
\ No newline at end of file
@@ -0,0 +1,80 @@
+package brain
+
+import (
+	"midas/common"
+	"testing"
+)
+
+// Report of an order resting on the book, as in exchange API docs. Working time W and self trade prevention mode V
+// used to fall on fields with the same keys in other case.
+const NEW_EXECUTION_REPORT = `{"e":"executionReport","E":1499405658658,"s":"ETHBTC","c":"mUvoqJxFIILMdfAW5iGSOW","S":"BUY",` +
+	`"o":"LIMIT","f":"GTC","q":"1.00000000","p":"0.10264410","P":"0.00000000","F":"0.00000000","g":-1,"C":"",` +
+	`"x":"NEW","X":"NEW","r":"NONE","i":4293153,"l":"0.00000000","z":"0.00000000","L":"0.00000000","n":"0",` +
+	`"N":null,"T":1499405658657,"t":-1,"v":3,"I":8641984,"w":true,"m":false,"M":false,"O":1499405658657,` +
+	`"Z":"0.00000000","Y":"0.00000000","Q":"0.00000000","W":1499405658657,"V":"NONE"}`
+
+const TRADE_EXECUTION_REPORT = `{"e":"executionReport","E":1499405658700,"s":"ETHBTC","c":"ETHBTC_1499405658000","S":"SELL",` +
+	`"o":"LIMIT","f":"IOC","q":"2.00000000","p":"0.03000000","P":"0.00000000","F":"0.00000000","g":-1,"C":"",` +
+	`"x":"TRADE","X":"PARTIALLY_FILLED","r":"NONE","i":9007199254740993,"l":"0.50000000","z":"1.50000000",` +
+	`"L":"0.03010000","n":"0.00001505","N":"BTC","T":1499405658699,"t":12345,"I":8641990,"w":false,"m":false,` +
+	`"M":true,"O":1499405658000,"Z":"0.04500000","Y":"0.01505000","Q":"0.00000000","V":"NONE"}`
+
+const CANCELED_EXECUTION_REPORT = `{"e":"executionReport","E":1499405659000,"s":"ETHBTC","c":"web_cancel","S":"BUY",` +
+	`"o":"LIMIT","f":"GTC","q":"1.00000000","p":"0.10264410","P":"0.00000000","F":"0.00000000","g":-1,` +
+	`"C":"mUvoqJxFIILMdfAW5iGSOW","x":"CANCELED","X":"CANCELED","r":"NONE","i":4293153,"l":"0.00000000",` +
+	`"z":"0.00000000","L":"0.00000000","n":"0","N":null,"T":1499405658999,"t":-1,"I":8641999,"w":false,` +
+	`"m":false,"M":false,"O":1499405658657,"Z":"0.00000000","Y":"0.00000000","Q":"0.00000000","V":"NONE"}`
+
+func TestParseExecutionReport(t *testing.T) {
+	cases := []struct {
+		name string
+		message string
+		expected common.OrderStatusChangeEvent
+		fills int
+	}{
+		{"new", NEW_EXECUTION_REPORT, common.OrderStatusChangeEvent{
+			OrderStatus: common.StatusNew, ClientOrderId: "mUvoqJxFIILMdfAW5iGSOW", OrderId: 4293153, Symbol: "ETHBTC",
+			Side: common.SideBuy, Type: common.TypeLimit, Price: common.ToDecimal("0.1026441"), OrigQty: common.ToDecimal("1"), TimeInForce: common.TimeInForce("GTC"),
+		}, 0},
+		{"trade", TRADE_EXECUTION_REPORT, common.OrderStatusChangeEvent{
+			OrderStatus: common.StatusPartiallyFilled, ClientOrderId: "ETHBTC_1499405658000", OrderId: 9007199254740993, Symbol: "ETHBTC",
+			Side: common.SideSell, Type: common.TypeLimit, Price: common.ToDecimal("0.03"), OrigQty: common.ToDecimal("2"), ExecutedQty: common.ToDecimal("1.5"), CumulativeQuoteQty: common.ToDecimal("0.045"),
+			TimeInForce: common.IOC,
+		}, 1},
+		// Reported under client order id of the order, not of the cancel request
+		{"canceled", CANCELED_EXECUTION_REPORT, common.OrderStatusChangeEvent{
+			OrderStatus: common.StatusCanceled, ClientOrderId: "mUvoqJxFIILMdfAW5iGSOW", OrderId: 4293153, Symbol: "ETHBTC",
+			Side: common.SideBuy, Type: common.TypeLimit, Price: common.ToDecimal("0.1026441"), OrigQty: common.ToDecimal("1"), TimeInForce: common.TimeInForce("GTC"),
+		}, 0},
+	}
+
+	for _, c := range cases {
+		event, err := parseExecutionReport([]byte(c.message))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.name, err.Error())
+			continue
+		}
+		e := c.expected
+		if event.OrderStatus != e.OrderStatus || event.ClientOrderId != e.ClientOrderId || event.OrderId != e.OrderId ||
+			event.Symbol != e.Symbol || event.Side != e.Side || event.Type != e.Type || event.TimeInForce != e.TimeInForce ||
+			event.Price != e.Price || event.OrigQty != e.OrigQty ||
+			event.ExecutedQty != e.ExecutedQty || event.CumulativeQuoteQty != e.CumulativeQuoteQty ||
+			event.ErrorMessage != "" || len(event.Fills) != c.fills {
+			t.Errorf("%s: unexpected event %+v", c.name, *event)
+		}
+	}
+
+	event, _ := parseExecutionReport([]byte(TRADE_EXECUTION_REPORT))
+	fill := event.Fills[0]
+	if fill.Price != common.ToDecimal("0.0301") || fill.Qty != common.ToDecimal("0.5") ||
+		fill.Commission != common.ToDecimal("0.00001505") || fill.CommissionAsset != "BTC" {
+		t.Errorf("unexpected fill %+v", *fill)
+	}
+	if common.UnixMillis(event.TransactTime) != 1499405658699 {
+		t.Errorf("unexpected transact time %s", event.TransactTime.String())
+	}
+
+	if _, err := parseExecutionReport([]byte(`{"e":"executionReport","i":"4293153"}`)); err == nil {
+		t.Error("expected error for report without order id")
+	}
+}
@@ -0,0 +1,161 @@
+package brain
+
+import (
+	"midas/common"
+	"sync"
+	"time"
+	"strconv"
+	"log"
+)
+
+// Part of exchange limit eyes are allowed to use, rest is left for weight we can't see (e.g. requests in flight)
+const WEIGHT_BUDGET_SAFETY_FACTOR = 0.9
+
+type weightWindow struct {
+	interval time.Duration
+	limit int64
+	start time.Time
+	used int64
+}
+
+// Tracks request weight used by a single eye's IP in every rate limit interval
+type WeightBudget struct {
+	windows map[string]*weightWindow // interval suffix (e.g. "1m") -> current window
+	mux sync.Mutex
+}
+
+func NewWeightBudget() *WeightBudget {
+	return &WeightBudget{
+		windows: make(map[string]*weightWindow),
+	}
+}
+
+// Reserves weight in every interval, returns false and reserves nothing if any interval would go over its limit
+func (b *WeightBudget) TryReserve(weight int64) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.syncLimits()
+	now := time.Now()
+	for _, window := range b.windows {
+		window.roll(now)
+		if window.used + weight > window.budget() {
+			return false
+		}
+	}
+
+	for _, window := range b.windows {
+		window.used += weight
+	}
+
+	return true
+}
+
+// Releases unused part of reservation and catches up with weight reported by exchange,
+// which also counts requests we did not send (e.g. other processes on the same host)
+func (b *WeightBudget) Settle(reserved int64, consumed int64, usedWeight common.UsedWeight) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	now := time.Now()
+	for interval, window := range b.windows {
+		window.roll(now)
+		if reserved > consumed {
+			window.used -= reserved - consumed
+			if window.used < 0 {
+				window.used = 0
+			}
+		}
+		if reported, ok := usedWeight[interval]; ok && reported > window.used {
+			window.used = reported
+		}
+	}
+}
+
+// Earliest time weight may fit into every interval again: end of the latest window it does not fit into now,
+// now if it fits already
+func (b *WeightBudget) AvailableAt(weight int64, now time.Time) time.Time {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	availableAt := now
+	for _, window := range b.windows {
+		window.roll(now)
+		if window.used + weight <= window.budget() {
+			continue
+		}
+		if reset := window.start.Add(window.interval); reset.After(availableAt) {
+			availableAt = reset
+		}
+	}
+
+	return availableAt
+}
+
+// Returns weight used in current window of every interval
+func (b *WeightBudget) Usage() map[string]int64 {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	now := time.Now()
+	usage := make(map[string]int64)
+	for interval, window := range b.windows {
+		window.roll(now)
+		usage[interval] = window.used
+	}
+
+	return usage
+}
+
+// Keeps windows in line with REQUEST_WEIGHT limits from exchange info
+func (b *WeightBudget) syncLimits() {
+	exchangeInfo := getSnapshot().ExchangeInfo
+	if exchangeInfo == nil {
+		return
+	}
+
+	for _, rateLimit := range exchangeInfo.RateLimits {
+		if rateLimit.RateLimitType != common.RATE_LIMIT_TYPE_REQUEST_WEIGHT {
+			continue
+		}
+		interval, suffix := common.ParseRateLimitInterval(rateLimit.Interval, rateLimit.IntervalNum)
+		if interval == 0 {
+			continue
+		}
+		window, ok := b.windows[suffix]
+		if !ok {
+			window = &weightWindow{interval: interval}
+			b.windows[suffix] = window
+		}
+		window.limit = rateLimit.Limit
+	}
+}
+
+func (w *weightWindow) budget() int64 {
+	return int64(float64(w.limit) * WEIGHT_BUDGET_SAFETY_FACTOR)
+}
+
+// Exchange windows are aligned to clock, e.g. minute window resets at the start of each minute
+func (w *weightWindow) roll(now time.Time) {
+	start := now.Truncate(w.interval)
+	if !start.Equal(w.start) {
+		w.start = start
+		w.used = 0
+	}
+}
+
+func settleEyeWeight(eyeId int, args map[string]string) {
+	reserved, _ := strconv.ParseInt(args[common.WEIGHT_RESERVED], 10, 64)
+	consumed, err := strconv.ParseInt(args[common.WEIGHT_CONSUMED], 10, 64)
+	if err != nil {
+		// Eye did not report weight, keep reservation as is
+		return
+	}
+
+	usedWeight, err := common.DeserializeUsedWeight(args[common.USED_WEIGHT_SERIALIZED])
+	if err != nil {
+		log.Println("Bad used weight from eye " + strconv.Itoa(eyeId) + ": " + err.Error())
+	}
+
+	getEyeHandle(eyeId).WeightBudget.Settle(reserved, consumed, usedWeight)
+}
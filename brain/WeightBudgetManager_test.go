@@ -0,0 +1,117 @@
+package brain
+
+import (
+	"encoding/json"
+	"midas/common"
+	"testing"
+	"time"
+)
+
+// Exchange info with rate limits, previous exchange info is restored after the test
+func useTestRateLimits(t *testing.T, rateLimits string) {
+	prev := getSnapshot()
+	t.Cleanup(func() {
+		currentSnapshot.Store(prev)
+	})
+
+	info := &common.ExchangeInfo{}
+	if err := json.Unmarshal([]byte(`{"rateLimits": ` + rateLimits + `}`), info); err != nil {
+		t.Fatal(err)
+	}
+	updateSnapshot(func(next *MarketSnapshot) bool {
+		next.ExchangeInfo = info
+		return true
+	})
+}
+
+func expectUsage(t *testing.T, name string, budget *WeightBudget, expected map[string]int64) {
+	usage := budget.Usage()
+	if len(usage) != len(expected) {
+		t.Errorf("%s: expected usage %v, got %v", name, expected, usage)
+		return
+	}
+	for interval, used := range expected {
+		if usage[interval] != used {
+			t.Errorf("%s: expected usage %v, got %v", name, expected, usage)
+			return
+		}
+	}
+}
+
+// Day windows keep the test away from window resets
+const TEST_RATE_LIMITS = `[
+	{"rateLimitType": "REQUEST_WEIGHT", "interval": "DAY", "intervalNum": 1, "limit": 50},
+	{"rateLimitType": "REQUEST_WEIGHT", "interval": "DAY", "intervalNum": 2, "limit": 100},
+	{"rateLimitType": "ORDERS", "interval": "DAY", "intervalNum": 1, "limit": 1}
+]`
+
+func TestWeightBudgetReserveAndSettle(t *testing.T) {
+	useTestRateLimits(t, TEST_RATE_LIMITS)
+	budget := NewWeightBudget()
+
+	// Budgets are 45 for 1d and 90 for 2d, order limits do not count
+	if !budget.TryReserve(40) {
+		t.Error("expected 40 to fit")
+	}
+	if budget.TryReserve(10) {
+		t.Error("expected 10 more to go over 1d budget")
+	}
+	expectUsage(t, "reserved", budget, map[string]int64{"1d": 40, "2d": 40})
+
+	// Unused part of reservation is released
+	budget.Settle(40, 10, nil)
+	expectUsage(t, "settled", budget, map[string]int64{"1d": 10, "2d": 10})
+
+	// Reported weight only raises usage
+	budget.Settle(0, 0, common.UsedWeight{"1d": 5, "2d": 80})
+	expectUsage(t, "reported", budget, map[string]int64{"1d": 10, "2d": 80})
+	if budget.TryReserve(20) {
+		t.Error("expected 20 more to go over 2d budget")
+	}
+	if !budget.TryReserve(10) {
+		t.Error("expected 10 to fit")
+	}
+	expectUsage(t, "reserved again", budget, map[string]int64{"1d": 20, "2d": 90})
+
+	// Usage never goes below zero
+	budget.Settle(100, 0, nil)
+	expectUsage(t, "released", budget, map[string]int64{"1d": 0, "2d": 0})
+}
+
+func TestWeightBudgetWithoutExchangeInfo(t *testing.T) {
+	budget := NewWeightBudget()
+	if !budget.TryReserve(1000000) {
+		t.Error("expected no limits before exchange info is fetched")
+	}
+	now := time.Now()
+	if !budget.AvailableAt(1000000, now).Equal(now) {
+		t.Error("expected weight to be available now")
+	}
+}
+
+func TestWeightBudgetAvailableAt(t *testing.T) {
+	useTestRateLimits(t, TEST_RATE_LIMITS)
+	budget := NewWeightBudget()
+	if !budget.TryReserve(40) {
+		t.Fatal("expected 40 to fit")
+	}
+
+	now := time.Now()
+	dayReset := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+	twoDaysReset := now.Truncate(48 * time.Hour).Add(48 * time.Hour)
+	cases := []struct {
+		name string
+		weight int64
+		expected time.Time
+	}{
+		{"fits", 5, now},
+		{"over 1d", 10, dayReset},
+		// Weight has to fit into both windows
+		{"over 1d and 2d", 60, twoDaysReset},
+	}
+	for _, c := range cases {
+		if availableAt := budget.AvailableAt(c.weight, now); !availableAt.Equal(c.expected) {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected.String(), availableAt.String())
+		}
+	}
+}
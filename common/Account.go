@@ -13,6 +13,6 @@ type Account struct {
 
 type Balance struct {
 	CoinSymbol string
-	Free float64
-	Locked float64
+	Free Decimal
+	Locked Decimal
 }
\ No newline at end of file
@@ -0,0 +1,274 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Exchanges quote prices and quantities with at most 8 decimal places
+const DECIMAL_PLACES = 8
+const decimalScale = 100000000
+
+var bigDecimalScale = big.NewInt(decimalScale)
+
+// Fixed-point number with DECIMAL_PLACES decimal places, exact for exchange prices and quantities.
+// Fits values up to ~9.2e10, zero value is 0. Multiplication, division and conversions report
+// results which do not fit instead of wrapping, as exchange data can reach the limit.
+type Decimal struct {
+	units int64 // value * 10^DECIMAL_PLACES
+}
+
+// Fails for digits past DECIMAL_PLACES which are not zeros
+func DecimalFromString(s string) (Decimal, error) {
+	return parseDecimal(s, false)
+}
+
+// Digits past DECIMAL_PLACES are rounded half away from zero instead of failing
+func parseDecimal(s string, round bool) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Decimal{}, errors.New("Empty decimal")
+	}
+
+	negative := false
+	if s[0] == '-' || s[0] == '+' {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	intPart := s
+	fracPart := ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		intPart = s[:dot]
+		fracPart = s[dot + 1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return Decimal{}, errors.New("Bad decimal " + s)
+	}
+	roundUp := false
+	if len(fracPart) > DECIMAL_PLACES {
+		// Digits past precision have to be zeros, otherwise value is not representable
+		if !round && strings.Trim(fracPart[DECIMAL_PLACES:], "0") != "" {
+			return Decimal{}, errors.New("Decimal " + s + " has more than " + strconv.Itoa(DECIMAL_PLACES) + " decimal places")
+		}
+		roundUp = fracPart[DECIMAL_PLACES] >= '5'
+		fracPart = fracPart[:DECIMAL_PLACES]
+	}
+	fracPart += strings.Repeat("0", DECIMAL_PLACES - len(fracPart))
+	if intPart == "" {
+		intPart = "0"
+	}
+
+	units, err := strconv.ParseInt(intPart + fracPart, 10, 64)
+	if err == nil && roundUp {
+		if units == math.MaxInt64 {
+			err = errors.New("value out of range")
+		}
+		units++
+	}
+	if err != nil {
+		return Decimal{}, errors.New("Bad decimal " + s + ": " + err.Error())
+	}
+	if negative {
+		units = -units
+	}
+
+	return Decimal{units}, nil
+}
+
+// Strings past precision are rounded, values which are not decimals or do not fit are errors
+func ParseDecimal(v interface{}) (Decimal, error) {
+	switch v.(type) {
+	case float64:
+		d, ok := DecimalFromFloat(v.(float64))
+		if !ok {
+			return Decimal{}, errors.New("Decimal out of range: " + strconv.FormatFloat(v.(float64), 'g', -1, 64))
+		}
+		return d, nil
+	case string:
+		return parseDecimal(v.(string), true)
+	default:
+		return Decimal{}, errors.New("Not a decimal: " + fmt.Sprint(v))
+	}
+}
+
+// Same as ToFloat64 for decimals, see ParseDecimal. Values which are not decimals or do not fit
+// are logged and give zero, so exchange data which has to be exact is parsed with ParseDecimal instead.
+func ToDecimal(v interface{}) Decimal {
+	if v == nil {
+		return Decimal{}
+	}
+
+	d, err := ParseDecimal(v)
+	if err != nil {
+		log.Println("To decimal error: " + err.Error())
+		return Decimal{}
+	}
+
+	return d
+}
+
+// Rounds f to the nearest representable value, false if it does not fit
+func DecimalFromFloat(f float64) (Decimal, bool) {
+	units := math.Round(f * decimalScale)
+	// Float64 of MaxInt64 rounds up to 2^63, which does not fit
+	if math.IsNaN(units) || units >= math.MaxInt64 || units < math.MinInt64 {
+		return Decimal{}, false
+	}
+
+	return Decimal{int64(units)}, true
+}
+
+func DecimalFromInt(i int64) Decimal {
+	return Decimal{i * decimalScale}
+}
+
+func (d Decimal) Float64() float64 {
+	return float64(d.units) / decimalScale
+}
+
+// Plain notation without trailing zeros, e.g. 0.001
+func (d Decimal) String() string {
+	units := d.units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	intPart := units / decimalScale
+	fracPart := units % decimalScale
+	if fracPart == 0 {
+		return sign + strconv.FormatInt(intPart, 10)
+	}
+	frac := strconv.FormatInt(fracPart, 10)
+	frac = strings.Repeat("0", DECIMAL_PLACES - len(frac)) + frac
+
+	return sign + strconv.FormatInt(intPart, 10) + "." + strings.TrimRight(frac, "0")
+}
+
+func (d Decimal) Add(o Decimal) Decimal {
+	return Decimal{d.units + o.units}
+}
+
+func (d Decimal) Sub(o Decimal) Decimal {
+	return Decimal{d.units - o.units}
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{-d.units}
+}
+
+// Truncates digits past DECIMAL_PLACES, false if product does not fit
+func (d Decimal) Mul(o Decimal) (Decimal, bool) {
+	product := new(big.Int).Mul(big.NewInt(d.units), big.NewInt(o.units))
+	return decimalFromBigUnits(product.Quo(product, bigDecimalScale))
+}
+
+// Truncates digits past DECIMAL_PLACES, false on division by zero and if quotient does not fit
+func (d Decimal) Div(o Decimal) (Decimal, bool) {
+	if o.units == 0 {
+		return Decimal{}, false
+	}
+	dividend := new(big.Int).Mul(big.NewInt(d.units), bigDecimalScale)
+	return decimalFromBigUnits(dividend.Quo(dividend, big.NewInt(o.units)))
+}
+
+func decimalFromBigUnits(units *big.Int) (Decimal, bool) {
+	if !units.IsInt64() {
+		return Decimal{}, false
+	}
+
+	return Decimal{units.Int64()}, true
+}
+
+// -1 if d < o, 0 if d == o, 1 if d > o
+func (d Decimal) Cmp(o Decimal) int {
+	switch {
+	case d.units < o.units:
+		return -1
+	case d.units > o.units:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (d Decimal) LessThan(o Decimal) bool {
+	return d.units < o.units
+}
+
+func (d Decimal) GreaterThan(o Decimal) bool {
+	return d.units > o.units
+}
+
+func (d Decimal) IsZero() bool {
+	return d.units == 0
+}
+
+func (d Decimal) IsPositive() bool {
+	return d.units > 0
+}
+
+func (d Decimal) IsNegative() bool {
+	return d.units < 0
+}
+
+// Rounds down to a multiple of step, zero step leaves d as is
+func (d Decimal) FloorToStep(step Decimal) Decimal {
+	if step.units <= 0 {
+		return d
+	}
+	rem := d.units % step.units
+	if rem < 0 {
+		rem += step.units
+	}
+
+	return Decimal{d.units - rem}
+}
+
+// Rounds up to a multiple of step, zero step leaves d as is
+func (d Decimal) CeilToStep(step Decimal) Decimal {
+	floor := d.FloorToStep(step)
+	if floor == d {
+		return d
+	}
+
+	return floor.Add(step)
+}
+
+// Zero step matches everything, as exchange filters with zero step are disabled
+func (d Decimal) IsMultipleOf(step Decimal) bool {
+	return step.units == 0 || d.units % step.units == 0
+}
+
+// Serialized as string so precision survives JSON
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Accepts both strings and numbers
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), "\"")
+	parsed, err := DecimalFromString(s)
+	if err != nil {
+		// Numbers may come in exponent form, precision is lost anyway then
+		f, floatErr := strconv.ParseFloat(s, 64)
+		if floatErr != nil {
+			return err
+		}
+		var ok bool
+		parsed, ok = DecimalFromFloat(f)
+		if !ok {
+			return errors.New("Decimal out of range: " + s)
+		}
+	}
+	*d = parsed
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecimalFromString(t *testing.T) {
+	cases := map[string]string{
+		"0.00100000": "0.001",
+		"12": "12",
+		"-0.5": "-0.5",
+		".25": "0.25",
+		"0.000000010": "0.00000001",
+	}
+	for in, expected := range cases {
+		d, err := DecimalFromString(in)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", in, err.Error())
+			continue
+		}
+		if d.String() != expected {
+			t.Errorf("%s: expected %s, got %s", in, expected, d.String())
+		}
+	}
+
+	if _, err := DecimalFromString("0.000000001"); err == nil {
+		t.Error("expected error for value past precision")
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	price := ToDecimal("0.03412")
+	qty := ToDecimal("12.5")
+	if got, ok := price.Mul(qty); !ok || got.String() != "0.4265" {
+		t.Errorf("expected 0.4265, got %s", got.String())
+	}
+	if got, ok := ToDecimal("1").Div(ToDecimal("3")); !ok || got.String() != "0.33333333" {
+		t.Errorf("expected 0.33333333, got %s", got.String())
+	}
+	// 0.1 + 0.2 is not exact in float64
+	if got := ToDecimal("0.1").Add(ToDecimal("0.2")); got != ToDecimal("0.3") {
+		t.Errorf("expected 0.3, got %s", got.String())
+	}
+}
+
+func TestDecimalSteps(t *testing.T) {
+	// Steps which are not powers of ten used to make FormatQty loop forever
+	step := ToDecimal("0.05")
+	if got := ToDecimal("1.234").FloorToStep(step).String(); got != "1.2" {
+		t.Errorf("expected 1.2, got %s", got)
+	}
+	if !ToDecimal("1.25").IsMultipleOf(step) {
+		t.Error("expected 1.25 to be a multiple of 0.05")
+	}
+	if ToDecimal("1.26").IsMultipleOf(step) {
+		t.Error("expected 1.26 not to be a multiple of 0.05")
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	ticker := Ticker{Symbol: "ETHBTC", BidPrice: ToDecimal("0.03412")}
+	out, err := json.Marshal(ticker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed Ticker
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed != ticker {
+		t.Errorf("expected %+v, got %+v", ticker, parsed)
+	}
+
+	var number Decimal
+	if err := json.Unmarshal([]byte("0.5"), &number); err != nil || number != ToDecimal("0.5") {
+		t.Errorf("expected 0.5 from JSON number, got %s", number.String())
+	}
+}
+
+func TestDecimalRange(t *testing.T) {
+	// Strings past precision are rounded rather than converted through float
+	if got := ToDecimal("0.123456785").String(); got != "0.12345679" {
+		t.Errorf("expected 0.12345679, got %s", got)
+	}
+	if got := ToDecimal("0.123456784999").String(); got != "0.12345678" {
+		t.Errorf("expected 0.12345678, got %s", got)
+	}
+	if got := ToDecimal("100000000000"); !got.IsZero() {
+		t.Errorf("expected zero for value which does not fit, got %s", got.String())
+	}
+
+	var d Decimal
+	if err := json.Unmarshal([]byte("1e12"), &d); err == nil {
+		t.Errorf("expected error for value which does not fit, got %s", d.String())
+	}
+
+	if _, err := ParseDecimal("100000000000"); err == nil {
+		t.Error("expected parse error for value which does not fit")
+	}
+
+	large := ToDecimal("50000000000")
+	for name, f := range map[string]func() (Decimal, bool){
+		"mul": func() (Decimal, bool) { return large.Mul(ToDecimal("2")) },
+		"div": func() (Decimal, bool) { return large.Div(ToDecimal("0.5")) },
+		"div by zero": func() (Decimal, bool) { return large.Div(Decimal{}) },
+		"float": func() (Decimal, bool) { return DecimalFromFloat(1e11) },
+	} {
+		if d, ok := f(); ok {
+			t.Errorf("%s: expected result out of range, got %s", name, d.String())
+		}
+	}
+}
@@ -6,7 +6,7 @@ import (
 
 type DepthRecord struct {
 	Price,
-	Amount float64
+	Amount Decimal
 }
 
 type Depth struct {
@@ -26,7 +26,7 @@ func (dr DepthRecords) Swap(i, j int) {
 }
 
 func (dr DepthRecords) Less(i, j int) bool {
-	return dr[i].Price < dr[j].Price
+	return dr[i].Price.LessThan(dr[j].Price)
 }
 
 func (depth *Depth) Serialize() string {
@@ -6,6 +6,7 @@ type ExchangeInfo struct {
 	RateLimits []struct {
 		RateLimitType string `json:"rateLimitType"`
 		Interval string `json:"interval"`
+		IntervalNum int64 `json:"intervalNum"`
 		Limit int64 `json:"limit"`
 	} `json:"rateLimits"`
 	Symbols []struct {
@@ -29,6 +30,8 @@ const (
 	FILTER_TYPE_PRICE_FILTER = "PRICE_FILTER"
 	FILTER_TYPE_LOT_SIZE = "LOT_SIZE"
 	FILTER_TYPE_MIN_NOTIONAL = "MIN_NOTIONAL"
+	FILTER_TYPE_PERCENT_PRICE = "PERCENT_PRICE"
+	FILTER_TYPE_MARKET_LOT_SIZE = "MARKET_LOT_SIZE"
 	FILTER_TYPE_ICEBERG_PARTS = "ICEBERG_PARTS"
 	FILTER_TYPE_MAX_NUM_ALGO_ORDERS = "MAX_NUM_ALGO_ORDERS"
 	FILTER_TYPE_MAX_NUM_ORDERS = "MAX_NUM_ORDERS"
@@ -36,6 +39,11 @@ const (
 	FILTER_TYPE_EXCHANGE_MAX_NUM_ALGO_ORDERS = "EXCHANGE_MAX_NUM_ALGO_ORDERS"
 )
 
+const (
+	RATE_LIMIT_TYPE_REQUEST_WEIGHT = "REQUEST_WEIGHT"
+	RATE_LIMIT_TYPE_ORDERS = "ORDERS"
+)
+
 type FilterCheck string
 
 var(
@@ -47,4 +55,5 @@ var(
 	FilterCheckMaxQty = FilterCheck("MAX_QTY")
 	FilterCheckStepSize = FilterCheck("STEP_SIZE")
 	FilterCheckMinNotional = FilterCheck("MIN_NOTIONAL")
+	FilterCheckPercentPrice = FilterCheck("PERCENT_PRICE")
 )
\ No newline at end of file
@@ -0,0 +1,54 @@
+package common
+
+import "encoding/json"
+
+// Runtime settings brain pushes to eyes
+type EyeSettings struct {
+	Version int64 // increased by brain on each change, echoed back in SETTINGS_ACK
+	Symbols map[string][]string // exchange -> symbols eye serves, empty list means all symbols
+	RequestTimeoutMillis int // 0 means no timeout
+	UseWebSocketStreams bool
+}
+
+func (settings *EyeSettings) Serialize() string {
+	out, err := json.Marshal(settings)
+	if err != nil {
+		panic (err)
+	}
+
+	return string(out)
+}
+
+func DeserializeEyeSettings(settingsSerialized string) (*EyeSettings, error) {
+	var settings *EyeSettings
+	err := json.Unmarshal([]byte(settingsSerialized), &settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+func (settings *EyeSettings) ServesExchange(exchange string) bool {
+	_, ok := settings.Symbols[exchange]
+	return ok
+}
+
+func (settings *EyeSettings) ServesSymbol(exchange string, symbol string) bool {
+	symbols, ok := settings.Symbols[exchange]
+	if !ok {
+		return false
+	}
+
+	if len(symbols) == 0 {
+		return true
+	}
+
+	for _, s := range symbols {
+		if s == symbol {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type EyeStatus struct {
+	Version string
+	SettingsVersion int64 // version of settings pushed by brain eye currently runs with
+	StartTs time.Time
+	Uptime time.Duration
+	RequestCounts map[string]int64 // command -> number of requests received
+	ErrorCounts map[string]int64 // command -> number of failed exchange calls
+	AvgLatencyMicros map[string]int64 // command -> avg exchange latency over recent calls
+	MaxLatencyMicros map[string]int64 // command -> max exchange latency over recent calls
+	UsedWeightHeaders map[string]string // last seen used weight header values
+}
+
+func (status *EyeStatus) Serialize() string {
+	out, err := json.Marshal(status)
+	if err != nil {
+		panic (err)
+	}
+
+	return string(out)
+}
+
+func DeserializeEyeStatus(statusSerialized string) *EyeStatus {
+	var status *EyeStatus
+	err := json.Unmarshal([]byte(statusSerialized), &status)
+	if err != nil {
+		panic(err)
+	}
+
+	return status
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"encoding/json"
+	"strconv"
 	"time"
 )
 
@@ -29,6 +30,10 @@ const (
 	KILL_EYE  = "kill_eye"
 	CONF_IN  = "conf_in"
 	CONF_OUT  = "conf_out"
+	STATUS_REQ  = "status_req"
+	STATUS_RESP  = "status_resp"
+	SET_SETTINGS  = "set_settings"
+	SETTINGS_ACK  = "settings_ack"
 )
 
 // Argument keys
@@ -39,6 +44,31 @@ const (
 	EXCHANGE                = "exchange"
 	DEPTH_SERIALIZED        = "depth_serialized"
 	TICKERS_MAP_SERIALIZED        = "tickers_map_serialized"
+	TICKERS_DELTA_SERIALIZED      = "tickers_delta_serialized"
+	TICKERS_FRAME_SEQ             = "tickers_frame_seq"
+	TICKERS_FRAME_TYPE            = "tickers_frame_type"
+	FORCE_KEYFRAME                = "force_keyframe"
+	EYE_STATUS_SERIALIZED         = "eye_status_serialized"
+	EYE_SETTINGS_SERIALIZED       = "eye_settings_serialized"
+	SETTINGS_VERSION              = "settings_version"
+	TRANSPORT                     = "transport"
+	WEIGHT_RESERVED               = "weight_reserved"
+	WEIGHT_CONSUMED               = "weight_consumed"
+	USED_WEIGHT_SERIALIZED        = "used_weight_serialized"
+)
+
+// Transports, tcp is used when TRANSPORT arg is missing
+const (
+	TRANSPORT_INPROC = "inproc"
+	INPROC_PREFIX = "inproc://midas_"
+)
+
+// Tickers frame types
+const (
+	// Full compressed tickers map in TICKERS_MAP_SERIALIZED
+	TICKERS_FRAME_KEY = "key"
+	// Compressed diff with previous frame of the same eye in TICKERS_DELTA_SERIALIZED
+	TICKERS_FRAME_DELTA = "delta"
 )
 
 func (message *Message) SerializeMessage() string {
@@ -60,3 +90,8 @@ func DeserializeMessage(messageJson string) *Message {
 	return message
 }
 
+
+// Endpoint used for port by eyes running inside brain process
+func InprocEndpoint(port int) string {
+	return INPROC_PREFIX + strconv.Itoa(port)
+}
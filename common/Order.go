@@ -43,13 +43,14 @@ type OrderStatusChangeEvent struct {
 	OrderStatus OrderStatus
 	ArbStateId string
 	ClientOrderId string
+	OrderId int64 // exchange's id, zero if order was not accepted
 	Symbol string
 	Side OrderSide
 	Type OrderType
-	Price         float64
-	OrigQty       float64
-	ExecutedQty   float64
-	CumulativeQuoteQty float64
+	Price         Decimal
+	OrigQty       Decimal
+	ExecutedQty   Decimal
+	CumulativeQuoteQty Decimal
 	TimeInForce   TimeInForce
 	Fills 		  []*Fill
 	ErrorMessage string
@@ -63,16 +64,16 @@ type OrderRequest struct {
 	Symbol string
 	Side OrderSide
 	Type OrderType
-	Qty float64
-	Price float64
+	Qty Decimal
+	Price Decimal
 }
 
 func (or OrderRequest) String() string {
 	return "[Side: " + string(or.Side) +
 		" | Symbol: " + or.Symbol +
 			" | Type: " + string(or.Type) +
-				" | Qty: " + FloatToString(or.Qty) +
-					" | Price: " + FloatToString(or.Price) + "]"
+				" | Qty: " + or.Qty.String() +
+					" | Price: " + or.Price.String() + "]"
 }
 
 type ExecutedOrderFullResponse struct {
@@ -80,10 +81,10 @@ type ExecutedOrderFullResponse struct {
 	OrderID       int64
 	ClientOrderID string
 	TransactTime  time.Time
-	Price         float64
-	OrigQty       float64
-	ExecutedQty   float64
-	CumulativeQuoteQty float64
+	Price         Decimal
+	OrigQty       Decimal
+	ExecutedQty   Decimal
+	CumulativeQuoteQty Decimal
 	Status        OrderStatus
 	TimeInForce   TimeInForce
 	Type          OrderType
@@ -91,9 +92,25 @@ type ExecutedOrderFullResponse struct {
 	Fills 		  []*Fill
 }
 
+// Order as exchange reports it in open orders and cancel responses
+type ExchangeOrder struct {
+	Symbol        string
+	OrderID       int64
+	ClientOrderID string
+	Price         Decimal
+	OrigQty       Decimal
+	ExecutedQty   Decimal
+	CumulativeQuoteQty Decimal
+	Status        OrderStatus
+	TimeInForce   TimeInForce
+	Type          OrderType
+	Side          OrderSide
+	Time          time.Time // when order was placed
+}
+
 type Fill struct {
-	Price 		float64
-	Qty 		float64
-	Commission  float64
+	Price 		Decimal
+	Qty 		Decimal
+	Commission  Decimal
 	CommissionAsset string
 }
\ No newline at end of file
@@ -0,0 +1,285 @@
+package common
+
+import (
+	"strings"
+)
+
+const (
+	ADJUSTMENT_FIELD_PRICE = "PRICE"
+	ADJUSTMENT_FIELD_QTY = "QTY"
+)
+
+// Filter values of a single symbol, zero value disables the limit
+type SymbolFilters struct {
+	MinPrice Decimal
+	MaxPrice Decimal
+	TickSize Decimal
+	MinQty Decimal
+	MaxQty Decimal
+	StepSize Decimal
+	MarketMinQty Decimal
+	MarketMaxQty Decimal
+	MarketStepSize Decimal
+	MinNotional Decimal
+	MinNotionalAppliesToMarket bool
+	MultiplierUp Decimal
+	MultiplierDown Decimal
+}
+
+// Single change normalizer made to order to pass some filter
+type OrderAdjustment struct {
+	Filter string
+	Field string
+	From Decimal
+	To Decimal
+}
+
+func (a OrderAdjustment) String() string {
+	return a.Filter + " " + a.Field + " " + a.From.String() + " -> " + a.To.String()
+}
+
+type NormalizedOrder struct {
+	Request *OrderRequest
+	Adjustments []OrderAdjustment
+	Check FilterCheck // filter normalized order still fails, FilterCheckOk if none
+}
+
+func (n *NormalizedOrder) adjust(filter string, field string, from Decimal, to Decimal) {
+	if from != to {
+		n.Adjustments = append(n.Adjustments, OrderAdjustment{filter, field, from, to})
+	}
+}
+
+func (n *NormalizedOrder) AdjustmentsString() string {
+	adjustments := make([]string, 0, len(n.Adjustments))
+	for _, adjustment := range n.Adjustments {
+		adjustments = append(adjustments, adjustment.String())
+	}
+
+	return strings.Join(adjustments, ", ")
+}
+
+func NewSymbolFilters(filters []Filter) *SymbolFilters {
+	symbolFilters := &SymbolFilters{MinNotionalAppliesToMarket: true}
+	for _, filter := range filters {
+		filterType, _ := filter["filterType"].(string)
+		switch filterType {
+		case FILTER_TYPE_PRICE_FILTER:
+			symbolFilters.MinPrice = ToDecimal(filter["minPrice"])
+			symbolFilters.MaxPrice = ToDecimal(filter["maxPrice"])
+			symbolFilters.TickSize = ToDecimal(filter["tickSize"])
+		case FILTER_TYPE_LOT_SIZE:
+			symbolFilters.MinQty = ToDecimal(filter["minQty"])
+			symbolFilters.MaxQty = ToDecimal(filter["maxQty"])
+			symbolFilters.StepSize = ToDecimal(filter["stepSize"])
+		case FILTER_TYPE_MARKET_LOT_SIZE:
+			symbolFilters.MarketMinQty = ToDecimal(filter["minQty"])
+			symbolFilters.MarketMaxQty = ToDecimal(filter["maxQty"])
+			symbolFilters.MarketStepSize = ToDecimal(filter["stepSize"])
+		case FILTER_TYPE_MIN_NOTIONAL:
+			symbolFilters.MinNotional = ToDecimal(filter["minNotional"])
+			// Older filters do not have the flag and always apply
+			if applyToMarket, ok := filter["applyToMarket"].(bool); ok {
+				symbolFilters.MinNotionalAppliesToMarket = applyToMarket
+			}
+		case FILTER_TYPE_PERCENT_PRICE:
+			symbolFilters.MultiplierUp = ToDecimal(filter["multiplierUp"])
+			symbolFilters.MultiplierDown = ToDecimal(filter["multiplierDown"])
+		}
+	}
+
+	return symbolFilters
+}
+
+// Market orders have to pass both LOT_SIZE and MARKET_LOT_SIZE, so the stricter bounds are taken.
+// Exchange steps are powers of ten, the larger step is then a multiple of the smaller one.
+func (f *SymbolFilters) lotSize(orderType OrderType) (Decimal, Decimal, Decimal) {
+	minQty, maxQty, stepSize := f.MinQty, f.MaxQty, f.StepSize
+	if orderType != TypeMarket {
+		return minQty, maxQty, stepSize
+	}
+
+	if f.MarketMinQty.GreaterThan(minQty) {
+		minQty = f.MarketMinQty
+	}
+	if f.MarketMaxQty.IsPositive() && (maxQty.IsZero() || f.MarketMaxQty.LessThan(maxQty)) {
+		maxQty = f.MarketMaxQty
+	}
+	if f.MarketStepSize.GreaterThan(stepSize) {
+		stepSize = f.MarketStepSize
+	}
+
+	return minQty, maxQty, stepSize
+}
+
+func (f *SymbolFilters) lotSizeFilter(orderType OrderType) string {
+	if orderType == TypeMarket {
+		return FILTER_TYPE_MARKET_LOT_SIZE
+	}
+
+	return FILTER_TYPE_LOT_SIZE
+}
+
+// Bounds of PERCENT_PRICE around refPrice, false if filter is disabled, there is no ref price or bounds do not fit
+func (f *SymbolFilters) percentPriceBounds(refPrice Decimal) (Decimal, Decimal, bool) {
+	if !f.MultiplierUp.IsPositive() || !refPrice.IsPositive() {
+		return Decimal{}, Decimal{}, false
+	}
+	lower, lowerOk := refPrice.Mul(f.MultiplierDown)
+	upper, upperOk := refPrice.Mul(f.MultiplierUp)
+
+	return lower, upper, lowerOk && upperOk
+}
+
+// Notional which does not fit is far above any MIN_NOTIONAL
+func (f *SymbolFilters) isBelowMinNotional(qty Decimal, price Decimal) bool {
+	notional, ok := qty.Mul(price)
+	return ok && notional.LessThan(f.MinNotional)
+}
+
+func (f *SymbolFilters) notionalApplies(orderType OrderType) bool {
+	return orderType != TypeMarket || f.MinNotionalAppliesToMarket
+}
+
+// Filters count steps from the min value, values below min are left for min check to reject
+func floorToGrid(value Decimal, min Decimal, step Decimal) Decimal {
+	if value.LessThan(min) {
+		return value
+	}
+
+	return min.Add(value.Sub(min).FloorToStep(step))
+}
+
+func ceilToGrid(value Decimal, min Decimal, step Decimal) Decimal {
+	if value.LessThan(min) {
+		return min
+	}
+
+	return min.Add(value.Sub(min).CeilToStep(step))
+}
+
+// refPrice stands for exchange's average price: PERCENT_PRICE is checked against it and
+// market orders are expected to fill at it
+func (f *SymbolFilters) Check(request *OrderRequest, refPrice Decimal) FilterCheck {
+	price := refPrice
+	if request.Type == TypeLimit {
+		price = request.Price
+		if !price.IsPositive() || price.LessThan(f.MinPrice) {
+			return FilterCheckMinPrice
+		}
+		if f.MaxPrice.IsPositive() && price.GreaterThan(f.MaxPrice) {
+			return FilterCheckMaxPrice
+		}
+		if !price.Sub(f.MinPrice).IsMultipleOf(f.TickSize) {
+			return FilterCheckTickSize
+		}
+		if lower, upper, ok := f.percentPriceBounds(refPrice); ok && (price.LessThan(lower) || price.GreaterThan(upper)) {
+			return FilterCheckPercentPrice
+		}
+	}
+
+	if f.notionalApplies(request.Type) && price.IsPositive() && f.isBelowMinNotional(request.Qty, price) {
+		return FilterCheckMinNotional
+	}
+
+	minQty, maxQty, stepSize := f.lotSize(request.Type)
+	if !request.Qty.IsPositive() || request.Qty.LessThan(minQty) {
+		return FilterCheckMinQty
+	}
+	if maxQty.IsPositive() && request.Qty.GreaterThan(maxQty) {
+		return FilterCheckMaxQty
+	}
+	if !request.Qty.Sub(minQty).IsMultipleOf(stepSize) {
+		return FilterCheckStepSize
+	}
+
+	return FilterCheckOk
+}
+
+// Repairs what can be repaired without making the order worse: buy price is rounded down and sell price up,
+// qty is rounded down and raised only to meet MIN_NOTIONAL when available balance of the coin order spends
+// covers it. Whatever can not be repaired is left to Check and reported in NormalizedOrder.Check.
+func (f *SymbolFilters) Normalize(request *OrderRequest, refPrice Decimal, available Decimal) *NormalizedOrder {
+	order := *request
+	normalized := &NormalizedOrder{Request: &order}
+
+	price := refPrice
+	if order.Type == TypeLimit {
+		price = f.normalizePrice(normalized, refPrice)
+	}
+
+	minQty, maxQty, stepSize := f.lotSize(order.Type)
+	lotSizeFilter := f.lotSizeFilter(order.Type)
+	qty := floorToGrid(order.Qty, minQty, stepSize)
+	normalized.adjust(lotSizeFilter, ADJUSTMENT_FIELD_QTY, order.Qty, qty)
+	if maxQty.IsPositive() && qty.GreaterThan(maxQty) {
+		capped := floorToGrid(maxQty, minQty, stepSize)
+		normalized.adjust(lotSizeFilter, ADJUSTMENT_FIELD_QTY, qty, capped)
+		qty = capped
+	}
+
+	if f.notionalApplies(order.Type) && price.IsPositive() && f.isBelowMinNotional(qty, price) {
+		qty = f.raiseToMinNotional(normalized, qty, price, available)
+	}
+	order.Qty = qty
+
+	normalized.Check = f.Check(&order, refPrice)
+
+	return normalized
+}
+
+// Qty which does not fit or costs more than available is left as is for Check to reject
+func (f *SymbolFilters) raiseToMinNotional(normalized *NormalizedOrder, qty Decimal, price Decimal, available Decimal) Decimal {
+	order := normalized.Request
+	minQty, maxQty, stepSize := f.lotSize(order.Type)
+	minNotionalQty, ok := f.MinNotional.Div(price)
+	if !ok {
+		return qty
+	}
+	needed := ceilToGrid(minNotionalQty, minQty, stepSize)
+	// Div and Mul truncate, so one more step may be needed
+	if f.isBelowMinNotional(needed, price) {
+		increment := stepSize
+		if !increment.IsPositive() {
+			increment = Decimal{1}
+		}
+		needed = needed.Add(increment)
+	}
+
+	cost := needed
+	if order.Side == SideBuy {
+		if cost, ok = needed.Mul(price); !ok {
+			return qty
+		}
+	}
+	if cost.GreaterThan(available) || (maxQty.IsPositive() && needed.GreaterThan(maxQty)) {
+		return qty
+	}
+	normalized.adjust(FILTER_TYPE_MIN_NOTIONAL, ADJUSTMENT_FIELD_QTY, qty, needed)
+
+	return needed
+}
+
+// Buying lower or selling higher can only make order fill less, never at worse price
+func (f *SymbolFilters) normalizePrice(normalized *NormalizedOrder, refPrice Decimal) Decimal {
+	order := normalized.Request
+	price := ceilToGrid(order.Price, f.MinPrice, f.TickSize)
+	if order.Side == SideBuy {
+		price = floorToGrid(order.Price, f.MinPrice, f.TickSize)
+	}
+	normalized.adjust(FILTER_TYPE_PRICE_FILTER, ADJUSTMENT_FIELD_PRICE, order.Price, price)
+
+	if lower, upper, ok := f.percentPriceBounds(refPrice); ok {
+		bounded := price
+		if order.Side == SideBuy && price.GreaterThan(upper) {
+			bounded = floorToGrid(upper, f.MinPrice, f.TickSize)
+		} else if order.Side == SideSell && price.LessThan(lower) {
+			bounded = ceilToGrid(lower, f.MinPrice, f.TickSize)
+		}
+		normalized.adjust(FILTER_TYPE_PERCENT_PRICE, ADJUSTMENT_FIELD_PRICE, price, bounded)
+		price = bounded
+	}
+	order.Price = price
+
+	return price
+}
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"testing"
+)
+
+func makeSymbolFilters() *SymbolFilters {
+	return NewSymbolFilters([]Filter{
+		{"filterType": FILTER_TYPE_PRICE_FILTER, "minPrice": "0.00000100", "maxPrice": "100000.00000000", "tickSize": "0.00000100"},
+		{"filterType": FILTER_TYPE_PERCENT_PRICE, "multiplierUp": "5", "multiplierDown": "0.2", "avgPriceMins": 5.0},
+		{"filterType": FILTER_TYPE_LOT_SIZE, "minQty": "0.00100000", "maxQty": "100000.00000000", "stepSize": "0.00100000"},
+		{"filterType": FILTER_TYPE_MIN_NOTIONAL, "minNotional": "0.00100000", "applyToMarket": true, "avgPriceMins": 5.0},
+		{"filterType": FILTER_TYPE_MARKET_LOT_SIZE, "minQty": "0.00000000", "maxQty": "500.00000000", "stepSize": "0.00000000"},
+	})
+}
+
+func TestNormalizeRoundsInSafeDirection(t *testing.T) {
+	filters := makeSymbolFilters()
+	refPrice := ToDecimal("0.034")
+
+	buy := filters.Normalize(&OrderRequest{"ETHBTC", SideBuy, TypeLimit, ToDecimal("1.23456"), ToDecimal("0.0341239")}, refPrice, ToDecimal("10"))
+	if buy.Check != FilterCheckOk {
+		t.Fatalf("expected normalized buy to pass, got %s", buy.Check)
+	}
+	if buy.Request.Price != ToDecimal("0.034123") || buy.Request.Qty != ToDecimal("1.234") {
+		t.Errorf("expected buy of 1.234 at 0.034123, got %s", buy.Request.String())
+	}
+	if len(buy.Adjustments) != 2 {
+		t.Errorf("expected price and qty adjustments, got %s", buy.AdjustmentsString())
+	}
+
+	sell := filters.Normalize(&OrderRequest{"ETHBTC", SideSell, TypeLimit, ToDecimal("1.23456"), ToDecimal("0.0341231")}, refPrice, ToDecimal("10"))
+	if sell.Request.Price != ToDecimal("0.034124") {
+		t.Errorf("expected sell price rounded up to 0.034124, got %s", sell.Request.Price.String())
+	}
+}
+
+func TestNormalizeRaisesQtyToMinNotional(t *testing.T) {
+	filters := makeSymbolFilters()
+	request := &OrderRequest{"ETHBTC", SideSell, TypeLimit, ToDecimal("0.02"), ToDecimal("0.034")}
+
+	normalized := filters.Normalize(request, ToDecimal("0.034"), ToDecimal("1"))
+	if normalized.Check != FilterCheckOk || normalized.Request.Qty != ToDecimal("0.03") {
+		t.Fatalf("expected qty raised to 0.03, got %s (%s)", normalized.Request.Qty.String(), normalized.Check)
+	}
+	last := normalized.Adjustments[len(normalized.Adjustments) - 1]
+	if last.Filter != FILTER_TYPE_MIN_NOTIONAL || last.From != ToDecimal("0.02") {
+		t.Errorf("expected min notional adjustment from 0.02, got %s", normalized.AdjustmentsString())
+	}
+
+	// Not enough balance to raise qty
+	normalized = filters.Normalize(request, ToDecimal("0.034"), ToDecimal("0.025"))
+	if normalized.Check != FilterCheckMinNotional || len(normalized.Adjustments) != 0 {
+		t.Errorf("expected min notional failure without adjustments, got %s (%s)", normalized.Check, normalized.AdjustmentsString())
+	}
+	if request.Qty != ToDecimal("0.02") {
+		t.Error("expected original request to be left as is")
+	}
+}
+
+func TestNormalizePercentPrice(t *testing.T) {
+	filters := makeSymbolFilters()
+
+	buy := filters.Normalize(&OrderRequest{"ETHBTC", SideBuy, TypeLimit, ToDecimal("1"), ToDecimal("0.2")}, ToDecimal("0.034"), ToDecimal("10"))
+	if buy.Check != FilterCheckOk || buy.Request.Price != ToDecimal("0.17") {
+		t.Errorf("expected buy price lowered to 0.17, got %s (%s)", buy.Request.Price.String(), buy.Check)
+	}
+
+	// Selling below the band can only be fixed by asking for more, which is safe
+	sell := filters.Normalize(&OrderRequest{"ETHBTC", SideSell, TypeLimit, ToDecimal("1"), ToDecimal("0.005")}, ToDecimal("0.034"), ToDecimal("10"))
+	if sell.Check != FilterCheckOk || sell.Request.Price != ToDecimal("0.0068") {
+		t.Errorf("expected sell price raised to 0.0068, got %s (%s)", sell.Request.Price.String(), sell.Check)
+	}
+
+	// Buying below the band would need a higher price
+	low := filters.Normalize(&OrderRequest{"ETHBTC", SideBuy, TypeLimit, ToDecimal("1"), ToDecimal("0.005")}, ToDecimal("0.034"), ToDecimal("10"))
+	if low.Check != FilterCheckPercentPrice {
+		t.Errorf("expected percent price failure, got %s", low.Check)
+	}
+}
+
+func TestNormalizeMarketLotSize(t *testing.T) {
+	filters := makeSymbolFilters()
+	market := filters.Normalize(&OrderRequest{"ETHBTC", SideSell, TypeMarket, ToDecimal("750.0005"), Decimal{}}, ToDecimal("0.034"), ToDecimal("1000"))
+	if market.Check != FilterCheckOk || market.Request.Qty != ToDecimal("500") {
+		t.Errorf("expected market qty capped at 500, got %s (%s)", market.Request.Qty.String(), market.Check)
+	}
+	if market.Adjustments[0].Filter != FILTER_TYPE_MARKET_LOT_SIZE {
+		t.Errorf("expected market lot size adjustment, got %s", market.AdjustmentsString())
+	}
+}
+
+func TestNormalizeOutOfRangeNotional(t *testing.T) {
+	filters := NewSymbolFilters([]Filter{
+		{"filterType": FILTER_TYPE_PERCENT_PRICE, "multiplierUp": "5", "multiplierDown": "0.2", "avgPriceMins": 5.0},
+		{"filterType": FILTER_TYPE_LOT_SIZE, "minQty": "0.00000000", "maxQty": "0.00000000", "stepSize": "1.00000000"},
+		{"filterType": FILTER_TYPE_MIN_NOTIONAL, "minNotional": "0.00100000", "applyToMarket": true, "avgPriceMins": 5.0},
+	})
+	price := ToDecimal("20000000000")
+
+	// Notional and price bounds do not fit, which must not be mistaken for a failed check
+	normalized := filters.Normalize(&OrderRequest{"XY", SideBuy, TypeLimit, ToDecimal("10"), price}, price, ToDecimal("1"))
+	if normalized.Check != FilterCheckOk {
+		t.Errorf("expected order to pass, got %s", normalized.Check)
+	}
+}
@@ -0,0 +1,131 @@
+package common
+
+// Shards tickers are split into, applying a delta copies only the shards of symbols it touches
+const TICKERS_SHARDS = 64
+
+// Tickers split into shards by symbol. Never modified once built: WithDelta returns new tickers which share
+// every shard the delta does not touch, so publishing a frame does not copy the whole map.
+// Nil ShardedTickers hold nothing.
+type ShardedTickers struct {
+	shards []TickersMap
+	size int
+}
+
+func NewShardedTickers(tickers TickersMap) *ShardedTickers {
+	t := &ShardedTickers{
+		shards: make([]TickersMap, TICKERS_SHARDS),
+		size: len(tickers),
+	}
+	for i := range t.shards {
+		t.shards[i] = make(TickersMap)
+	}
+	for symbol, ticker := range tickers {
+		t.shards[getTickersShard(symbol)][symbol] = ticker
+	}
+
+	return t
+}
+
+// FNV-1a, inlined so lookups do not allocate
+func getTickersShard(symbol string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(symbol); i++ {
+		hash ^= uint32(symbol[i])
+		hash *= 16777619
+	}
+
+	return int(hash % TICKERS_SHARDS)
+}
+
+// Nil if there is no ticker for symbol
+func (t *ShardedTickers) Get(symbol string) *Ticker {
+	if t == nil {
+		return nil
+	}
+
+	return t.shards[getTickersShard(symbol)][symbol]
+}
+
+func (t *ShardedTickers) Len() int {
+	if t == nil {
+		return 0
+	}
+
+	return t.size
+}
+
+// Same as DiffTickersMaps with t as prev
+func (t *ShardedTickers) Diff(next *TickersMap) *TickersDelta {
+	delta := &TickersDelta{
+		Updated: make(TickersMap),
+		Removed: make([]string, 0),
+	}
+
+	added := 0
+	for symbol, ticker := range *next {
+		prevTicker := t.Get(symbol)
+		if prevTicker == nil {
+			added++
+		}
+		if prevTicker == nil || *prevTicker != *ticker {
+			delta.Updated[symbol] = ticker
+		}
+	}
+
+	// t holds more than the symbols of next it shares only if some were removed, scanning t is skipped otherwise
+	if t.Len() > len(*next) - added {
+		for _, shard := range t.shards {
+			for symbol := range shard {
+				if _, ok := (*next)[symbol]; !ok {
+					delta.Removed = append(delta.Removed, symbol)
+				}
+			}
+		}
+	}
+
+	return delta
+}
+
+// Tickers with delta applied, t is left as is. Only shards delta touches are copied, tickers themselves
+// are replaced, not mutated, so previously obtained pointers stay valid.
+func (t *ShardedTickers) WithDelta(delta *TickersDelta) *ShardedTickers {
+	if t == nil {
+		t = NewShardedTickers(nil)
+	}
+	next := &ShardedTickers{
+		shards: make([]TickersMap, TICKERS_SHARDS),
+		size: t.size,
+	}
+	copy(next.shards, t.shards)
+
+	copied := make([]bool, TICKERS_SHARDS)
+	getShard := func(symbol string) TickersMap {
+		i := getTickersShard(symbol)
+		if !copied[i] {
+			shard := make(TickersMap, len(t.shards[i]) + 1)
+			for s, ticker := range t.shards[i] {
+				shard[s] = ticker
+			}
+			next.shards[i] = shard
+			copied[i] = true
+		}
+		return next.shards[i]
+	}
+
+	for symbol, ticker := range delta.Updated {
+		shard := getShard(symbol)
+		if _, ok := shard[symbol]; !ok {
+			next.size++
+		}
+		shard[symbol] = ticker
+	}
+	for _, symbol := range delta.Removed {
+		shard := getShard(symbol)
+		if _, ok := shard[symbol]; ok {
+			next.size--
+			delete(shard, symbol)
+		}
+	}
+
+	return next
+}
@@ -6,10 +6,16 @@ type TickersMap map[string]*Ticker
 
 type Ticker struct {
 	Symbol   string
-	BidPrice float64
-	BidQty   float64
-	AskPrice float64
-	AskQty   float64
+	BidPrice Decimal
+	BidQty   Decimal
+	AskPrice Decimal
+	AskQty   Decimal
+}
+
+// Difference between two consecutive tickers maps
+type TickersDelta struct {
+	Updated TickersMap
+	Removed []string
 }
 
 func (tickersMap *TickersMap) Serialize() string {
@@ -30,3 +36,60 @@ func DeserializeTickersMap(tickersMapSerialized string) *TickersMap {
 
 	return depth
 }
+
+// Returns tickers which were added or changed in next and symbols which are gone from it
+func DiffTickersMaps(prev *TickersMap, next *TickersMap) *TickersDelta {
+	delta := &TickersDelta{
+		Updated: make(TickersMap),
+		Removed: make([]string, 0),
+	}
+
+	for symbol, ticker := range *next {
+		prevTicker, ok := (*prev)[symbol]
+		if !ok || *prevTicker != *ticker {
+			delta.Updated[symbol] = ticker
+		}
+	}
+
+	for symbol := range *prev {
+		if _, ok := (*next)[symbol]; !ok {
+			delta.Removed = append(delta.Removed, symbol)
+		}
+	}
+
+	return delta
+}
+
+// Applies delta in place, tickers are replaced, not mutated, so previously obtained pointers stay valid
+func (tickersMap *TickersMap) ApplyDelta(delta *TickersDelta) {
+	for symbol, ticker := range delta.Updated {
+		(*tickersMap)[symbol] = ticker
+	}
+
+	for _, symbol := range delta.Removed {
+		delete(*tickersMap, symbol)
+	}
+}
+
+func (delta *TickersDelta) IsEmpty() bool {
+	return len(delta.Updated) == 0 && len(delta.Removed) == 0
+}
+
+func (delta *TickersDelta) Serialize() string {
+	out, err := json.Marshal(delta)
+	if err != nil {
+		panic (err)
+	}
+
+	return string(out)
+}
+
+func DeserializeTickersDelta(tickersDeltaSerialized string) (*TickersDelta, error) {
+	var delta *TickersDelta
+	err := json.Unmarshal([]byte(tickersDeltaSerialized), &delta)
+	if err != nil {
+		return nil, err
+	}
+
+	return delta, nil
+}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"sort"
+	"testing"
+)
+
+func makeTicker(symbol string, bid string, ask string) *Ticker {
+	return &Ticker{
+		Symbol: symbol,
+		BidPrice: ToDecimal(bid),
+		BidQty: ToDecimal("1"),
+		AskPrice: ToDecimal(ask),
+		AskQty: ToDecimal("1"),
+	}
+}
+
+func TestDiffTickersMaps(t *testing.T) {
+	prev := TickersMap{
+		"ETHBTC": makeTicker("ETHBTC", "0.03", "0.031"),
+		"BNBBTC": makeTicker("BNBBTC", "0.002", "0.0021"),
+		"XRPBTC": makeTicker("XRPBTC", "0.00001", "0.000011"),
+	}
+	next := TickersMap{
+		// Equal ticker in a new allocation is not a change
+		"ETHBTC": makeTicker("ETHBTC", "0.03", "0.031"),
+		"BNBBTC": makeTicker("BNBBTC", "0.002", "0.0022"),
+		"LTCBTC": makeTicker("LTCBTC", "0.005", "0.0051"),
+	}
+
+	delta := DiffTickersMaps(&prev, &next)
+	updated := make([]string, 0, len(delta.Updated))
+	for symbol := range delta.Updated {
+		updated = append(updated, symbol)
+	}
+	sort.Strings(updated)
+	if len(updated) != 2 || updated[0] != "BNBBTC" || updated[1] != "LTCBTC" {
+		t.Errorf("expected BNBBTC and LTCBTC updated, got %v", updated)
+	}
+	if len(delta.Removed) != 1 || delta.Removed[0] != "XRPBTC" {
+		t.Errorf("expected XRPBTC removed, got %v", delta.Removed)
+	}
+
+	if !DiffTickersMaps(&next, &next).IsEmpty() {
+		t.Error("expected empty delta of equal maps")
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	prev := TickersMap{
+		"ETHBTC": makeTicker("ETHBTC", "0.03", "0.031"),
+		"BNBBTC": makeTicker("BNBBTC", "0.002", "0.0021"),
+		"XRPBTC": makeTicker("XRPBTC", "0.00001", "0.000011"),
+	}
+	next := TickersMap{
+		"ETHBTC": makeTicker("ETHBTC", "0.03", "0.031"),
+		"BNBBTC": makeTicker("BNBBTC", "0.002", "0.0022"),
+		"LTCBTC": makeTicker("LTCBTC", "0.005", "0.0051"),
+	}
+	prevBNB := prev["BNBBTC"]
+
+	// Delta goes over the wire as eyes send it
+	delta, err := DeserializeTickersDelta(DiffTickersMaps(&prev, &next).Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev.ApplyDelta(delta)
+
+	if !DiffTickersMaps(&prev, &next).IsEmpty() || len(prev) != len(next) {
+		t.Errorf("expected %s, got %s", next.Serialize(), prev.Serialize())
+	}
+	if prevBNB.AskPrice.String() != "0.0021" {
+		t.Error("ticker obtained before delta was mutated")
+	}
+}
+
+func TestTickersMapSerialization(t *testing.T) {
+	tickers := TickersMap{
+		"ETHBTC": makeTicker("ETHBTC", "0.03", "0.031"),
+		"BNBBTC": makeTicker("BNBBTC", "0.00000001", "0.0021"),
+	}
+
+	deserialized := DeserializeTickersMap(tickers.Serialize())
+	if !DiffTickersMaps(&tickers, deserialized).IsEmpty() || len(*deserialized) != len(tickers) {
+		t.Errorf("expected %s, got %s", tickers.Serialize(), deserialized.Serialize())
+	}
+
+	if _, err := DeserializeTickersDelta("{"); err == nil {
+		t.Error("expected error for malformed delta")
+	}
+}
+
+func TestShardedTickersWithDelta(t *testing.T) {
+	prev := NewShardedTickers(TickersMap{
+		"ETHBTC": makeTicker("ETHBTC", "0.03", "0.031"),
+		"BNBBTC": makeTicker("BNBBTC", "0.002", "0.0021"),
+		"XRPBTC": makeTicker("XRPBTC", "0.00001", "0.000011"),
+	})
+	next := TickersMap{
+		"ETHBTC": makeTicker("ETHBTC", "0.03", "0.031"),
+		"BNBBTC": makeTicker("BNBBTC", "0.002", "0.0022"),
+		"LTCBTC": makeTicker("LTCBTC", "0.005", "0.0051"),
+	}
+
+	delta := prev.Diff(&next)
+	if len(delta.Updated) != 2 || len(delta.Removed) != 1 || delta.Removed[0] != "XRPBTC" {
+		t.Errorf("expected 2 updated and XRPBTC removed, got %s", delta.Serialize())
+	}
+	applied := prev.WithDelta(delta)
+	if !applied.Diff(&next).IsEmpty() || applied.Len() != len(next) {
+		t.Errorf("expected %s after delta", next.Serialize())
+	}
+	// Previous tickers are shared, not modified
+	if prev.Len() != 3 || prev.Get("XRPBTC") == nil || prev.Get("LTCBTC") != nil || prev.Get("BNBBTC").AskPrice.String() != "0.0021" {
+		t.Error("previous tickers were modified by delta")
+	}
+	if applied.Get("ETHBTC") != prev.Get("ETHBTC") {
+		t.Error("expected unchanged ticker to be shared")
+	}
+
+	var empty *ShardedTickers
+	if empty.Get("ETHBTC") != nil || empty.Len() != 0 || empty.Diff(&next).IsEmpty() {
+		t.Error("expected nil tickers to hold nothing")
+	}
+}
@@ -0,0 +1,11 @@
+package common
+
+// Value of qty of coin in reference asset
+type Valuation struct {
+	Coin string
+	Qty float64
+	RefAsset string
+	Mid float64 // at mid prices, without fees
+	Executable float64 // trading through Path at top of book, after fees
+	Path []string // symbols traded, empty if coin is the reference asset
+}
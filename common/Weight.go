@@ -0,0 +1,77 @@
+package common
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Used weight header without interval suffix counts weight for 1 minute
+const (
+	USED_WEIGHT_HEADER = "X-Mbx-Used-Weight"
+	DEFAULT_USED_WEIGHT_INTERVAL = "1m"
+)
+
+// interval (e.g. "1m") -> weight used by IP during current interval
+type UsedWeight map[string]int64
+
+// Builds used weight per interval from headers like X-Mbx-Used-Weight-1m
+func ParseUsedWeightHeaders(headers map[string]string) UsedWeight {
+	usedWeight := make(UsedWeight)
+	for header, value := range headers {
+		if !strings.HasPrefix(header, USED_WEIGHT_HEADER) {
+			continue
+		}
+		weight, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		interval := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(header, USED_WEIGHT_HEADER), "-"))
+		if interval == "" {
+			interval = DEFAULT_USED_WEIGHT_INTERVAL
+		}
+		usedWeight[interval] = weight
+	}
+
+	return usedWeight
+}
+
+// Converts exchange info interval to duration and to the suffix used in headers
+func ParseRateLimitInterval(interval string, intervalNum int64) (time.Duration, string) {
+	if intervalNum == 0 {
+		intervalNum = 1
+	}
+	num := strconv.FormatInt(intervalNum, 10)
+	switch interval {
+	case "SECOND":
+		return time.Duration(intervalNum) * time.Second, num + "s"
+	case "MINUTE":
+		return time.Duration(intervalNum) * time.Minute, num + "m"
+	case "HOUR":
+		return time.Duration(intervalNum) * time.Hour, num + "h"
+	case "DAY":
+		return time.Duration(intervalNum) * 24 * time.Hour, num + "d"
+	default:
+		return 0, ""
+	}
+}
+
+func (usedWeight UsedWeight) Serialize() string {
+	out, err := json.Marshal(usedWeight)
+	if err != nil {
+		panic (err)
+	}
+
+	return string(out)
+}
+
+func DeserializeUsedWeight(usedWeightSerialized string) (UsedWeight, error) {
+	var usedWeight UsedWeight
+	err := json.Unmarshal([]byte(usedWeightSerialized), &usedWeight)
+	if err != nil {
+		return nil, err
+	}
+
+	return usedWeight, nil
+}
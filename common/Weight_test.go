@@ -0,0 +1,63 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUsedWeightHeaders(t *testing.T) {
+	usedWeight := ParseUsedWeightHeaders(map[string]string{
+		"X-Mbx-Used-Weight-1M": "20",
+		"X-Mbx-Used-Weight-1h": "300",
+		"X-Mbx-Used-Weight-1s": "bad",
+		"X-Mbx-Order-Count-1m": "5",
+	})
+	if len(usedWeight) != 2 || usedWeight["1m"] != 20 || usedWeight["1h"] != 300 {
+		t.Errorf("expected 20 for 1m and 300 for 1h, got %v", usedWeight)
+	}
+
+	// Header without suffix counts 1 minute
+	usedWeight = ParseUsedWeightHeaders(map[string]string{"X-Mbx-Used-Weight": "10"})
+	if len(usedWeight) != 1 || usedWeight["1m"] != 10 {
+		t.Errorf("expected 10 for 1m, got %v", usedWeight)
+	}
+}
+
+func TestParseRateLimitInterval(t *testing.T) {
+	cases := []struct {
+		interval string
+		intervalNum int64
+		duration time.Duration
+		suffix string
+	}{
+		{"SECOND", 10, 10 * time.Second, "10s"},
+		{"MINUTE", 1, time.Minute, "1m"},
+		// Missing interval num means 1
+		{"MINUTE", 0, time.Minute, "1m"},
+		{"HOUR", 2, 2 * time.Hour, "2h"},
+		{"DAY", 1, 24 * time.Hour, "1d"},
+		{"WEEK", 1, 0, ""},
+	}
+	for _, c := range cases {
+		duration, suffix := ParseRateLimitInterval(c.interval, c.intervalNum)
+		if duration != c.duration || suffix != c.suffix {
+			t.Errorf("%s %d: expected %s %s, got %s %s", c.interval, c.intervalNum, c.duration.String(), c.suffix, duration.String(), suffix)
+		}
+	}
+}
+
+func TestUsedWeightSerialization(t *testing.T) {
+	usedWeight := UsedWeight{"1m": 10, "1h": 300}
+	deserialized, err := DeserializeUsedWeight(usedWeight.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deserialized) != 2 || deserialized["1m"] != 10 || deserialized["1h"] != 300 {
+		t.Errorf("expected %v, got %v", usedWeight, deserialized)
+	}
+
+	// Eyes which do not report weight send nothing
+	if _, err := DeserializeUsedWeight(""); err == nil {
+		t.Error("expected error for empty used weight")
+	}
+}
@@ -0,0 +1,198 @@
+package arb
+
+import (
+	"midas/common"
+	"math"
+)
+
+// Order book side a leg trades against: bids when selling base, asks when buying it.
+// Levels are ordered best price first, as exchanges return them.
+type BookLeg struct {
+	Side common.OrderSide
+	Levels common.DepthRecords
+	Fee float64
+}
+
+// Expected execution of a single leg at chosen size
+type LegFill struct {
+	QtyFrom float64
+	QtyTo float64 // after fee
+	OrderQty float64 // in base coin
+	AvgPrice float64
+	WorstPrice float64 // price of the last level touched, limit price which fills whole qty
+	Slippage float64 // relative to best level, positive means worse
+}
+
+// Trade size which maximizes absolute profit after walking every leg's book
+type DepthSizing struct {
+	QtyBefore float64
+	QtyAfter float64
+	Fills []*LegFill
+}
+
+// Part of a book level in units of the coin leg trades from
+type bookSegment struct {
+	capFrom float64
+	rate float64 // to per from, before fee
+	price float64
+}
+
+func (l *BookLeg) segments() []bookSegment {
+	segments := make([]bookSegment, 0, len(l.Levels))
+	for _, level := range l.Levels {
+		if !level.Price.IsPositive() || !level.Amount.IsPositive() {
+			continue
+		}
+		price := level.Price.Float64()
+		amount := level.Amount.Float64()
+		if l.Side == common.SideSell {
+			segments = append(segments, bookSegment{amount, price, price})
+		} else {
+			segments = append(segments, bookSegment{amount * price, 1.0 / price, price})
+		}
+	}
+
+	return segments
+}
+
+// Capacity of the whole book in units of the coin leg trades from
+func (l *BookLeg) capacity() float64 {
+	capacity := 0.0
+	for _, segment := range l.segments() {
+		capacity += segment.capFrom
+	}
+
+	return capacity
+}
+
+// Walks the book with qtyFrom, book is assumed to be deep enough
+func (l *BookLeg) fill(qtyFrom float64) *LegFill {
+	segments := l.segments()
+	left := qtyFrom
+	qtyTo := 0.0
+	baseQty := 0.0
+	worstPrice := 0.0
+	for _, segment := range segments {
+		if left <= 0 {
+			break
+		}
+		taken := math.Min(left, segment.capFrom)
+		left -= taken
+		qtyTo += taken * segment.rate
+		worstPrice = segment.price
+		if l.Side == common.SideSell {
+			baseQty += taken
+		} else {
+			baseQty += taken * segment.rate
+		}
+	}
+
+	fill := &LegFill{
+		QtyFrom: qtyFrom,
+		QtyTo: qtyTo * (1.0 - l.Fee),
+		OrderQty: baseQty,
+		WorstPrice: worstPrice,
+	}
+	if baseQty > 0 && len(segments) > 0 {
+		if l.Side == common.SideSell {
+			fill.AvgPrice = qtyTo / baseQty
+			fill.Slippage = (segments[0].price - fill.AvgPrice) / segments[0].price
+		} else {
+			fill.AvgPrice = qtyFrom / baseQty
+			fill.Slippage = (fill.AvgPrice - segments[0].price) / segments[0].price
+		}
+	}
+
+	return fill
+}
+
+// Qty leg has to take to give qtyTo after fee, inverse of fill
+func (l *BookLeg) inputFor(qtyTo float64) float64 {
+	left := qtyTo / (1.0 - l.Fee)
+	qtyFrom := 0.0
+	for _, segment := range l.segments() {
+		if left <= 0 {
+			break
+		}
+		taken := math.Min(left / segment.rate, segment.capFrom)
+		left -= taken * segment.rate
+		qtyFrom += taken
+	}
+
+	return qtyFrom
+}
+
+// Runs qtyBefore through every leg, returns nil if some book is too thin
+func fillLegs(legs []*BookLeg, qtyBefore float64) []*LegFill {
+	fills := make([]*LegFill, 0, len(legs))
+	qty := qtyBefore
+	for _, leg := range legs {
+		// Sizes derived from capacity carry rounding errors
+		if qty > leg.capacity() * (1.0 + 1e-9) {
+			return nil
+		}
+		fill := leg.fill(qty)
+		fills = append(fills, fill)
+		qty = fill.QtyTo
+	}
+
+	return fills
+}
+
+// Finds size up to maxQtyBefore which gives the most of start coin back minus what was put in.
+// Every leg's output is concave in its input, so is cycle's profit and the best size is one of
+// the points where some leg moves to the next book level. Returns nil if no size is profitable.
+func SizeByDepth(legs []*BookLeg, maxQtyBefore float64) *DepthSizing {
+	if len(legs) == 0 || maxQtyBefore <= 0 {
+		return nil
+	}
+
+	// Largest qty every book can take
+	maxQty := maxQtyBefore
+	for i := len(legs) - 1; i >= 0; i-- {
+		capacity := legs[i].capacity()
+		for j := i - 1; j >= 0; j-- {
+			capacity = legs[j].inputFor(capacity)
+		}
+		maxQty = math.Min(maxQty, capacity)
+	}
+
+	// Level boundaries of every leg expressed in start coin
+	candidates := []float64{maxQty}
+	for i, leg := range legs {
+		boundary := 0.0
+		for _, segment := range leg.segments() {
+			boundary += segment.capFrom
+			qty := boundary
+			for j := i - 1; j >= 0; j-- {
+				qty = legs[j].inputFor(qty)
+			}
+			if qty < maxQty {
+				candidates = append(candidates, qty)
+			}
+		}
+	}
+
+	var best *DepthSizing
+	bestProfit := 0.0
+	for _, qtyBefore := range candidates {
+		if qtyBefore <= 0 {
+			continue
+		}
+		fills := fillLegs(legs, qtyBefore)
+		if fills == nil {
+			continue
+		}
+		qtyAfter := fills[len(fills) - 1].QtyTo
+		if profit := qtyAfter - qtyBefore; profit > bestProfit {
+			bestProfit = profit
+			best = &DepthSizing{
+				QtyBefore: qtyBefore,
+				QtyAfter: qtyAfter,
+				Fills: fills,
+			}
+		}
+	}
+
+	return best
+}
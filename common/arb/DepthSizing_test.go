@@ -0,0 +1,84 @@
+package arb
+
+import (
+	"midas/common"
+	"math"
+	"testing"
+)
+
+func almostEqual(a float64, b float64) bool {
+	return math.Abs(a - b) < 1e-9
+}
+
+// Price and amount pairs
+func levels(values ...string) common.DepthRecords {
+	records := make(common.DepthRecords, 0, len(values) / 2)
+	for i := 0; i + 1 < len(values); i += 2 {
+		records = append(records, common.DepthRecord{common.ToDecimal(values[i]), common.ToDecimal(values[i + 1])})
+	}
+
+	return records
+}
+
+// Buys X for USDT and sells it back, second ask level and second bid level eat the profit
+func makeRoundTrip(fee float64) []*BookLeg {
+	return []*BookLeg{
+		{
+			Side: common.SideBuy,
+			Levels: levels("1.0", "100", "1.2", "100"),
+			Fee: fee,
+		},
+		{
+			Side: common.SideSell,
+			Levels: levels("1.1", "150", "0.9", "100"),
+			Fee: fee,
+		},
+	}
+}
+
+func TestSizeByDepthStopsAtUnprofitableLevel(t *testing.T) {
+	sizing := SizeByDepth(makeRoundTrip(0), 1000)
+	if sizing == nil {
+		t.Fatal("expected profitable size")
+	}
+	if !almostEqual(sizing.QtyBefore, 100) || !almostEqual(sizing.QtyAfter, 110) {
+		t.Errorf("expected 100 -> 110, got %f -> %f", sizing.QtyBefore, sizing.QtyAfter)
+	}
+	if !almostEqual(sizing.Fills[0].AvgPrice, 1.0) || !almostEqual(sizing.Fills[0].Slippage, 0) {
+		t.Errorf("expected buy at 1.0 without slippage, got %f %f", sizing.Fills[0].AvgPrice, sizing.Fills[0].Slippage)
+	}
+	if !almostEqual(sizing.Fills[1].OrderQty, 100) || !almostEqual(sizing.Fills[1].AvgPrice, 1.1) {
+		t.Errorf("expected sell of 100 at 1.1, got %f at %f", sizing.Fills[1].OrderQty, sizing.Fills[1].AvgPrice)
+	}
+}
+
+func TestSizeByDepthRespectsMaxQty(t *testing.T) {
+	sizing := SizeByDepth(makeRoundTrip(0), 50)
+	if sizing == nil || !almostEqual(sizing.QtyBefore, 50) {
+		t.Fatalf("expected size capped at 50, got %+v", sizing)
+	}
+}
+
+func TestSizeByDepthSlippage(t *testing.T) {
+	legs := []*BookLeg{
+		{Side: common.SideBuy, Levels: levels("1.0", "100", "1.05", "100")},
+		{Side: common.SideSell, Levels: levels("1.2", "1000")},
+	}
+	sizing := SizeByDepth(legs, 1000)
+	if sizing == nil || !almostEqual(sizing.QtyBefore, 205) {
+		t.Fatalf("expected both ask levels to be taken, got %+v", sizing)
+	}
+	fill := sizing.Fills[0]
+	if !almostEqual(fill.AvgPrice, 205.0 / 200.0) || !almostEqual(fill.WorstPrice, 1.05) {
+		t.Errorf("unexpected buy fill %+v", fill)
+	}
+	if !almostEqual(fill.Slippage, 0.025) {
+		t.Errorf("expected 2.5%% slippage, got %f", fill.Slippage)
+	}
+}
+
+func TestSizeByDepthNoProfit(t *testing.T) {
+	if sizing := SizeByDepth(makeRoundTrip(0.1), 1000); sizing != nil {
+		t.Errorf("expected no profitable size with high fee, got %+v", sizing)
+	}
+}
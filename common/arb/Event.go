@@ -0,0 +1,34 @@
+package arb
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Lifecycle stage of an arb state
+type EventType string
+
+var (
+	EventDetected  = EventType("DETECTED")
+	EventUpdated   = EventType("UPDATED")
+	EventScheduled = EventType("SCHEDULED")
+	EventDropped   = EventType("DROPPED")
+	EventExpired   = EventType("EXPIRED")
+	EventExecuted  = EventType("EXECUTED")
+)
+
+type Event struct {
+	Type EventType
+	State *State
+	Reason string // why state was dropped or expired, how execution ended
+	Ts time.Time
+}
+
+func (e *Event) Serialize() string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic("Error marshaling arb event: " + err.Error())
+	}
+
+	return string(b)
+}
@@ -0,0 +1,240 @@
+package arb
+
+import (
+	"midas/common"
+	"time"
+)
+
+// How legs of an arb are sent
+type ExecutionStrategy string
+
+// Stage of a single order of an execution, either leg or recovery order
+type LegStatus string
+
+// Stage of an arb execution as a whole
+type ExecutionStatus string
+
+var (
+	// Next leg is sent once previous one is done and is sized by what previous leg received
+	StrategySequential = ExecutionStrategy("SEQUENTIAL")
+	// All legs are sent at once from balances already held
+	StrategyParallel = ExecutionStrategy("PARALLEL")
+
+	LegPending         = LegStatus("PENDING")
+	LegSent            = LegStatus("SENT")
+	LegFilled          = LegStatus("FILLED")
+	LegPartiallyFilled = LegStatus("PARTIALLY_FILLED")
+	LegUnfilled        = LegStatus("UNFILLED") // IOC order expired without fills
+	LegFailed          = LegStatus("FAILED") // order was rejected or could not be sent
+	LegSkipped         = LegStatus("SKIPPED") // not sent, previous leg did not fill or order does not pass filters
+	LegDust            = LegStatus("DUST") // recovery not sent, leftover is below min notional and can not be traded
+
+	ExecutionPending    = ExecutionStatus("PENDING")
+	ExecutionRunning    = ExecutionStatus("RUNNING")
+	ExecutionCompleted  = ExecutionStatus("COMPLETED") // every leg filled
+	ExecutionRecovering = ExecutionStatus("RECOVERING") // unwind or completion orders are in flight
+	ExecutionRecovered  = ExecutionStatus("RECOVERED") // leftovers are traded back to start coin or below min notional
+	ExecutionFailed     = ExecutionStatus("FAILED") // leftovers could not be traded back, needs attention
+)
+
+// Order sent on behalf of an execution. Recovery orders have no Leg.
+type LegExecution struct {
+	Leg *Leg
+	From string
+	To string
+	Request *common.OrderRequest // as sent, after normalization
+	ClientOrderId string
+	Status LegStatus
+	Spent common.Decimal // of From coin
+	Received common.Decimal // of To coin, net of commission paid in it
+	Error string
+}
+
+type Execution struct {
+	State *State
+	Strategy ExecutionStrategy
+	Status ExecutionStatus
+	Legs []*LegExecution // in state's leg order
+	Recoveries []*LegExecution
+	StartTs time.Time
+	EndTs time.Time
+}
+
+// Requests are state's leg orders, normalized and in leg order
+func NewExecution(state *State, strategy ExecutionStrategy, requests []*common.OrderRequest) *Execution {
+	legs := make([]*LegExecution, 0, len(state.Legs))
+	for i, leg := range state.Legs {
+		legs = append(legs, &LegExecution{
+			Leg: leg,
+			From: leg.From.CoinSymbol,
+			To: leg.To.CoinSymbol,
+			Request: requests[i],
+			Status: LegPending,
+		})
+	}
+
+	return &Execution{
+		State: state,
+		Strategy: strategy,
+		Status: ExecutionPending,
+		Legs: legs,
+		StartTs: time.Now(),
+	}
+}
+
+func NewRecoveryExecution(from string, to string, request *common.OrderRequest) *LegExecution {
+	return &LegExecution{
+		From: from,
+		To: to,
+		Request: request,
+		Status: LegPending,
+	}
+}
+
+// Takes filled amounts and status from exchange response. Commission paid in a third coin (BNB)
+// is the fee budget, not arb inventory, and is left out.
+func (l *LegExecution) Apply(res *common.ExecutedOrderFullResponse) {
+	if l.Request.Side == common.SideSell {
+		l.Spent, l.Received = res.ExecutedQty, res.CumulativeQuoteQty
+	} else {
+		l.Spent, l.Received = res.CumulativeQuoteQty, res.ExecutedQty
+	}
+	for _, fill := range res.Fills {
+		switch fill.CommissionAsset {
+		case l.To:
+			l.Received = l.Received.Sub(fill.Commission)
+		case l.From:
+			l.Spent = l.Spent.Add(fill.Commission)
+		}
+	}
+
+	switch {
+	case res.Status == common.StatusFilled:
+		l.Status = LegFilled
+	case res.ExecutedQty.IsPositive():
+		l.Status = LegPartiallyFilled
+	default:
+		l.Status = LegUnfilled
+	}
+}
+
+func (l *LegExecution) Fail(err error) {
+	l.Status = LegFailed
+	l.Error = err.Error()
+}
+
+func (l *LegExecution) Skip(reason string) {
+	l.Status = LegSkipped
+	l.Error = reason
+}
+
+func (l *LegExecution) SkipAsDust(reason string) {
+	l.Status = LegDust
+	l.Error = reason
+}
+
+// Coin execution starts and has to end with
+func (e *Execution) StartCoin() string {
+	return e.Legs[0].From
+}
+
+func (e *Execution) AllLegsFilled() bool {
+	for _, leg := range e.Legs {
+		if leg.Status != LegFilled {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Net amount of every coin but the start coin moved by legs and recoveries. Positive amount is inventory
+// left over, e.g. received by a leg whose next leg did not fill, negative is inventory spent and not replaced.
+// Parallel legs are sized from balances held beforehand, so a leg may receive a bit more or less than the
+// next one spends even when both fill as requested. That residue is by design and is not a leftover.
+func (e *Execution) Leftovers() map[string]common.Decimal {
+	leftovers := make(map[string]common.Decimal)
+	for _, orders := range [][]*LegExecution{e.Legs, e.Recoveries} {
+		for _, order := range orders {
+			leftovers[order.From] = leftovers[order.From].Sub(order.Spent)
+			leftovers[order.To] = leftovers[order.To].Add(order.Received)
+		}
+	}
+	if e.Strategy == StrategyParallel {
+		for coin, residue := range e.getPlannedResidue() {
+			leftovers[coin] = leftovers[coin].Sub(residue)
+		}
+	}
+	delete(leftovers, e.StartCoin())
+	for coin, qty := range leftovers {
+		if qty.IsZero() {
+			delete(leftovers, coin)
+		}
+	}
+
+	return leftovers
+}
+
+// Net amount of coins legs move at their prices, commission aside, for coins whose legs all filled.
+// Coin of a leg which did not fill has to get back to where it was, so it has no residue.
+func (e *Execution) getPlannedResidue() map[string]common.Decimal {
+	residue := make(map[string]common.Decimal)
+	unfilled := make(map[string]bool)
+	for _, leg := range e.Legs {
+		notional, ok := leg.Request.Qty.Mul(leg.Request.Price)
+		if leg.Status != LegFilled || !ok {
+			unfilled[leg.From] = true
+			unfilled[leg.To] = true
+			continue
+		}
+		spent, received := leg.Request.Qty, notional
+		if leg.Request.Side == common.SideBuy {
+			spent, received = notional, leg.Request.Qty
+		}
+		residue[leg.From] = residue[leg.From].Sub(spent)
+		residue[leg.To] = residue[leg.To].Add(received)
+	}
+	for coin := range unfilled {
+		delete(residue, coin)
+	}
+
+	return residue
+}
+
+// Settles final status once all legs and recoveries are done
+func (e *Execution) Finish(ts time.Time) {
+	e.EndTs = ts
+	if len(e.Recoveries) == 0 && e.AllLegsFilled() {
+		e.Status = ExecutionCompleted
+		return
+	}
+
+	// Recoveries skipped for other reasons than dust, e.g. MAX_QTY, leave inventory behind
+	for _, recovery := range e.Recoveries {
+		if recovery.Status != LegFilled && recovery.Status != LegDust {
+			e.Status = ExecutionFailed
+			return
+		}
+	}
+	// Leftovers nobody tried to recover
+	if len(e.Recoveries) == 0 && len(e.Leftovers()) > 0 {
+		e.Status = ExecutionFailed
+		return
+	}
+	e.Status = ExecutionRecovered
+}
+
+// Request of a leg sized to spend input of its From coin, for sequential execution.
+// Buy qty is in base coin, so input of quote coin is converted at order price. False if qty does not fit into Decimal.
+func ResizeOrder(request *common.OrderRequest, input common.Decimal) (*common.OrderRequest, bool) {
+	resized := *request
+	resized.Qty = input
+	if request.Side == common.SideBuy {
+		var ok bool
+		if resized.Qty, ok = input.Div(request.Price); !ok {
+			return &resized, false
+		}
+	}
+
+	return &resized, true
+}
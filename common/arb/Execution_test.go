@@ -0,0 +1,190 @@
+package arb
+
+import (
+	"errors"
+	"midas/common"
+	"testing"
+	"time"
+)
+
+func makeTestExecution(strategy ExecutionStrategy) *Execution {
+	btc := common.Coin{CoinSymbol: "BTC"}
+	eth := common.Coin{CoinSymbol: "ETH"}
+	bnb := common.Coin{CoinSymbol: "BNB"}
+	orders := []*common.OrderRequest{
+		{"ETHBTC", common.SideBuy, common.TypeLimit, common.ToDecimal("1"), common.ToDecimal("0.03")},
+		{"BNBETH", common.SideBuy, common.TypeLimit, common.ToDecimal("10"), common.ToDecimal("0.1")},
+		{"BNBBTC", common.SideSell, common.TypeLimit, common.ToDecimal("10"), common.ToDecimal("0.0031")},
+	}
+	state := &State{
+		Id: "ETHBTC_BNBETH_BNBBTC",
+		Legs: []*Leg{
+			{From: btc, To: eth, Order: orders[0]},
+			{From: eth, To: bnb, Order: orders[1]},
+			{From: bnb, To: btc, Order: orders[2]},
+		},
+	}
+
+	return NewExecution(state, strategy, orders)
+}
+
+func TestLegExecutionApply(t *testing.T) {
+	execution := makeTestExecution(StrategySequential)
+	leg := execution.Legs[0]
+	leg.Apply(&common.ExecutedOrderFullResponse{
+		Status: common.StatusExpired,
+		ExecutedQty: common.ToDecimal("0.6"),
+		CumulativeQuoteQty: common.ToDecimal("0.018"),
+		Fills: []*common.Fill{
+			{common.ToDecimal("0.03"), common.ToDecimal("0.6"), common.ToDecimal("0.0006"), "ETH"},
+		},
+	})
+
+	if leg.Status != LegPartiallyFilled {
+		t.Errorf("expected partially filled leg, got %s", leg.Status)
+	}
+	if leg.Spent != common.ToDecimal("0.018") || leg.Received != common.ToDecimal("0.5994") {
+		t.Errorf("expected 0.018 BTC spent for 0.5994 ETH, got %s for %s", leg.Spent.String(), leg.Received.String())
+	}
+
+	// Commission in BNB is not arb inventory
+	sell := execution.Legs[2]
+	sell.Apply(&common.ExecutedOrderFullResponse{
+		Status: common.StatusFilled,
+		ExecutedQty: common.ToDecimal("10"),
+		CumulativeQuoteQty: common.ToDecimal("0.031"),
+		Fills: []*common.Fill{
+			{common.ToDecimal("0.0031"), common.ToDecimal("10"), common.ToDecimal("0.0075"), "BNB"},
+		},
+	})
+	if sell.Status != LegFilled || sell.Spent != common.ToDecimal("10.0075") || sell.Received != common.ToDecimal("0.031") {
+		t.Errorf("unexpected filled sell %s: spent %s, received %s", sell.Status, sell.Spent.String(), sell.Received.String())
+	}
+}
+
+func TestExecutionLeftoversAfterPartialFill(t *testing.T) {
+	execution := makeTestExecution(StrategySequential)
+	execution.Legs[0].Apply(&common.ExecutedOrderFullResponse{Status: common.StatusFilled, ExecutedQty: common.ToDecimal("1"), CumulativeQuoteQty: common.ToDecimal("0.03")})
+	execution.Legs[1].Apply(&common.ExecutedOrderFullResponse{Status: common.StatusExpired, ExecutedQty: common.ToDecimal("4"), CumulativeQuoteQty: common.ToDecimal("0.4")})
+	execution.Legs[2].Apply(&common.ExecutedOrderFullResponse{Status: common.StatusFilled, ExecutedQty: common.ToDecimal("4"), CumulativeQuoteQty: common.ToDecimal("0.0124")})
+
+	leftovers := execution.Leftovers()
+	if _, ok := leftovers["BTC"]; ok {
+		t.Error("expected start coin to be left out")
+	}
+	if len(leftovers) != 1 || leftovers["ETH"] != common.ToDecimal("0.6") {
+		t.Errorf("expected only 0.6 ETH left over, got %v", leftovers)
+	}
+
+	recovery := NewRecoveryExecution("ETH", "BTC", &common.OrderRequest{"ETHBTC", common.SideSell, common.TypeMarket, common.ToDecimal("0.6"), common.Decimal{}})
+	recovery.Apply(&common.ExecutedOrderFullResponse{Status: common.StatusFilled, ExecutedQty: common.ToDecimal("0.6"), CumulativeQuoteQty: common.ToDecimal("0.0179")})
+	execution.Recoveries = append(execution.Recoveries, recovery)
+	if len(execution.Leftovers()) != 0 {
+		t.Errorf("expected recovery to clear ETH, got %v", execution.Leftovers())
+	}
+
+	execution.Finish(time.Now())
+	if execution.Status != ExecutionRecovered {
+		t.Errorf("expected recovered execution, got %s", execution.Status)
+	}
+}
+
+func TestParallelResidueIsNotLeftover(t *testing.T) {
+	execution := makeTestExecution(StrategyParallel)
+	// Legs are sized independently: 1 ETH is bought, 0.999 ETH buy 9.99 BNB and 10 BNB are sold
+	execution.Legs[1].Request.Qty = common.ToDecimal("9.99")
+	for _, leg := range execution.Legs {
+		quoteQty, _ := leg.Request.Qty.Mul(leg.Request.Price)
+		leg.Apply(&common.ExecutedOrderFullResponse{Status: common.StatusFilled, ExecutedQty: leg.Request.Qty, CumulativeQuoteQty: quoteQty})
+	}
+	if leftovers := execution.Leftovers(); len(leftovers) != 0 {
+		t.Errorf("expected planned residue not to be left over, got %v", leftovers)
+	}
+
+	// Residue of a coin whose legs did not all fill is a leftover
+	partial := makeTestExecution(StrategyParallel)
+	partial.Legs[0].Apply(&common.ExecutedOrderFullResponse{Status: common.StatusFilled, ExecutedQty: common.ToDecimal("1"), CumulativeQuoteQty: common.ToDecimal("0.03")})
+	partial.Legs[1].Apply(&common.ExecutedOrderFullResponse{Status: common.StatusExpired})
+	partial.Legs[2].Apply(&common.ExecutedOrderFullResponse{Status: common.StatusFilled, ExecutedQty: common.ToDecimal("10"), CumulativeQuoteQty: common.ToDecimal("0.031")})
+	leftovers := partial.Leftovers()
+	if len(leftovers) != 2 || leftovers["ETH"] != common.ToDecimal("1") || leftovers["BNB"] != common.ToDecimal("-10") {
+		t.Errorf("expected 1 ETH and -10 BNB left over, got %v", leftovers)
+	}
+}
+
+func TestExecutionFinish(t *testing.T) {
+	completed := makeTestExecution(StrategyParallel)
+	for _, leg := range completed.Legs {
+		leg.Apply(&common.ExecutedOrderFullResponse{Status: common.StatusFilled, ExecutedQty: common.ToDecimal("1"), CumulativeQuoteQty: common.ToDecimal("1")})
+	}
+	completed.Finish(time.Now())
+	if completed.Status != ExecutionCompleted {
+		t.Errorf("expected completed execution, got %s", completed.Status)
+	}
+
+	// Nothing filled, nothing to recover
+	unfilled := makeTestExecution(StrategySequential)
+	unfilled.Legs[0].Apply(&common.ExecutedOrderFullResponse{Status: common.StatusExpired})
+	unfilled.Finish(time.Now())
+	if unfilled.Status != ExecutionRecovered {
+		t.Errorf("expected unfilled execution to be recovered, got %s", unfilled.Status)
+	}
+
+	// Leftovers without recoveries
+	unrecovered := makeTestExecution(StrategySequential)
+	unrecovered.Legs[0].Apply(&common.ExecutedOrderFullResponse{Status: common.StatusFilled, ExecutedQty: common.ToDecimal("1"), CumulativeQuoteQty: common.ToDecimal("0.03")})
+	unrecovered.Finish(time.Now())
+	if unrecovered.Status != ExecutionFailed {
+		t.Errorf("expected execution with leftovers to fail, got %s", unrecovered.Status)
+	}
+
+	// Leftover below min notional can not be traded back
+	dust := makeTestExecution(StrategySequential)
+	dust.Legs[0].Apply(&common.ExecutedOrderFullResponse{Status: common.StatusFilled, ExecutedQty: common.ToDecimal("0.001"), CumulativeQuoteQty: common.ToDecimal("0.00003")})
+	dustRecovery := NewRecoveryExecution("ETH", "BTC", nil)
+	dustRecovery.SkipAsDust("MIN_NOTIONAL")
+	dust.Recoveries = append(dust.Recoveries, dustRecovery)
+	dust.Finish(time.Now())
+	if dust.Status != ExecutionRecovered {
+		t.Errorf("expected execution with dust to be recovered, got %s", dust.Status)
+	}
+
+	// Leftover above max qty is stranded
+	stranded := makeTestExecution(StrategySequential)
+	stranded.Legs[0].Apply(&common.ExecutedOrderFullResponse{Status: common.StatusFilled, ExecutedQty: common.ToDecimal("1"), CumulativeQuoteQty: common.ToDecimal("0.03")})
+	skippedRecovery := NewRecoveryExecution("ETH", "BTC", nil)
+	skippedRecovery.Skip("MAX_QTY")
+	stranded.Recoveries = append(stranded.Recoveries, skippedRecovery)
+	stranded.Finish(time.Now())
+	if stranded.Status != ExecutionFailed {
+		t.Errorf("expected execution with skipped recovery to fail, got %s", stranded.Status)
+	}
+
+	failed := makeTestExecution(StrategyParallel)
+	failed.Legs[0].Fail(errors.New("rejected"))
+	recovery := NewRecoveryExecution("ETH", "BTC", failed.Legs[1].Request)
+	recovery.Fail(errors.New("rejected"))
+	failed.Recoveries = append(failed.Recoveries, recovery)
+	failed.Finish(time.Now())
+	if failed.Status != ExecutionFailed {
+		t.Errorf("expected failed execution, got %s", failed.Status)
+	}
+}
+
+func TestResizeOrder(t *testing.T) {
+	buy := &common.OrderRequest{"BNBETH", common.SideBuy, common.TypeLimit, common.ToDecimal("10"), common.ToDecimal("0.1")}
+	if resized, ok := ResizeOrder(buy, common.ToDecimal("0.5")); !ok || resized.Qty != common.ToDecimal("5") || buy.Qty != common.ToDecimal("10") {
+		t.Errorf("expected buy resized to 5 and original left as is, got %s", resized.String())
+	}
+
+	sell := &common.OrderRequest{"BNBBTC", common.SideSell, common.TypeLimit, common.ToDecimal("10"), common.ToDecimal("0.0031")}
+	if resized, ok := ResizeOrder(sell, common.ToDecimal("4.5")); !ok || resized.Qty != common.ToDecimal("4.5") {
+		t.Errorf("expected sell resized to 4.5, got %s", resized.String())
+	}
+
+	// Input of a quote coin converted at a tiny price does not fit
+	cheap := &common.OrderRequest{"XBTC", common.SideBuy, common.TypeLimit, common.ToDecimal("10"), common.ToDecimal("0.00000001")}
+	if resized, ok := ResizeOrder(cheap, common.ToDecimal("1000")); ok {
+		t.Errorf("expected resized qty out of range, got %s", resized.String())
+	}
+}
@@ -0,0 +1,57 @@
+package arb
+
+import (
+	"sync"
+	"time"
+)
+
+// Frames older than that are forgotten, confirmation rules can't ask for more frames
+const FRAME_HISTORY_SIZE = 64
+
+// Tickers frame arb state was found in
+type FrameSighting struct {
+	Ts time.Time
+	EyeId int
+}
+
+// Latest FRAME_HISTORY_SIZE frames arb state was found in
+type FrameHistory struct {
+	sightings []FrameSighting
+	mux sync.Mutex
+}
+
+func NewFrameHistory() *FrameHistory {
+	return &FrameHistory{
+		sightings: make([]FrameSighting, 0),
+	}
+}
+
+// Frame is recorded once, however many times state is evaluated with it
+func (h *FrameHistory) Add(ts time.Time, eyeId int) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if n := len(h.sightings); n > 0 && h.sightings[n - 1].Ts.Equal(ts) && h.sightings[n - 1].EyeId == eyeId {
+		return
+	}
+	h.sightings = append(h.sightings, FrameSighting{ts, eyeId})
+	if len(h.sightings) > FRAME_HISTORY_SIZE {
+		h.sightings = h.sightings[len(h.sightings) - FRAME_HISTORY_SIZE:]
+	}
+}
+
+// Counts frames arb held in, i.e. frames which arrived before arb state was last confirmed,
+// and distinct eyes they came from
+func (h *FrameHistory) Confirmations(lastUpdateTs time.Time) (int, int) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	frames := 0
+	eyes := make(map[int]bool)
+	for _, sighting := range h.sightings {
+		if sighting.Ts.Before(lastUpdateTs) {
+			frames++
+			eyes[sighting.EyeId] = true
+		}
+	}
+
+	return frames, len(eyes)
+}
@@ -0,0 +1,47 @@
+package arb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrameHistoryIsBounded(t *testing.T) {
+	history := NewFrameHistory()
+	start := time.Now()
+	for i := 0; i < 3 * FRAME_HISTORY_SIZE; i++ {
+		history.Add(start.Add(time.Duration(i) * time.Millisecond), i % 2)
+	}
+
+	frames, eyes := history.Confirmations(start.Add(time.Hour))
+	if frames != FRAME_HISTORY_SIZE || eyes != 2 {
+		t.Errorf("expected %d frames from 2 eyes, got %d from %d", FRAME_HISTORY_SIZE, frames, eyes)
+	}
+}
+
+func TestFrameHistoryCountsConfirmedFrames(t *testing.T) {
+	history := NewFrameHistory()
+	start := time.Now()
+	history.Add(start, 1)
+	history.Add(start.Add(time.Millisecond), 1)
+	history.Add(start.Add(2 * time.Millisecond), 2)
+
+	// Last frame has not been evaluated by detector yet
+	frames, eyes := history.Confirmations(start.Add(2 * time.Millisecond))
+	if frames != 2 || eyes != 1 {
+		t.Errorf("expected 2 frames from 1 eye, got %d from %d", frames, eyes)
+	}
+}
+
+func TestFrameHistoryRecordsFrameOnce(t *testing.T) {
+	history := NewFrameHistory()
+	start := time.Now()
+	// State evaluated twice with the same frame, e.g. by two detector passes reading the same snapshot
+	history.Add(start, 1)
+	history.Add(start, 1)
+	history.Add(start.Add(time.Millisecond), 2)
+
+	frames, eyes := history.Confirmations(start.Add(time.Hour))
+	if frames != 2 || eyes != 2 {
+		t.Errorf("expected 2 frames from 2 eyes, got %d from %d", frames, eyes)
+	}
+}
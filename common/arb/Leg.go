@@ -0,0 +1,73 @@
+package arb
+
+import (
+	"midas/common"
+	"strings"
+)
+
+// Single trade of an arb, From coin is traded for To coin on Pair
+type Leg struct {
+	Pair *common.CoinPair
+	From common.Coin
+	To common.Coin
+	Order *common.OrderRequest
+	AvgPrice float64 // expected, equals order price unless sized by depth
+	Slippage float64 // relative to best price
+}
+
+// Cycle of coins an arb goes through, first coin is the one profit is measured in
+type Cycle struct {
+	Coins []common.Coin
+	Key string
+}
+
+func NewCycle(coins []common.Coin) *Cycle {
+	symbols := make([]string, 0, len(coins))
+	for _, coin := range coins {
+		symbols = append(symbols, coin.CoinSymbol)
+	}
+
+	return &Cycle{
+		Coins: coins,
+		Key: strings.Join(symbols, "->"),
+	}
+}
+
+// Request with qty multiplied by scale, for resizing all legs of an arb at once so they stay balanced.
+// False if scaled qty does not fit into Decimal.
+func ScaleOrder(request *common.OrderRequest, scale float64) (*common.OrderRequest, bool) {
+	scaled := *request
+	var ok bool
+	scaled.Qty, ok = common.DecimalFromFloat(request.Qty.Float64() * scale)
+
+	return &scaled, ok
+}
+
+// Amount of start coin first leg spends and amount of it last leg returns if orders fill at legs' expected prices.
+// Orders may be rounded so that legs do not pass amounts on exactly, whatever is left in middle coins is not counted.
+func GetOrdersQtys(legs []*Leg, orders []*common.OrderRequest, fee float64) (float64, float64) {
+	if len(legs) == 0 {
+		return 0, 0
+	}
+
+	first, last := orders[0], orders[len(orders) - 1]
+	qtyBefore := first.Qty.Float64()
+	if first.Side == common.SideBuy {
+		qtyBefore *= legs[0].getExpectedPrice(first)
+	}
+	qtyAfter := last.Qty.Float64()
+	if last.Side == common.SideSell {
+		qtyAfter *= legs[len(legs) - 1].getExpectedPrice(last)
+	}
+
+	return qtyBefore, qtyAfter * (1.0 - fee)
+}
+
+// Market orders have no price, they are expected to fill at leg's average price as well
+func (l *Leg) getExpectedPrice(request *common.OrderRequest) float64 {
+	if l.AvgPrice > 0 {
+		return l.AvgPrice
+	}
+
+	return request.Price.Float64()
+}
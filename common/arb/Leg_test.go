@@ -0,0 +1,48 @@
+package arb
+
+import (
+	"midas/common"
+	"testing"
+)
+
+// BTC -> ETH -> BNB -> BTC
+func makeTestLegs() ([]*Leg, []*common.OrderRequest) {
+	btc := common.Coin{CoinSymbol: "BTC"}
+	eth := common.Coin{CoinSymbol: "ETH"}
+	bnb := common.Coin{CoinSymbol: "BNB"}
+	orders := []*common.OrderRequest{
+		{"ETHBTC", common.SideBuy, common.TypeLimit, common.ToDecimal("1"), common.ToDecimal("0.03")},
+		{"BNBETH", common.SideBuy, common.TypeLimit, common.ToDecimal("10"), common.ToDecimal("0.1")},
+		{"BNBBTC", common.SideSell, common.TypeLimit, common.ToDecimal("10"), common.ToDecimal("0.0031")},
+	}
+	legs := []*Leg{
+		{From: btc, To: eth, Order: orders[0]},
+		{From: eth, To: bnb, Order: orders[1]},
+		{From: bnb, To: btc, Order: orders[2]},
+	}
+
+	return legs, orders
+}
+
+func TestScaleOrder(t *testing.T) {
+	order := &common.OrderRequest{"BNBETH", common.SideBuy, common.TypeLimit, common.ToDecimal("10.05"), common.ToDecimal("0.1")}
+	if scaled, ok := ScaleOrder(order, 2.0 / 2.01); !ok || scaled.Qty != common.ToDecimal("10") || order.Qty != common.ToDecimal("10.05") {
+		t.Errorf("expected order scaled to 10 and original left as is, got %s", scaled.String())
+	}
+}
+
+func TestGetOrdersQtys(t *testing.T) {
+	legs, orders := makeTestLegs()
+
+	// 1 ETH for 0.03 BTC, 10 BNB sold for 0.031 BTC
+	qtyBefore, qtyAfter := GetOrdersQtys(legs, orders, 0.001)
+	if !almostEqual(qtyBefore, 0.03) || !almostEqual(qtyAfter, 0.031 * 0.999) {
+		t.Errorf("expected 0.03 BTC for 0.030969 BTC, got %f for %f", qtyBefore, qtyAfter)
+	}
+
+	// Depth sized legs fill at average price rather than limit price
+	legs[2].AvgPrice = 0.0032
+	if _, qtyAfter := GetOrdersQtys(legs, orders, 0); !almostEqual(qtyAfter, 0.032) {
+		t.Errorf("expected 0.032 BTC at average price, got %f", qtyAfter)
+	}
+}
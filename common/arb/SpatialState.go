@@ -0,0 +1,55 @@
+package arb
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Same pair bought on one exchange and sold on another
+type SpatialState struct {
+	Id string
+	Key string
+	Symbol string
+	BuyExchange string
+	SellExchange string
+	BuyPrice float64 // ask on BuyExchange
+	SellPrice float64 // bid on SellExchange
+	BuyFee float64
+	SellFee float64
+	Qty float64 // in base coin, limited by both books and inventory on both exchanges
+	ProfitRelative float64 // after fees on both exchanges
+	ProfitInQuote float64 // for Qty
+	InventoryLimited bool // Qty is limited by inventory rather than books
+	StartTs time.Time
+	LastUpdateTs time.Time // use Touch and GetLastUpdateTs
+	mux sync.RWMutex
+}
+
+func (s *SpatialState) Touch(ts time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.LastUpdateTs = ts
+}
+
+func (s *SpatialState) GetLastUpdateTs() time.Time {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.LastUpdateTs
+}
+
+func (s *SpatialState) MarshalJSON() ([]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	type spatialStateFields SpatialState
+	return json.Marshal((*spatialStateFields)(s))
+}
+
+func (s *SpatialState) String() string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		panic("Error marshaling spatial arb state: " + err.Error())
+	}
+
+	return string(b)
+}
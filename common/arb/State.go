@@ -4,6 +4,7 @@ import (
 	"time"
 	"midas/common"
 	"encoding/json"
+	"sync"
 )
 
 type State struct {
@@ -12,10 +13,15 @@ type State struct {
 	QtyBefore float64
 	QtyAfter float64
 	ProfitRelative float64
-	Triangle *Triangle
+	ProfitInRef float64 // absolute profit valued in RefAsset
+	RefAsset string
+	Triangle *Triangle // nil for cycles which are not triangles, oriented in Direction
+	Direction Direction
+	Cycle *Cycle
+	Legs []*Leg // in execution order, last leg returns to the first coin
 	StartTs time.Time
-	LastUpdateTs time.Time
-	FrameUpdateTsQueue []time.Time // holds timestamps of frame updates
+	LastUpdateTs time.Time // detector keeps touching it while arb holds, use Touch and GetLastUpdateTs
+	FrameHistory *FrameHistory
 	Orders map[string]*common.OrderRequest
 	BalanceA float64
 	BalanceB float64
@@ -23,18 +29,79 @@ type State struct {
 	OrderQtyAB float64
 	OrderQtyBC float64
 	OrderQtyAC float64
-	ScheduledForExecution bool
+	ScheduledForExecution bool // use MarkScheduled and IsScheduled
 	UsesAllBalance bool
+	SizedByDepth bool // sized by walking order books, otherwise by top of book
+	lastRejection string // reason state was last not executed for, use Reject
+	mux sync.RWMutex
+}
+
+// Fields not listed above are set before state is published and never change after
+func (s *State) Touch(ts time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.LastUpdateTs = ts
+}
+
+func (s *State) GetLastUpdateTs() time.Time {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.LastUpdateTs
+}
+
+// Returns false if state is already scheduled, so it is executed at most once
+func (s *State) MarkScheduled() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.ScheduledForExecution {
+		return false
+	}
+	s.ScheduledForExecution = true
+	return true
+}
+
+// Returns false if state was last rejected for the same reason, so repeated rejections are reported once
+func (s *State) Reject(reason string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.lastRejection == reason {
+		return false
+	}
+	s.lastRejection = reason
+	return true
+}
+
+func (s *State) IsScheduled() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.ScheduledForExecution
+}
+
+func (s *State) MarshalJSON() ([]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	// Same fields without MarshalJSON
+	type stateFields State
+	return json.Marshal((*stateFields)(s))
 }
 
 func (s *State) GetFrameUpdateCount() int {
-	count := 0
-	for _, ts := range s.FrameUpdateTsQueue {
-		if ts.Before(s.LastUpdateTs) {
-			count++
-		}
+	frames, _ := s.FrameHistory.Confirmations(s.GetLastUpdateTs())
+	return frames
+}
+
+// Coins the arb goes through, e.g. ETH->BTC->DNT->ETH
+func (s *State) Chain() string {
+	if len(s.Legs) == 0 {
+		return ""
+	}
+
+	chain := s.Legs[0].From.CoinSymbol
+	for _, leg := range s.Legs {
+		chain += "->" + leg.To.CoinSymbol
 	}
-	return count
+
+	return chain
 }
 
 func (s *State) String() string {
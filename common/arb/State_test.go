@@ -0,0 +1,58 @@
+package arb
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStateIsScheduledOnce(t *testing.T) {
+	state := &State{Id: "ETHBTC_BNBBTC_BNBETH", FrameHistory: NewFrameHistory()}
+	scheduled := int32(0)
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state.Touch(time.Now())
+			if state.MarkScheduled() {
+				mux.Lock()
+				scheduled++
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if scheduled != 1 || !state.IsScheduled() {
+		t.Errorf("expected state to be scheduled exactly once, got %d", scheduled)
+	}
+}
+
+func TestStateMarshalJSON(t *testing.T) {
+	ts := time.Unix(1500000000, 0).UTC()
+	state := &State{Id: "id", FrameHistory: NewFrameHistory()}
+	state.Touch(ts)
+
+	out, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(out, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["Id"] != "id" || fields["LastUpdateTs"] != ts.Format(time.RFC3339) {
+		t.Errorf("unexpected fields %s", string(out))
+	}
+}
+
+func TestStateRejectionIsReportedOnce(t *testing.T) {
+	state := &State{Id: "id", FrameHistory: NewFrameHistory()}
+	reported := []bool{state.Reject("MIN_NOTIONAL ETHBTC"), state.Reject("MIN_NOTIONAL ETHBTC"), state.Reject("RESERVATION: BTC")}
+	if !reported[0] || reported[1] || !reported[2] {
+		t.Errorf("expected only changed reasons to be reported, got %v", reported)
+	}
+}
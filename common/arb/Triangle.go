@@ -13,3 +13,28 @@ type Triangle struct {
 	CoinC common.Coin
 	Key string
 }
+
+func (t *Triangle) Symbols() []string {
+	return []string{t.PairAB.PairSymbol, t.PairBC.PairSymbol, t.PairAC.PairSymbol}
+}
+
+// Order coins of a triangle are traded in
+type Direction string
+
+var (
+	DirectionForward = Direction("FORWARD") // A->B->C->A
+	DirectionReverse = Direction("REVERSE") // A->C->B->A
+)
+
+// Same triangle with B and C swapped, so A->B->C->A of the result is A->C->B->A of t
+func (t *Triangle) Reversed() *Triangle {
+	return &Triangle{
+		PairAB: t.PairAC,
+		PairBC: t.PairBC,
+		PairAC: t.PairAB,
+		CoinA: t.CoinA,
+		CoinB: t.CoinC,
+		CoinC: t.CoinB,
+		Key: t.Key,
+	}
+}
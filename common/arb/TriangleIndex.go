@@ -0,0 +1,49 @@
+package arb
+
+// Triangles indexed by symbols of their pairs, so triangles affected by a ticker update can be found without a full scan.
+// Not safe for concurrent use
+type TriangleIndex struct {
+	bySymbol map[string]map[string]*Triangle // symbol -> triangle key -> triangle
+}
+
+func NewTriangleIndex() *TriangleIndex {
+	return &TriangleIndex{
+		bySymbol: make(map[string]map[string]*Triangle),
+	}
+}
+
+func (index *TriangleIndex) Add(triangle *Triangle) {
+	for _, symbol := range triangle.Symbols() {
+		triangles, ok := index.bySymbol[symbol]
+		if !ok {
+			triangles = make(map[string]*Triangle)
+			index.bySymbol[symbol] = triangles
+		}
+		triangles[triangle.Key] = triangle
+	}
+}
+
+func (index *TriangleIndex) Remove(triangle *Triangle) {
+	for _, symbol := range triangle.Symbols() {
+		triangles, ok := index.bySymbol[symbol]
+		if !ok {
+			continue
+		}
+		delete(triangles, triangle.Key)
+		if len(triangles) == 0 {
+			delete(index.bySymbol, symbol)
+		}
+	}
+}
+
+// Returns triangles which use at least one of symbols, keyed by triangle key
+func (index *TriangleIndex) TrianglesForSymbols(symbols []string) map[string]*Triangle {
+	result := make(map[string]*Triangle)
+	for _, symbol := range symbols {
+		for key, triangle := range index.bySymbol[symbol] {
+			result[key] = triangle
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,100 @@
+package arb
+
+import (
+	"midas/common"
+	"strconv"
+	"testing"
+)
+
+var quoteCoins = []string{"BTC", "ETH", "BNB", "USDT"}
+
+// Builds a market shaped like Binance: every coin is quoted in every quote coin
+func makeTriangles(numCoins int) ([]*Triangle, []string) {
+	pairs := make(map[string]*common.CoinPair)
+	makePair := func(base string, quote string) *common.CoinPair {
+		symbol := base + quote
+		if pair, ok := pairs[symbol]; ok {
+			return pair
+		}
+		pair := &common.CoinPair{symbol, common.Coin{base}, common.Coin{quote}}
+		pairs[symbol] = pair
+		return pair
+	}
+
+	triangles := make([]*Triangle, 0)
+	for i := 0; i < numCoins; i++ {
+		coin := "C" + strconv.Itoa(i)
+		for j, quoteB := range quoteCoins {
+			for _, quoteC := range quoteCoins[j+1:] {
+				triangles = append(triangles, &Triangle{
+					PairAB: makePair(coin, quoteB),
+					PairBC: makePair(quoteC, quoteB),
+					PairAC: makePair(coin, quoteC),
+					CoinA: common.Coin{coin},
+					CoinB: common.Coin{quoteB},
+					CoinC: common.Coin{quoteC},
+					Key: coin + quoteB + quoteC,
+				})
+			}
+		}
+	}
+
+	symbols := make([]string, 0, len(pairs))
+	for symbol := range pairs {
+		symbols = append(symbols, symbol)
+	}
+
+	return triangles, symbols
+}
+
+func benchmarkTrianglesForSymbols(b *testing.B, numChanged int) {
+	triangles, symbols := makeTriangles(400)
+	index := NewTriangleIndex()
+	for _, triangle := range triangles {
+		index.Add(triangle)
+	}
+	changed := symbols[:numChanged]
+
+	b.ResetTimer()
+	evaluated := 0
+	for i := 0; i < b.N; i++ {
+		evaluated += len(index.TrianglesForSymbols(changed))
+	}
+	b.ReportMetric(float64(evaluated)/float64(b.N), "triangles/update")
+	b.ReportMetric(float64(len(triangles)), "triangles/full_scan")
+}
+
+func BenchmarkTrianglesForSymbols10(b *testing.B) {
+	benchmarkTrianglesForSymbols(b, 10)
+}
+
+func BenchmarkTrianglesForSymbols100(b *testing.B) {
+	benchmarkTrianglesForSymbols(b, 100)
+}
+
+func BenchmarkTrianglesForSymbols1000(b *testing.B) {
+	benchmarkTrianglesForSymbols(b, 1000)
+}
+
+func TestTrianglesForSymbols(t *testing.T) {
+	triangles, _ := makeTriangles(10)
+	index := NewTriangleIndex()
+	for _, triangle := range triangles {
+		index.Add(triangle)
+	}
+
+	// ETHBTC is the BC pair of every triangle through BTC and ETH
+	if got := len(index.TrianglesForSymbols([]string{"ETHBTC"})); got != 10 {
+		t.Errorf("expected 10 triangles for ETHBTC, got %d", got)
+	}
+
+	// C0 forms a triangle with every pair of quote coins
+	if got := len(index.TrianglesForSymbols([]string{"C0BTC", "C0ETH"})); got != 5 {
+		t.Errorf("expected 5 triangles for C0BTC and C0ETH, got %d", got)
+	}
+
+	index.Remove(triangles[0])
+	if got := len(index.TrianglesForSymbols([]string{"ETHBTC"})); got != 9 {
+		t.Errorf("expected 9 triangles for ETHBTC after removal, got %d", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"encoding/json"
 	"log"
+	"errors"
 )
 
 const (
@@ -23,6 +24,74 @@ type BrainConfig struct {
 	MYSQL_PASSWORD string `json:"mysql_password"`
 	API_KEY string `json:"api_key"`
 	API_SECRET string `json:"api_secret"`
+	EYE_SETTINGS *EyeSettingsConfig `json:"eye_settings"`
+	IN_PROCESS_EYES int `json:"in_process_eyes"` // number of eyes brain_exec runs in its own process
+	CYCLE_MAX_LEGS int `json:"cycle_max_legs"` // longest cycle N-leg detector looks for, detector is off if less than 3
+	CYCLE_START_COINS []string `json:"cycle_start_coins"` // coins cycles start and end with, profit is measured in them
+	CYCLE_DETECTION_PERIOD_MILLIS int `json:"cycle_detection_period_millis"`
+	EXECUTION_THRESHOLDS *ExecutionThresholdsConfig `json:"execution_thresholds"`
+	EXECUTION *ExecutionConfig `json:"execution"`
+	ORDER_MANAGER *OrderManagerConfig `json:"order_manager"`
+	PAPER_TRADING *PaperTradingConfig `json:"paper_trading"` // orders are filled against live books with virtual balances if set
+	PERSISTENCE *PersistenceConfig `json:"persistence"` // arbs are executed right away if missing
+	UNIVERSE *UniverseConfig `json:"universe"` // re-read at runtime
+	ARB_EVENTS_PORT int `json:"arb_events_port"` // arb events are published over ZMQ PUB if set
+	EXCHANGE_FEES map[string]float64 `json:"exchange_fees"` // taker fee per exchange for spatial arbs
+}
+
+// Coins, symbols and triangles arbs and rebalancing may use, empty allow list means everything is allowed,
+// deny lists win over allow lists. Triangles are given by key, i.e. their coins sorted and joined (BTCDNTETH)
+type UniverseConfig struct {
+	ALLOWED_COINS []string `json:"allowed_coins"`
+	DENIED_COINS []string `json:"denied_coins"`
+	ALLOWED_SYMBOLS []string `json:"allowed_symbols"`
+	DENIED_SYMBOLS []string `json:"denied_symbols"`
+	ALLOWED_TRIANGLES []string `json:"allowed_triangles"`
+	DENIED_TRIANGLES []string `json:"denied_triangles"`
+}
+
+// Arb has to hold for MIN_FRAMES frames or MIN_DURATION_MILLIS, seen by MIN_DISTINCT_EYES eyes, before it is executed
+type PersistenceConfig struct {
+	MIN_FRAMES int `json:"min_frames"` // at most arb.FRAME_HISTORY_SIZE
+	MIN_DURATION_MILLIS int `json:"min_duration_millis"`
+	MIN_DISTINCT_EYES int `json:"min_distinct_eyes"`
+}
+
+// Arb is executed only if its profit is left above thresholds after buffers are taken out
+type ExecutionThresholdsConfig struct {
+	FEE float64 `json:"fee"` // taker fee of each leg
+	MIN_RELATIVE_PROFIT float64 `json:"min_relative_profit"`
+	MIN_ABSOLUTE_PROFIT float64 `json:"min_absolute_profit"` // in REFERENCE_ASSET, 0 disables the check
+	REFERENCE_ASSET string `json:"reference_asset"`
+	SLIPPAGE_PER_LEG float64 `json:"slippage_per_leg"` // expected relative slippage of each leg
+	LATENCY_PENALTY_PER_MS float64 `json:"latency_penalty_per_ms"` // relative profit lost per ms of frame age
+}
+
+// How arb legs are sent and what is done when some of them do not fill
+type ExecutionConfig struct {
+	STRATEGY string `json:"strategy"` // SEQUENTIAL or PARALLEL, PARALLEL if missing
+	DISABLE_RECOVERY bool `json:"disable_recovery"` // leftovers of legs which did not fill are only logged, not traded back
+}
+
+// Open orders are reconciled with exchange every RECONCILE_PERIOD_MILLIS, orders brain placed which are older
+// than ORDER_TTL_MILLIS are canceled
+type OrderManagerConfig struct {
+	ORDER_TTL_MILLIS int `json:"order_ttl_millis"` // 0 disables cancellation
+	RECONCILE_PERIOD_MILLIS int `json:"reconcile_period_millis"`
+	CANCEL_ON_SHUTDOWN bool `json:"cancel_on_shutdown"`
+}
+
+// Virtual account paper trading starts with
+type PaperTradingConfig struct {
+	BALANCES map[string]float64 `json:"balances"`
+	FEE float64 `json:"fee"` // taker fee charged in coin order receives, execution thresholds FEE if missing
+}
+
+// Runtime settings pushed to connected eyes
+type EyeSettingsConfig struct {
+	SYMBOLS map[string][]string `json:"symbols"` // exchange -> symbols, empty list means all symbols
+	REQUEST_TIMEOUT_MILLIS int `json:"request_timeout_millis"`
+	USE_WEBSOCKET_STREAMS bool `json:"use_websocket_streams"`
 }
 
 type EyeConfig struct {
@@ -34,24 +103,42 @@ func ReadBrainConfig() *BrainConfig {
 	if brainConfig != nil {
 		return brainConfig
 	}
+	config, err := readBrainConfigFile()
+	if err != nil {
+		panic(err.Error())
+	}
+	brainConfig = config
+	log.Println("BrainConfig created")
+
+	return brainConfig
+}
+
+// Reads brain config from disk without touching the cached instance,
+// used for settings which can change at runtime
+func ReloadBrainConfig() (*BrainConfig, error) {
+	return readBrainConfigFile()
+}
+
+func readBrainConfigFile() (*BrainConfig, error) {
+	var config *BrainConfig
 	jsonConfigFile, err := os.Open(BRAIN_CONFIG_PATH)
-	defer jsonConfigFile.Close()
 	if err != nil {
-		panic("Unable to open brain config. Make sure there is brain_config.json next to brain_exec binary: " + err.Error())
+		return nil, errors.New("Unable to open brain config. Make sure there is brain_config.json next to brain_exec binary: " + err.Error())
 	}
 
+	defer jsonConfigFile.Close()
+
 	byteValue, err := ioutil.ReadAll(jsonConfigFile)
 	if err != nil {
-		panic("Unable to parse brain config: " + err.Error())
+		return nil, errors.New("Unable to parse brain config: " + err.Error())
 	}
 
-	err = json.Unmarshal(byteValue, &brainConfig)
+	err = json.Unmarshal(byteValue, &config)
 	if err != nil {
-		panic("Unable to parse brain config: " + err.Error())
+		return nil, errors.New("Unable to parse brain config: " + err.Error())
 	}
-	log.Println("BrainConfig created")
 
-	return brainConfig
+	return config, nil
 }
 
 func ReadEyeConfig() *EyeConfig {
@@ -2,19 +2,89 @@ package main
 
 import (
 	"midas/brain"
+	"midas/configuration"
 	"midas/logging"
+	"midas/eyes"
+	"os"
+	"os/signal"
+	"syscall"
+	"log"
 )
 
+// Eyes run by brain process, cleaned up by brain itself on shutdown
+var inProcessEyes []*eyes.Eye
+
 func main() {
+	handleSignals()
 	initialize()
+	shutdown()
 }
 
 func initialize() {
+	brain.Configure(configuration.ReadBrainConfig())
 	logging.InitMySQLLogger()
 	brain.RunUpdateAccountInfo()
+	brain.RunOrderManager()
 	brain.RunUpdateExchangeInfo()
 	brain.ScheduleTickerUpdates()
+	brain.ScheduleDepthUpdates()
 	brain.SetupRequestReceiver()
-	defer brain.CleanupEyesHandler()
+	runInProcessEyes()
+	brain.RunEyesStatusUpdates()
+	brain.RunEyeSettingsUpdates()
+	brain.RunUniverseUpdates()
+	brain.RunArbEventsPublisher()
+	// Blocks until shutdown signal
 	brain.RunArbDetector()
 }
+
+// Routines which queue events are stopped before event queue is flushed
+func shutdown() {
+	brain.StopOrderExecution()
+	brain.StopOrderManager()
+	// After order manager, so reports of orders canceled on shutdown are recorded
+	brain.StopUserDataStream()
+	brain.StopArbEventsPublisher()
+	logging.FlushEventQueue()
+	brain.StopEyesHandler()
+	// In-process eyes close their sockets before brain closes its side
+	stopInProcessEyes()
+	brain.CleanupEyesHandler()
+	log.Println("Brain stopped")
+}
+
+func handleSignals() {
+	// SIGUSR1 cancels every open order without stopping brain
+	cancelSignals := make(chan os.Signal, 1)
+	signal.Notify(cancelSignals, syscall.SIGUSR1)
+	go func() {
+		for range cancelSignals {
+			log.Println("Received cancel signal")
+			brain.CancelAllOrders()
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("Received shutdown signal, shutting down...")
+		brain.StopArbDetector()
+		<-signals
+		log.Println("Received second shutdown signal, exiting")
+		os.Exit(1)
+	}()
+}
+
+func runInProcessEyes() {
+	brainConfig := configuration.ReadBrainConfig()
+	for i := 0; i < brainConfig.IN_PROCESS_EYES; i++ {
+		inProcessEyes = append(inProcessEyes, eyes.RunInProcessEye(brainConfig.CONNECTION_RECEIVER_PORT))
+	}
+}
+
+func stopInProcessEyes() {
+	for _, eye := range inProcessEyes {
+		eye.StopAndWait()
+	}
+}
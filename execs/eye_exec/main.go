@@ -2,9 +2,27 @@ package main
 
 import (
 	. "midas/eyes"
+	"os"
+	"os/signal"
+	"syscall"
+	"log"
 )
 
 func main() {
+	handleSignals()
 	SetupEye()
 	defer CleanupEye()
-}
\ No newline at end of file
+}
+
+func handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("Received shutdown signal, stopping eye...")
+		StopEye()
+		<-signals
+		log.Println("Received second shutdown signal, exiting")
+		os.Exit(1)
+	}()
+}
@@ -4,45 +4,136 @@ import (
 	"midas/common"
 	"github.com/pebbe/zmq4"
 	"sync"
+	"syscall"
 	"strconv"
 	"midas/apis/binance"
 	"time"
 	"log"
 	"midas/configuration"
+	"midas/network"
 )
 
 const (
 	TCP_PREFIX    = "tcp://"
 	INPUT_BUFFER_SIZE = 10000
+	// How often receiving goroutine checks whether eye is stopped
+	RECEIVE_TIMEOUT_MILLIS = 500
 )
 
-var eyeConfig = configuration.ReadEyeConfig()
+type Eye struct {
+	endpoint func(port int) string // builds brain endpoint address for port
+	connectionReceiverPort int
+	inProcess bool
+	channelIn chan string
+	socketIn *zmq4.Socket
+	socketOut *zmq4.Socket
+	portIn int
+	portOut int
+	setupWg sync.WaitGroup
+	stopOnce sync.Once
+	stopped chan struct{} // closed by Stop
+	socketsWg sync.WaitGroup // released once goroutines using sockets closed them
+	cleanedUp chan struct{} // closed once in-process eye is stopped and cleaned up
+	tickersFrameEncoders map[string]*TickersFrameEncoder // exchange -> encoder
+	stats *EyeStats
+	// Eyes running in brain process have their own settings and stream
+	settings *common.EyeSettings
+	settingsMux sync.RWMutex
+	stream *tickersStream
+	streamConnecting bool
+	streamMux sync.Mutex
+}
+
+// Eye run by eye_exec
+var standaloneEye *Eye
+
+func NewEye(endpoint func(port int) string, connectionReceiverPort int, inProcess bool) *Eye {
+	eye := &Eye{
+		endpoint: endpoint,
+		connectionReceiverPort: connectionReceiverPort,
+		inProcess: inProcess,
+		channelIn: make(chan string, INPUT_BUFFER_SIZE),
+		stopped: make(chan struct{}),
+		cleanedUp: make(chan struct{}),
+		tickersFrameEncoders: map[string]*TickersFrameEncoder{
+			common.BINANCE: {},
+		},
+		stats: NewEyeStats(),
+		settings: makeDefaultEyeSettings(),
+	}
+	// Released by Stop
+	eye.setupWg.Add(1)
 
-var channelIn = make(chan string, INPUT_BUFFER_SIZE)
-var socketIn *zmq4.Socket
-var socketOut *zmq4.Socket
-var portIn int
-var portOut int
-var setupWg sync.WaitGroup
+	return eye
+}
 
 func SetupEye() {
-	requestSetupMetadata()
-	socketIn = setupInSocket()
-	socketOut = setupOutSocket()
+	eyeConfig := configuration.ReadEyeConfig()
+	standaloneEye = NewEye(
+		func(port int) string {
+			return TCP_PREFIX + eyeConfig.BRAIN_ADDRESS + ":" + strconv.Itoa(port)
+		},
+		eyeConfig.BRAIN_CONNECTION_RECEIVER_PORT,
+		false,
+	)
+	standaloneEye.Run()
+}
 
-	setupWg.Wait()
+func CleanupEye() {
+	standaloneEye.Cleanup()
+}
+
+// Unblocks SetupEye as if brain sent KILL_EYE
+func StopEye() {
+	if standaloneEye != nil {
+		standaloneEye.Stop()
+	}
+}
+
+// Runs eye inside brain process, it talks to brain over inproc transport with the same messages as a remote eye
+func RunInProcessEye(connectionReceiverPort int) *Eye {
+	eye := NewEye(common.InprocEndpoint, connectionReceiverPort, true)
+	go func() {
+		eye.Run()
+		eye.Cleanup()
+		close(eye.cleanedUp)
+	}()
+
+	return eye
+}
+
+// Stops in-process eye and blocks until its sockets and stream are closed
+func (e *Eye) StopAndWait() {
+	e.Stop()
+	<-e.cleanedUp
+}
+
+// Blocks until eye is killed by brain
+func (e *Eye) Run() {
+	e.requestSetupMetadata()
+	e.socketIn = e.setupInSocket()
+	e.socketOut = e.setupOutSocket()
+
+	e.setupWg.Wait()
 	log.Println("Killed eye")
 }
 
-func requestSetupMetadata() {
+func (e *Eye) requestSetupMetadata() {
 	socket, _ := zmq4.NewSocket(zmq4.REQ)
-	address := TCP_PREFIX + eyeConfig.BRAIN_ADDRESS + ":" + strconv.Itoa(eyeConfig.BRAIN_CONNECTION_RECEIVER_PORT)
+	defer socket.Close()
+	address := e.endpoint(e.connectionReceiverPort)
 	log.Println("Requesting metadata from brain at address: " + address)
 	socket.Connect(address)
 	// TODO proper message
+	var args map[string]string
+	if e.inProcess {
+		args = map[string]string{
+			common.TRANSPORT: common.TRANSPORT_INPROC,
+		}
+	}
 	message := common.Message{
 		common.CONNECT_EYE,
-		nil,
+		args,
 		nil,
 		"",
 	}
@@ -53,21 +144,40 @@ func requestSetupMetadata() {
 		log.Println("Bad port confirmation message from Brain, aborting...")
 		return
 	}
-	args := response.Args
+	respArgs := response.Args
 	// Brain port_in == Eye port_out
-	log.Println("Received metadata: Port In: " + args[common.PORT_OUT] + " Port out: " + args[common.PORT_IN])
-	portIn, _ = strconv.Atoi(args[common.PORT_OUT])
-	portOut, _ = strconv.Atoi(args[common.PORT_IN])
+	log.Println("Received metadata: Port In: " + respArgs[common.PORT_OUT] + " Port out: " + respArgs[common.PORT_IN])
+	e.portIn, _ = strconv.Atoi(respArgs[common.PORT_OUT])
+	e.portOut, _ = strconv.Atoi(respArgs[common.PORT_IN])
 }
 
-func CleanupEye() {
-	socketOut.Close()
-	socketIn.Close()
+func (e *Eye) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.stopped)
+		e.setupWg.Done()
+	})
+}
+
+func (e *Eye) isStopped() bool {
+	select {
+	case <-e.stopped:
+		return true
+	default:
+		return false
+	}
 }
 
-func setupOutSocket() *zmq4.Socket {
+// Sockets are closed by goroutines which use them once they see eye is stopped, as closing
+// a socket another goroutine is receiving from makes it return empty messages
+func (e *Eye) Cleanup() {
+	e.Stop()
+	e.socketsWg.Wait()
+	e.stopTickersStream()
+}
+
+func (e *Eye) setupOutSocket() *zmq4.Socket {
 	out, _ := zmq4.NewSocket(zmq4.PULL)
-	address := TCP_PREFIX + eyeConfig.BRAIN_ADDRESS + ":" + strconv.Itoa(portOut)
+	address := e.endpoint(e.portOut)
 	log.Println("Eye: connecting out to: " + address)
 	out.Connect(address)
 	message := common.Message{
@@ -76,21 +186,34 @@ func setupOutSocket() *zmq4.Socket {
 		nil,
 		"",
 	}
-	socketIn.Send(message.SerializeMessage(), 0)
-	setupWg.Add(1)
+	e.channelIn<-message.SerializeMessage()
+	out.SetRcvtimeo(time.Duration(RECEIVE_TIMEOUT_MILLIS) * time.Millisecond)
+	e.socketsWg.Add(1)
 	go func(){
-		for {
-			msg, _ := out.Recv(0)
-			handleMessage(msg)
+		defer e.socketsWg.Done()
+		defer out.Close()
+		for !e.isStopped() {
+			msg, err := out.Recv(0)
+			if err != nil {
+				if zmq4.AsErrno(err) == zmq4.Errno(syscall.EAGAIN) {
+					continue
+				}
+				log.Println("Eye out error: " + err.Error())
+				return
+			}
+			if msg == "" {
+				continue
+			}
+			e.handleMessage(msg)
 		}
 	}()
 
 	return out
 }
 
-func setupInSocket() *zmq4.Socket {
+func (e *Eye) setupInSocket() *zmq4.Socket {
 	in, _ := zmq4.NewSocket(zmq4.PUSH)
-	address := TCP_PREFIX + eyeConfig.BRAIN_ADDRESS + ":" + strconv.Itoa(portIn)
+	address := e.endpoint(e.portIn)
 	log.Println("Eye: connecting in to: " + address)
 	in.Connect(address)
 	message := common.Message{
@@ -100,36 +223,89 @@ func setupInSocket() *zmq4.Socket {
 		"",
 	}
 	in.Send(message.SerializeMessage(), 0)
+	e.socketsWg.Add(1)
 	go func(){
+		defer e.socketsWg.Done()
+		defer in.Close()
 		for {
-			msg := <-channelIn
-			in.Send(msg,0)
+			select {
+			case msg := <-e.channelIn:
+				in.Send(msg,0)
+			case <-e.stopped:
+				return
+			}
 		}
 	}()
 
 	return in
 }
 
-func handleMessage(messageSerialized string) {
+func (e *Eye) handleMessage(messageSerialized string) {
 	log.Println("Eye received: " + messageSerialized)
 	message := common.DeserializeMessage(messageSerialized)
 	command := message.Command
+	e.stats.RecordRequest(command)
 	switch command {
 	case common.KILL_EYE:
-		setupWg.Done()
+		e.Stop()
+	case common.SET_SETTINGS:
+		var errMsg string
+		newSettings, err := common.DeserializeEyeSettings(message.Args[common.EYE_SETTINGS_SERIALIZED])
+		if err == nil {
+			err = e.applySettings(newSettings)
+		}
+		if err != nil {
+			log.Println("Error applying settings: " + err.Error())
+			errMsg = err.Error()
+		}
+
+		response := common.Message{
+			common.SETTINGS_ACK,
+			map[string]string{
+				common.SETTINGS_VERSION: message.Args[common.SETTINGS_VERSION],
+			},
+			nil,
+			errMsg,
+		}
+
+		e.channelIn<-response.SerializeMessage()
+	case common.STATUS_REQ:
+		response := common.Message{
+			common.STATUS_RESP,
+			map[string]string{
+				common.EYE_STATUS_SERIALIZED: e.stats.GetStatus(e.getSettings().Version).Serialize(),
+			},
+			&common.TraceInfo{
+				BrainReqSentTs: message.TraceInfo.BrainReqSentTs,
+			},
+			"",
+		}
+
+		e.channelIn<-response.SerializeMessage()
 	case common.DEPTH_REQ:
 		args := message.Args
 		pair := args[common.CURRENCY_PAIR]
 		exchange := args[common.EXCHANGE]
 
+		var refusal string
 		if exchange != common.BINANCE {
-			log.Println("Unsupported exchange " + exchange)
+			refusal = "Unsupported exchange " + exchange
+		} else if !e.getSettings().ServesSymbol(exchange, pair) {
+			refusal = "Symbol " + pair + " is not served by this eye"
+		}
+		if refusal != "" {
+			// Replied anyway, so brain settles weight it reserved for the request
+			log.Println(refusal)
+			e.refuseRequest(common.DEPTH_RESP, message, map[string]string{
+				common.CURRENCY_PAIR: pair,
+				common.EXCHANGE: exchange,
+			}, refusal)
 			return
 		}
 
 		go func() {
 			tStart := time.Now()
-			depth, err := binance.GetDepth(100, pair)
+			depth, err := binance.GetDepth(binance.MAX_DEPTH, pair, e.getRequestTimeout())
 			var serialized string
 			var errMsg string
 			if err != nil {
@@ -142,6 +318,7 @@ func handleMessage(messageSerialized string) {
 			}
 			tEnd := time.Now()
 			delta := tEnd.Sub(tStart)
+			e.stats.RecordExchangeCall(command, delta, err)
 			log.Println("Depth fetched in " + delta.String())
 
 			response := common.Message{
@@ -159,39 +336,63 @@ func handleMessage(messageSerialized string) {
 				errMsg,
 			}
 
-			channelIn<-response.SerializeMessage()
+			addWeightArgs(response.Args, args, binance.GetDepthRequestWeight(binance.MAX_DEPTH))
+			e.channelIn<-response.SerializeMessage()
 		} ()
 
 	case common.TICKERS_MAP_REQ:
 		args := message.Args
 		exchange := args[common.EXCHANGE]
 
+		var refusal string
 		if exchange != common.BINANCE {
-			log.Println("Unsupported exchange " + exchange)
+			refusal = "Unsupported exchange " + exchange
+		} else if !e.getSettings().ServesExchange(exchange) {
+			refusal = "Exchange " + exchange + " is not served by this eye"
+		}
+		if refusal != "" {
+			log.Println(refusal)
+			e.refuseRequest(common.TICKERS_MAP_RESP, message, map[string]string{
+				common.EXCHANGE: exchange,
+			}, refusal)
 			return
 		}
 
+		encoder := e.tickersFrameEncoders[exchange]
+		if args[common.FORCE_KEYFRAME] != "" {
+			encoder.RequestKeyframe()
+		}
+
 		go func() {
 			tStart := time.Now()
-			tickers, err := binance.GetAllTickers()
-			var serialized string
+			tickers, ok := e.getStreamedTickers()
+			var err error
+			weightConsumed := int64(0)
+			if !ok {
+				tickers, err = binance.GetAllTickers(e.getRequestTimeout())
+				weightConsumed = binance.ALL_TICKERS_REQUEST_WEIGHT
+				if err == nil {
+					e.seedStreamedTickers(tickers)
+				}
+			}
+			if err == nil {
+				tickers = e.filterTickers(exchange, tickers)
+			}
 			var errMsg string
 			if err != nil {
 				log.Println("Error fetching tickers")
-				serialized = ""
 				errMsg = err.Error()
 			} else {
-				serialized = tickers.Serialize()
 				errMsg = ""
 			}
 			tEnd := time.Now()
 			delta := tEnd.Sub(tStart) // nanosec
+			e.stats.RecordExchangeCall(command, delta, err)
 			log.Println("Tickers fetched in " + delta.String())
 
 			response := common.Message{
 				common.TICKERS_MAP_RESP,
 				map[string]string{
-					common.TICKERS_MAP_SERIALIZED:	serialized,
 					common.EXCHANGE:				exchange,
 				},
 				&common.TraceInfo{
@@ -202,7 +403,35 @@ func handleMessage(messageSerialized string) {
 				errMsg,
 			}
 
-			channelIn<-response.SerializeMessage()
+			addWeightArgs(response.Args, args, weightConsumed)
+			if err != nil {
+				e.channelIn<-response.SerializeMessage()
+				return
+			}
+
+			encoder.EncodeAndSend(tickers, &response, e.channelIn)
 		} ()
 	}
 }
+
+// Replies with error to request eye does not serve, nothing is fetched so no weight is consumed
+func (e *Eye) refuseRequest(command string, request *common.Message, responseArgs map[string]string, errMsg string) {
+	response := common.Message{
+		command,
+		responseArgs,
+		&common.TraceInfo{
+			BrainReqSentTs: request.TraceInfo.BrainReqSentTs,
+		},
+		errMsg,
+	}
+
+	addWeightArgs(response.Args, request.Args, 0)
+	e.channelIn<-response.SerializeMessage()
+}
+
+// Reports weight consumed by request and last seen used weight so brain can track eye's budget
+func addWeightArgs(responseArgs map[string]string, requestArgs map[string]string, weightConsumed int64) {
+	responseArgs[common.WEIGHT_RESERVED] = requestArgs[common.WEIGHT_RESERVED]
+	responseArgs[common.WEIGHT_CONSUMED] = strconv.FormatInt(weightConsumed, 10)
+	responseArgs[common.USED_WEIGHT_SERIALIZED] = common.ParseUsedWeightHeaders(network.GetUsedWeightHeaders()).Serialize()
+}
@@ -0,0 +1,176 @@
+package eyes
+
+import (
+	"github.com/gorilla/websocket"
+	"log"
+	"midas/apis/binance"
+	"midas/common"
+	"strconv"
+	"time"
+)
+
+// Streamed tickers older than this are considered stale, stream is reconnected and REST is used meanwhile
+const STREAM_STALE_THRESHOLD_SEC = 5
+
+// Used until brain pushes settings
+func makeDefaultEyeSettings() *common.EyeSettings {
+	return &common.EyeSettings{
+		Version: 0,
+		Symbols: map[string][]string{
+			common.BINANCE: {},
+		},
+		RequestTimeoutMillis: 0,
+		UseWebSocketStreams: false,
+	}
+}
+
+// Book tickers stream connection with tickers it received. Each connection fills its own map,
+// so callbacks of a closed connection never write into the map of the next one.
+type tickersStream struct {
+	conn *websocket.Conn
+	tickers common.TickersMap
+	updateTs time.Time
+	// Stream only pushes tickers which change, so it is merged into a REST snapshot before it is used
+	seeded bool
+}
+
+// Settings are replaced on update, never mutated
+func (e *Eye) getSettings() *common.EyeSettings {
+	e.settingsMux.RLock()
+	defer e.settingsMux.RUnlock()
+	return e.settings
+}
+
+// Each eye's requests time out on their own, so eyes running in brain process don't share a timeout
+func (e *Eye) getRequestTimeout() time.Duration {
+	return time.Duration(e.getSettings().RequestTimeoutMillis) * time.Millisecond
+}
+
+// Stream is dialed before settings are swapped, getSettings is not blocked meanwhile
+func (e *Eye) applySettings(newSettings *common.EyeSettings) error {
+	if newSettings.Symbols == nil {
+		newSettings.Symbols = make(map[string][]string)
+	}
+
+	if newSettings.UseWebSocketStreams {
+		if err := e.startTickersStream(); err != nil {
+			return err
+		}
+	} else {
+		e.stopTickersStream()
+	}
+
+	e.settingsMux.Lock()
+	defer e.settingsMux.Unlock()
+	e.settings = newSettings
+	log.Println("Applied settings version " + strconv.FormatInt(newSettings.Version, 10))
+
+	return nil
+}
+
+func (e *Eye) startTickersStream() error {
+	e.streamMux.Lock()
+	if e.stream != nil || e.streamConnecting {
+		e.streamMux.Unlock()
+		return nil
+	}
+	e.streamConnecting = true
+	e.streamMux.Unlock()
+
+	stream := &tickersStream{
+		tickers: make(common.TickersMap),
+	}
+	conn, err := binance.StreamAllBookTickers(func(ticker *common.Ticker) {
+		e.streamMux.Lock()
+		defer e.streamMux.Unlock()
+		stream.tickers[ticker.Symbol] = ticker
+		stream.updateTs = time.Now()
+	})
+
+	e.streamMux.Lock()
+	defer e.streamMux.Unlock()
+	e.streamConnecting = false
+	if err != nil {
+		return err
+	}
+	stream.conn = conn
+	stream.updateTs = time.Now()
+	e.stream = stream
+
+	return nil
+}
+
+func (e *Eye) stopTickersStream() {
+	e.streamMux.Lock()
+	defer e.streamMux.Unlock()
+
+	if e.stream == nil {
+		return
+	}
+
+	e.stream.conn.Close()
+	e.stream = nil
+}
+
+// Returns copy of streamed tickers, false if streams are off, stream went stale
+// or was not merged into a REST snapshot yet
+func (e *Eye) getStreamedTickers() (*common.TickersMap, bool) {
+	if !e.getSettings().UseWebSocketStreams {
+		return nil, false
+	}
+
+	e.streamMux.Lock()
+	if e.stream == nil || time.Since(e.stream.updateTs) > time.Duration(STREAM_STALE_THRESHOLD_SEC) * time.Second {
+		e.streamMux.Unlock()
+		log.Println("Tickers stream is stale, reconnecting...")
+		e.stopTickersStream()
+		go e.startTickersStream()
+		return nil, false
+	}
+	if !e.stream.seeded {
+		e.streamMux.Unlock()
+		return nil, false
+	}
+
+	tickers := make(common.TickersMap)
+	for symbol, ticker := range e.stream.tickers {
+		tickers[symbol] = ticker
+	}
+	e.streamMux.Unlock()
+
+	return &tickers, true
+}
+
+// Fills symbols stream did not push yet from REST snapshot, tickers already streamed are newer and are kept.
+// From then on stream covers every symbol and is used instead of REST.
+func (e *Eye) seedStreamedTickers(snapshot *common.TickersMap) {
+	e.streamMux.Lock()
+	defer e.streamMux.Unlock()
+
+	if e.stream == nil || e.stream.seeded {
+		return
+	}
+	for symbol, ticker := range *snapshot {
+		if _, ok := e.stream.tickers[symbol]; !ok {
+			e.stream.tickers[symbol] = ticker
+		}
+	}
+	e.stream.seeded = true
+}
+
+// Leaves only symbols eye is configured to serve
+func (e *Eye) filterTickers(exchange string, tickers *common.TickersMap) *common.TickersMap {
+	currentSettings := e.getSettings()
+	if len(currentSettings.Symbols[exchange]) == 0 {
+		return tickers
+	}
+
+	filtered := make(common.TickersMap)
+	for symbol, ticker := range *tickers {
+		if currentSettings.ServesSymbol(exchange, symbol) {
+			filtered[symbol] = ticker
+		}
+	}
+
+	return &filtered
+}
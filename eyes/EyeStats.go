@@ -0,0 +1,96 @@
+package eyes
+
+import (
+	"midas/common"
+	"midas/network"
+	"sync"
+	"time"
+)
+
+// Number of latest exchange calls per command used for latency stats
+const LATENCY_WINDOW_SIZE = 100
+
+// Set at build time with -ldflags "-X midas/eyes.Version=..."
+var Version = "dev"
+
+type EyeStats struct {
+	startTs time.Time
+	requestCounts map[string]int64
+	errorCounts map[string]int64
+	latencies map[string][]time.Duration // command -> ring buffer of recent exchange latencies
+	latencyIndexes map[string]int
+	mux sync.Mutex
+}
+
+func NewEyeStats() *EyeStats {
+	return &EyeStats{
+		startTs: time.Now(),
+		requestCounts: make(map[string]int64),
+		errorCounts: make(map[string]int64),
+		latencies: make(map[string][]time.Duration),
+		latencyIndexes: make(map[string]int),
+	}
+}
+
+func (s *EyeStats) RecordRequest(command string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.requestCounts[command]++
+}
+
+func (s *EyeStats) RecordExchangeCall(command string, latency time.Duration, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err != nil {
+		s.errorCounts[command]++
+	}
+
+	window := s.latencies[command]
+	if len(window) < LATENCY_WINDOW_SIZE {
+		s.latencies[command] = append(window, latency)
+		return
+	}
+	index := s.latencyIndexes[command]
+	window[index] = latency
+	s.latencyIndexes[command] = (index + 1) % LATENCY_WINDOW_SIZE
+}
+
+func (s *EyeStats) GetStatus(settingsVersion int64) *common.EyeStatus {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	status := &common.EyeStatus{
+		Version: Version,
+		SettingsVersion: settingsVersion,
+		StartTs: s.startTs,
+		Uptime: time.Since(s.startTs),
+		RequestCounts: make(map[string]int64),
+		ErrorCounts: make(map[string]int64),
+		AvgLatencyMicros: make(map[string]int64),
+		MaxLatencyMicros: make(map[string]int64),
+		UsedWeightHeaders: network.GetUsedWeightHeaders(),
+	}
+
+	for command, count := range s.requestCounts {
+		status.RequestCounts[command] = count
+	}
+
+	for command, count := range s.errorCounts {
+		status.ErrorCounts[command] = count
+	}
+
+	for command, window := range s.latencies {
+		var total time.Duration
+		var max time.Duration
+		for _, latency := range window {
+			total += latency
+			if latency > max {
+				max = latency
+			}
+		}
+		status.AvgLatencyMicros[command] = int64(total / time.Duration(len(window)) / time.Microsecond)
+		status.MaxLatencyMicros[command] = int64(max / time.Microsecond)
+	}
+
+	return status
+}
@@ -0,0 +1,50 @@
+package eyes
+
+import (
+	"midas/common"
+	"strconv"
+	"sync"
+)
+
+// Every TICKERS_KEYFRAME_INTERVAL frames full tickers map is sent instead of a delta
+const TICKERS_KEYFRAME_INTERVAL = 100
+
+// Remembers last tickers map sent to brain so consecutive frames can be sent as deltas
+type TickersFrameEncoder struct {
+	lastSent *common.TickersMap
+	seq int64
+	framesSinceKeyframe int
+	forceKeyframe bool
+	mux sync.Mutex
+}
+
+func (e *TickersFrameEncoder) RequestKeyframe() {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.forceKeyframe = true
+}
+
+// Encodes tickers into response args and pushes response to brain.
+// Both happen under lock so frames reach brain in sequence order
+func (e *TickersFrameEncoder) EncodeAndSend(tickers *common.TickersMap, response *common.Message, channelIn chan<- string) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	e.seq++
+	response.Args[common.TICKERS_FRAME_SEQ] = strconv.FormatInt(e.seq, 10)
+
+	if e.lastSent == nil || e.forceKeyframe || e.framesSinceKeyframe >= TICKERS_KEYFRAME_INTERVAL {
+		response.Args[common.TICKERS_FRAME_TYPE] = common.TICKERS_FRAME_KEY
+		response.Args[common.TICKERS_MAP_SERIALIZED] = common.CompressString(tickers.Serialize())
+		e.framesSinceKeyframe = 0
+		e.forceKeyframe = false
+	} else {
+		delta := common.DiffTickersMaps(e.lastSent, tickers)
+		response.Args[common.TICKERS_FRAME_TYPE] = common.TICKERS_FRAME_DELTA
+		response.Args[common.TICKERS_DELTA_SERIALIZED] = common.CompressString(delta.Serialize())
+		e.framesSinceKeyframe++
+	}
+
+	e.lastSent = tickers
+	channelIn<-response.SerializeMessage()
+}
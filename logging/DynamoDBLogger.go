@@ -179,7 +179,7 @@ func genArbStateItem(state *arb.State) *ArbStateItem {
 		state.Triangle.CoinC.CoinSymbol + "->" +
 		state.Triangle.CoinA.CoinSymbol
 
-	lastedForMs := int64(state.LastUpdateTs.Sub(state.StartTs)/time.Millisecond)
+	lastedForMs := int64(state.GetLastUpdateTs().Sub(state.StartTs)/time.Millisecond)
 	return &ArbStateItem{
 		ArbChain: arbChain,
 		QtyBefore: state.QtyBefore,
@@ -190,6 +190,6 @@ func genArbStateItem(state *arb.State) *ArbStateItem {
 		CoinB: state.Triangle.CoinB.CoinSymbol,
 		CoinC: state.Triangle.CoinC.CoinSymbol,
 		StartTs: state.StartTs,
-		EndTs: state.LastUpdateTs,
+		EndTs: state.GetLastUpdateTs(),
 	}
 }
\ No newline at end of file
@@ -9,6 +9,7 @@ import (
 	"time"
 	"midas/common"
 	"encoding/json"
+	"sync"
 )
 
 type EventType string
@@ -16,6 +17,7 @@ type EventType string
 var (
 	EventTypeArbState          = EventType("ARB_STATE")
 	EventTypeOrderStatusChange = EventType("ORDER_STATUS_CHANGE")
+	EventTypeSpatialArbState   = EventType("SPATIAL_ARB_STATE")
 )
 
 type Event struct{
@@ -56,10 +58,29 @@ const (
 	FIELD_BALANCE_A                  = "balance_a"
 	FIELD_BALANCE_B                  = "balance_b"
 	FIELD_BALANCE_C                  = "balance_c"
+	FIELD_PROFIT_IN_REF              = "profit_in_ref"
+	FIELD_REF_ASSET                  = "ref_asset"
+
+	// cycle_arb_states
+	FIELD_NUM_LEGS = "num_legs"
+	FIELD_START_COIN = "start_coin"
+	FIELD_LEGS = "legs"
+
+	// spatial_arb_states
+	FIELD_BUY_EXCHANGE = "buy_exchange"
+	FIELD_SELL_EXCHANGE = "sell_exchange"
+	FIELD_BUY_PRICE = "buy_price"
+	FIELD_SELL_PRICE = "sell_price"
+	FIELD_BUY_FEE = "buy_fee"
+	FIELD_SELL_FEE = "sell_fee"
+	FIELD_QTY = "qty"
+	FIELD_PROFIT_IN_QUOTE = "profit_in_quote"
+	FIELD_INVENTORY_LIMITED = "inventory_limited"
 
 	// order_events
 	FIELD_ORDER_STATUS = "order_status"
 	FIELD_CLIENT_ORDER_ID = "client_order_id"
+	FIELD_ORDER_ID = "order_id"
 	FIELD_SYMBOL = "symbol"
 	FIELD_SIDE = "side"
 	FIELD_TYPE = "type"
@@ -118,6 +139,50 @@ const (
 		FIELD_BALANCE_A + " FLOAT(16, 8)," +
 		FIELD_BALANCE_B + " FLOAT(16, 8)," +
 		FIELD_BALANCE_C + " FLOAT(16, 8)," +
+		FIELD_PROFIT_IN_REF + " FLOAT(16, 8)," +
+		FIELD_REF_ASSET + " VARCHAR(16)," +
+		"PRIMARY KEY (id)" +
+		");"
+
+	// cycle_arb_states
+	TABLE_CYCLE_ARB_STATES_NAME = "cycle_arb_states"
+	CREATE_TABLE_CYCLE_ARB_STATES_QUERY = "CREATE TABLE IF NOT EXISTS " + TABLE_CYCLE_ARB_STATES_NAME + "(" +
+		"id INT(10) NOT NULL AUTO_INCREMENT," +
+		FIELD_ARB_STATE_ID + " VARCHAR(128)," +
+		FIELD_ARB_CHAIN + " VARCHAR(128)," +
+		FIELD_NUM_LEGS + " INT(10)," +
+		FIELD_START_COIN + " VARCHAR(64)," +
+		FIELD_QTY_BEFORE + " FLOAT(16, 8)," +
+		FIELD_QTY_AFTER + " FLOAT(16, 8)," +
+		FIELD_RELATIVE_PROFIT_PERCENTAGE + " FLOAT(16, 8)," +
+		FIELD_LASTED_FOR_MS + " INT(10)," +
+		FIELD_STARTED_AT + " TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP," +
+		FIELD_FINISHED_AT + " TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP," +
+		FIELD_LEGS + " LONGTEXT," +
+		FIELD_PROFIT_IN_REF + " FLOAT(16, 8)," +
+		FIELD_REF_ASSET + " VARCHAR(16)," +
+		"PRIMARY KEY (id)" +
+		");"
+
+	// spatial_arb_states
+	TABLE_SPATIAL_ARB_STATES_NAME = "spatial_arb_states"
+	CREATE_TABLE_SPATIAL_ARB_STATES_QUERY = "CREATE TABLE IF NOT EXISTS " + TABLE_SPATIAL_ARB_STATES_NAME + "(" +
+		"id INT(10) NOT NULL AUTO_INCREMENT," +
+		FIELD_ARB_STATE_ID + " VARCHAR(128)," +
+		FIELD_SYMBOL + " VARCHAR(64)," +
+		FIELD_BUY_EXCHANGE + " VARCHAR(64)," +
+		FIELD_SELL_EXCHANGE + " VARCHAR(64)," +
+		FIELD_BUY_PRICE + " FLOAT(16, 8)," +
+		FIELD_SELL_PRICE + " FLOAT(16, 8)," +
+		FIELD_BUY_FEE + " FLOAT(16, 8)," +
+		FIELD_SELL_FEE + " FLOAT(16, 8)," +
+		FIELD_QTY + " FLOAT(16, 8)," +
+		FIELD_RELATIVE_PROFIT_PERCENTAGE + " FLOAT(16, 8)," +
+		FIELD_PROFIT_IN_QUOTE + " FLOAT(16, 8)," +
+		FIELD_INVENTORY_LIMITED + " BOOLEAN," +
+		FIELD_LASTED_FOR_MS + " INT(10)," +
+		FIELD_STARTED_AT + " TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP," +
+		FIELD_FINISHED_AT + " TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP," +
 		"PRIMARY KEY (id)" +
 		");"
 
@@ -143,6 +208,7 @@ const (
 		FIELD_BALANCE_C + " FLOAT(16, 8)," +
 		FIELD_ERROR_MESSAGE + " LONGTEXT," +
 		FIELD_FILLS + " LONGTEXT," +
+		FIELD_ORDER_ID + " BIGINT," +
 		"PRIMARY KEY (id)" +
 		");"
 )
@@ -180,8 +246,46 @@ const (
 		FIELD_PRICE_AC + "," +
 		FIELD_BALANCE_A + "," +
 		FIELD_BALANCE_B + "," +
-		FIELD_BALANCE_C +
-		") VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+		FIELD_BALANCE_C + "," +
+		FIELD_PROFIT_IN_REF + "," +
+		FIELD_REF_ASSET +
+		") VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+
+	// cycle_arb_states
+	INSERT_CYCLE_ARB_STATE_QUERY = "INSERT INTO " + TABLE_CYCLE_ARB_STATES_NAME + "(" +
+		FIELD_ARB_STATE_ID + "," +
+		FIELD_ARB_CHAIN + "," +
+		FIELD_NUM_LEGS + "," +
+		FIELD_START_COIN + "," +
+		FIELD_QTY_BEFORE + "," +
+		FIELD_QTY_AFTER + "," +
+		FIELD_RELATIVE_PROFIT_PERCENTAGE + "," +
+		FIELD_LASTED_FOR_MS + "," +
+		FIELD_STARTED_AT + "," +
+		FIELD_FINISHED_AT + "," +
+		FIELD_LEGS + "," +
+		FIELD_PROFIT_IN_REF + "," +
+		FIELD_REF_ASSET +
+		") VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)"
+
+	// spatial_arb_states
+	INSERT_SPATIAL_ARB_STATE_QUERY = "INSERT INTO " + TABLE_SPATIAL_ARB_STATES_NAME + "(" +
+		FIELD_ARB_STATE_ID + "," +
+		FIELD_SYMBOL + "," +
+		FIELD_BUY_EXCHANGE + "," +
+		FIELD_SELL_EXCHANGE + "," +
+		FIELD_BUY_PRICE + "," +
+		FIELD_SELL_PRICE + "," +
+		FIELD_BUY_FEE + "," +
+		FIELD_SELL_FEE + "," +
+		FIELD_QTY + "," +
+		FIELD_RELATIVE_PROFIT_PERCENTAGE + "," +
+		FIELD_PROFIT_IN_QUOTE + "," +
+		FIELD_INVENTORY_LIMITED + "," +
+		FIELD_LASTED_FOR_MS + "," +
+		FIELD_STARTED_AT + "," +
+		FIELD_FINISHED_AT +
+		") VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
 
 	// order_events
 	INSERT_ORDER_EVENT_QUERY = "INSERT INTO " + TABLE_ORDER_EVENTS_NAME + "(" +
@@ -201,23 +305,57 @@ const (
 		FIELD_BALANCE_B + "," +
 		FIELD_BALANCE_C + "," +
 		FIELD_ERROR_MESSAGE + "," +
-		FIELD_FILLS +
-		") VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+		FIELD_FILLS + "," +
+		FIELD_ORDER_ID +
+		") VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
 )
 
 var eventQueue = make(chan *Event, EVENT_QUEUE_SIZE)
+// Closed by logging routine once queue is closed and drained
+var eventQueueDone = make(chan struct{})
+var eventQueueClosed = false
+var eventQueueMux sync.RWMutex
 
 func InitMySQLLogger() {
 	createTableIfNotExists(CREATE_TABLE_ARB_STATES_QUERY)
+	createTableIfNotExists(CREATE_TABLE_CYCLE_ARB_STATES_QUERY)
+	createTableIfNotExists(CREATE_TABLE_SPATIAL_ARB_STATES_QUERY)
 	createTableIfNotExists(CREATE_ORDER_EVENTS_QUERY)
+	// Tables created before reference valuation
+	addColumnIfNotExists(TABLE_ARB_STATES_NAME, FIELD_PROFIT_IN_REF, "FLOAT(16, 8)")
+	addColumnIfNotExists(TABLE_ARB_STATES_NAME, FIELD_REF_ASSET, "VARCHAR(16)")
+	addColumnIfNotExists(TABLE_CYCLE_ARB_STATES_NAME, FIELD_PROFIT_IN_REF, "FLOAT(16, 8)")
+	addColumnIfNotExists(TABLE_CYCLE_ARB_STATES_NAME, FIELD_REF_ASSET, "VARCHAR(16)")
+	// Tables created before execution reports
+	addColumnIfNotExists(TABLE_ORDER_EVENTS_NAME, FIELD_ORDER_ID, "BIGINT")
 	startLoggingRoutine()
 }
 
-// Puts arbState in a queue for async logging
+// Puts arbState in a queue for async logging, events queued after flush are dropped
 func QueueEvent(event *Event) {
+	eventQueueMux.RLock()
+	defer eventQueueMux.RUnlock()
+	if eventQueueClosed {
+		log.Println("Event queue is closed, dropping event")
+		return
+	}
 	eventQueue <-event
 }
 
+// Closes queue and blocks until all queued events are recorded.
+// Routines which queue events are expected to be stopped before.
+func FlushEventQueue() {
+	log.Println("Flushing event queue...")
+	eventQueueMux.Lock()
+	if !eventQueueClosed {
+		eventQueueClosed = true
+		close(eventQueue)
+	}
+	eventQueueMux.Unlock()
+	<-eventQueueDone
+	log.Println("Flushing event queue... Done")
+}
+
 func recordOrderStatusChangedEvent(orderEvent *common.OrderStatusChangeEvent) {
 	dbPass := configuration.ReadBrainConfig().MYSQL_PASSWORD
 	db, err := sql.Open(DB_DRIVER, DB_USER + ":" + dbPass + "@tcp(127.0.0.1:3306)/" + DB_NAME)
@@ -247,10 +385,10 @@ func recordOrderStatusChangedEvent(orderEvent *common.OrderStatusChangeEvent) {
 		string(orderEvent.Symbol),
 		string(orderEvent.Side),
 		string(orderEvent.Type),
-		orderEvent.Price,
-		orderEvent.OrigQty,
-		orderEvent.ExecutedQty,
-		orderEvent.CumulativeQuoteQty,
+		orderEvent.Price.Float64(),
+		orderEvent.OrigQty.Float64(),
+		orderEvent.ExecutedQty.Float64(),
+		orderEvent.CumulativeQuoteQty.Float64(),
 		string(orderEvent.TimeInForce),
 		orderEvent.TransactTime.Format(TIMESTAMP_FORMAT),
 		orderEvent.BalanceA,
@@ -258,11 +396,17 @@ func recordOrderStatusChangedEvent(orderEvent *common.OrderStatusChangeEvent) {
 		orderEvent.BalanceC,
 		orderEvent.ErrorMessage,
 		fillsStr,
+		orderEvent.OrderId,
 	)
 	checkErr(err)
 }
 
 func recordArbState(state *arb.State) {
+	if state.Triangle == nil {
+		recordCycleArbState(state)
+		return
+	}
+
 	dbPass := configuration.ReadBrainConfig().MYSQL_PASSWORD
 	db, err := sql.Open(DB_DRIVER, DB_USER + ":" + dbPass + "@tcp(127.0.0.1:3306)/" + DB_NAME)
 	defer db.Close()
@@ -277,13 +421,8 @@ func recordArbState(state *arb.State) {
 		return
 	}
 
-
-
-	arbChain := state.Triangle.CoinA.CoinSymbol + "->" +
-		state.Triangle.CoinB.CoinSymbol + "->" +
-		state.Triangle.CoinC.CoinSymbol + "->" +
-		state.Triangle.CoinA.CoinSymbol
-	lastedForMs := int64(state.LastUpdateTs.Sub(state.StartTs)/time.Millisecond)
+	arbChain := state.Chain()
+	lastedForMs := int64(state.GetLastUpdateTs().Sub(state.StartTs)/time.Millisecond)
 	_, err = stmt.Exec(
 		state.Id,
 		arbChain,
@@ -295,7 +434,7 @@ func recordArbState(state *arb.State) {
 		state.Triangle.CoinB.CoinSymbol,
 		state.Triangle.CoinC.CoinSymbol,
 		state.StartTs.Format(TIMESTAMP_FORMAT),
-		state.LastUpdateTs.Format(TIMESTAMP_FORMAT),
+		state.GetLastUpdateTs().Format(TIMESTAMP_FORMAT),
 		state.GetFrameUpdateCount(),
 		state.Orders["AB"].Symbol,
 		state.Orders["BC"].Symbol,
@@ -303,18 +442,63 @@ func recordArbState(state *arb.State) {
 		string(state.Orders["AB"].Side),
 		string(state.Orders["BC"].Side),
 		string(state.Orders["AC"].Side),
-		state.Orders["AB"].Qty,
-		state.Orders["BC"].Qty,
-		state.Orders["AC"].Qty,
+		state.Orders["AB"].Qty.Float64(),
+		state.Orders["BC"].Qty.Float64(),
+		state.Orders["AC"].Qty.Float64(),
 		state.OrderQtyAB,
 		state.OrderQtyBC,
 		state.OrderQtyAC,
-		state.Orders["AB"].Price,
-		state.Orders["BC"].Price,
-		state.Orders["AC"].Price,
+		state.Orders["AB"].Price.Float64(),
+		state.Orders["BC"].Price.Float64(),
+		state.Orders["AC"].Price.Float64(),
 		state.BalanceA,
 		state.BalanceB,
 		state.BalanceC,
+		state.ProfitInRef,
+		state.RefAsset,
+	)
+	checkErr(err)
+}
+
+func recordCycleArbState(state *arb.State) {
+	dbPass := configuration.ReadBrainConfig().MYSQL_PASSWORD
+	db, err := sql.Open(DB_DRIVER, DB_USER + ":" + dbPass + "@tcp(127.0.0.1:3306)/" + DB_NAME)
+	defer db.Close()
+
+	if checkErr(err) {
+		return
+	}
+
+	stmt, err := db.Prepare(INSERT_CYCLE_ARB_STATE_QUERY)
+
+	if checkErr(err) {
+		return
+	}
+
+	orders := make([]*common.OrderRequest, 0, len(state.Legs))
+	for _, leg := range state.Legs {
+		orders = append(orders, leg.Order)
+	}
+	legs, err := json.Marshal(orders)
+	if checkErr(err) {
+		return
+	}
+
+	lastedForMs := int64(state.GetLastUpdateTs().Sub(state.StartTs)/time.Millisecond)
+	_, err = stmt.Exec(
+		state.Id,
+		state.Chain(),
+		len(state.Legs),
+		state.Cycle.Coins[0].CoinSymbol,
+		state.QtyBefore,
+		state.QtyAfter,
+		state.ProfitRelative * 100.0,
+		lastedForMs,
+		state.StartTs.Format(TIMESTAMP_FORMAT),
+		state.GetLastUpdateTs().Format(TIMESTAMP_FORMAT),
+		string(legs),
+		state.ProfitInRef,
+		state.RefAsset,
 	)
 	checkErr(err)
 }
@@ -344,15 +528,79 @@ func createTableIfNotExists(createTableQuery string) {
 	}
 }
 
+func recordSpatialArbState(state *arb.SpatialState) {
+	dbPass := configuration.ReadBrainConfig().MYSQL_PASSWORD
+	db, err := sql.Open(DB_DRIVER, DB_USER + ":" + dbPass + "@tcp(127.0.0.1:3306)/" + DB_NAME)
+	defer db.Close()
+
+	if checkErr(err) {
+		return
+	}
+
+	stmt, err := db.Prepare(INSERT_SPATIAL_ARB_STATE_QUERY)
+
+	if checkErr(err) {
+		return
+	}
+
+	lastedForMs := int64(state.GetLastUpdateTs().Sub(state.StartTs)/time.Millisecond)
+	_, err = stmt.Exec(
+		state.Id,
+		state.Symbol,
+		state.BuyExchange,
+		state.SellExchange,
+		state.BuyPrice,
+		state.SellPrice,
+		state.BuyFee,
+		state.SellFee,
+		state.Qty,
+		state.ProfitRelative * 100.0,
+		state.ProfitInQuote,
+		state.InventoryLimited,
+		lastedForMs,
+		state.StartTs.Format(TIMESTAMP_FORMAT),
+		state.GetLastUpdateTs().Format(TIMESTAMP_FORMAT),
+	)
+	checkErr(err)
+}
+
+func addColumnIfNotExists(table string, column string, definition string) {
+	dbPass := configuration.ReadBrainConfig().MYSQL_PASSWORD
+	db, err := sql.Open(DB_DRIVER, DB_USER + ":" + dbPass + "@tcp(127.0.0.1:3306)/" + DB_NAME)
+	defer db.Close()
+
+	if err != nil {
+		panic(err)
+	}
+
+	var count int
+	err = db.QueryRow(
+		"SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+		DB_NAME, table, column).Scan(&count)
+	if err != nil {
+		panic(err)
+	}
+	if count > 0 {
+		return
+	}
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func startLoggingRoutine() {
 	go func() {
-		for {
-			event := <-eventQueue
+		defer close(eventQueueDone)
+		for event := range eventQueue {
 			switch event.EventType {
 			case EventTypeArbState:
 				recordArbState(event.Value.(*arb.State))
 			case EventTypeOrderStatusChange:
 				recordOrderStatusChangedEvent(event.Value.(*common.OrderStatusChangeEvent))
+			case EventTypeSpatialArbState:
+				recordSpatialArbState(event.Value.(*arb.SpatialState))
 			default:
 				panic("Unsupported logger type")
 			}
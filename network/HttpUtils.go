@@ -5,16 +5,20 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"midas/common"
 	"midas/configuration"
 	"strconv"
 	"time"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"strings"
+	"sync"
 )
 
-var apiKey = configuration.ReadBrainConfig().API_KEY
-var apiSecret = configuration.ReadBrainConfig().API_SECRET
+// header -> last seen value
+var usedWeightHeaders = make(map[string]string)
+var usedWeightHeadersMux sync.Mutex
 
 func NewHttpRequest(
 	reqType string,
@@ -23,9 +27,22 @@ func NewHttpRequest(
 	useApiKey bool,
 	useSignature bool) ([]byte, error) {
 
+	return NewHttpRequestWithTimeout(reqType, reqUrl, reqData, useApiKey, useSignature, 0)
+}
+
+// Timeout applies to this request only, so eyes sharing the process each keep their own. 0 means no timeout.
+func NewHttpRequestWithTimeout(
+	reqType string,
+	reqUrl string,
+	reqData map[string]string,
+	useApiKey bool,
+	useSignature bool,
+	timeout time.Duration) ([]byte, error) {
+
 	transport := &http.Transport{}
 	client := &http.Client{
 		Transport: transport,
+		Timeout: timeout,
 	}
 
 	req, err := http.NewRequest(reqType, reqUrl, nil)
@@ -38,7 +55,8 @@ func NewHttpRequest(
 	}
 
 	if useApiKey {
-		req.Header.Add("X-MBX-APIKEY", apiKey)
+		// Keys are read on first use, so importing network does not need brain config
+		req.Header.Add("X-MBX-APIKEY", configuration.ReadBrainConfig().API_KEY)
 	}
 
 	if useSignature {
@@ -57,7 +75,7 @@ func NewHttpRequest(
 
 	if useSignature {
 		payload := q.Encode()
-		signature, err := getParamHmacSHA256Sign(apiSecret, payload)
+		signature, err := getParamHmacSHA256Sign(configuration.ReadBrainConfig().API_SECRET, payload)
 		if err != nil {
 			return nil, err
 		}
@@ -73,6 +91,8 @@ func NewHttpRequest(
 
 	defer resp.Body.Close()
 
+	recordUsedWeightHeaders(resp.Header)
+
 	bodyData, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -94,3 +114,26 @@ func getParamHmacSHA256Sign(secret, params string) (string, error) {
 	return hex.EncodeToString(mac.Sum(nil)), nil
 }
 
+
+func recordUsedWeightHeaders(header http.Header) {
+	usedWeightHeadersMux.Lock()
+	defer usedWeightHeadersMux.Unlock()
+	for key := range header {
+		// Binance reports weight used by our IP in headers like X-MBX-USED-WEIGHT-1M
+		if strings.HasPrefix(key, common.USED_WEIGHT_HEADER) {
+			usedWeightHeaders[key] = header.Get(key)
+		}
+	}
+}
+
+// Returns copy of last seen used weight headers
+func GetUsedWeightHeaders() map[string]string {
+	usedWeightHeadersMux.Lock()
+	defer usedWeightHeadersMux.Unlock()
+	headers := make(map[string]string)
+	for key, value := range usedWeightHeaders {
+		headers[key] = value
+	}
+
+	return headers
+}
\ No newline at end of file
@@ -8,7 +8,7 @@
 
 cd ~/go/projects/src/midas
 git pull
-/usr/local/go/bin/go install midas/execs/eye_exec
+/usr/local/go/bin/go install -ldflags "-X midas/eyes.Version=$(git rev-parse --short HEAD)" midas/execs/eye_exec
 cd ~/go/projects/bin
 pid=$(pgrep eye_exec)
 if [ ! -z $pid ]; then